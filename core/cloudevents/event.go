@@ -0,0 +1,429 @@
+// Package cloudevents implements the CloudEvents 1.0 binary and structured
+// content modes, shared by the transports of gkit.
+package cloudevents
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"sort"
+	"strings"
+	"time"
+
+	gkit "github.com/kikihakiem/gkit/core"
+)
+
+const (
+	// SpecVersion is the version of the CloudEvents specification implemented
+	// by Event.
+	SpecVersion = "1.0"
+
+	// ContentType is the media type of events in structured content mode.
+	ContentType = "application/cloudevents+json"
+
+	// HeaderPrefix prefixes the headers carrying the attributes of events in
+	// binary content mode, e.g. ce-id.
+	HeaderPrefix = "ce-"
+)
+
+// ErrInvalid is returned when an event lacks required attributes or has
+// malformed ones.
+var ErrInvalid = errors.New("cloudevents: invalid event")
+
+// Mode tells how an event is carried by a message.
+type Mode int
+
+const (
+	// Binary carries the attributes of the event in headers, and its data as
+	// the body of the message.
+	Binary Mode = iota
+
+	// Structured carries the whole event as a JSON object in the body of the
+	// message.
+	Structured
+)
+
+// Attributes are the context attributes of a CloudEvent.
+type Attributes struct {
+	ID              string
+	Source          string
+	SpecVersion     string
+	Type            string
+	Subject         string
+	Time            time.Time
+	DataContentType string
+	DataSchema      string
+
+	// Extensions are the extension attributes, by lowercase name.
+	Extensions map[string]string
+}
+
+// Event is a CloudEvent carrying data of type T. Data is serialized as JSON,
+// unless T is []byte, in which case it is passed as is.
+type Event[T any] struct {
+	Attributes
+	Data T
+}
+
+// New constructs an event of the given source and type, with a random ID
+// and the current time.
+func New[T any](source, eventType string, data T) Event[T] {
+	var id [16]byte
+	rand.Read(id[:]) //nolint:errcheck
+
+	// format the ID as a version 4 UUID.
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+
+	e := Event[T]{
+		Attributes: Attributes{
+			ID:          fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:]),
+			Source:      source,
+			SpecVersion: SpecVersion,
+			Type:        eventType,
+			Time:        time.Now().UTC(),
+		},
+		Data: data,
+	}
+
+	if !e.isBinary() {
+		e.DataContentType = "application/json"
+	}
+
+	return e
+}
+
+// Validate checks that the required attributes are set, and that the names of
+// the extensions are valid.
+func (a Attributes) Validate() error {
+	for _, required := range []struct{ name, value string }{
+		{"id", a.ID},
+		{"source", a.Source},
+		{"specversion", a.SpecVersion},
+		{"type", a.Type},
+	} {
+		if required.value == "" {
+			return fmt.Errorf("%w: missing %s", ErrInvalid, required.name)
+		}
+	}
+
+	if a.SpecVersion != SpecVersion {
+		return fmt.Errorf("%w: unsupported specversion %q", ErrInvalid, a.SpecVersion)
+	}
+
+	names := make([]string, 0, len(a.Extensions))
+	for name := range a.Extensions {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !validExtensionName(name) {
+			return fmt.Errorf("%w: invalid extension name %q", ErrInvalid, name)
+		}
+	}
+
+	return nil
+}
+
+// Encode serializes the event for the given content mode, returning the
+// headers and body of the message carrying it. Header names are lowercase.
+func Encode[T any](e Event[T], mode Mode) (map[string]string, []byte, error) {
+	if err := e.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	if mode == Structured {
+		body, err := json.Marshal(e)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return map[string]string{"content-type": ContentType}, body, nil
+	}
+
+	header := make(map[string]string)
+	for name, value := range e.attributes() {
+		if name == "datacontenttype" {
+			header["content-type"] = value
+			continue
+		}
+
+		header[HeaderPrefix+name] = value
+	}
+
+	body, err := e.marshalData()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return header, body, nil
+}
+
+// Decode deserializes an event from the headers and body of a message,
+// in either content mode. Header names are matched case-insensitively.
+func Decode[T any](header map[string][]string, body []byte) (Event[T], error) {
+	var e Event[T]
+
+	contentType := headerValue(header, "content-type")
+
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == ContentType {
+		if err := json.Unmarshal(body, &e); err != nil {
+			return e, err
+		}
+
+		return e, e.Validate()
+	}
+
+	attributes := make(map[string]string)
+	for name, values := range header {
+		if len(values) == 0 || !strings.HasPrefix(strings.ToLower(name), HeaderPrefix) {
+			continue
+		}
+
+		attributes[strings.ToLower(name[len(HeaderPrefix):])] = values[0]
+	}
+
+	if contentType != "" {
+		attributes["datacontenttype"] = contentType
+	}
+
+	if err := e.setAttributes(attributes); err != nil {
+		return e, err
+	}
+
+	if err := e.Validate(); err != nil {
+		return e, err
+	}
+
+	return e, e.unmarshalData(body)
+}
+
+// MarshalJSON implements json.Marshaler, serializing the event in the
+// structured content mode JSON format.
+func (e Event[T]) MarshalJSON() ([]byte, error) {
+	object := make(map[string]any)
+	for name, value := range e.attributes() {
+		object[name] = value
+	}
+
+	data, err := e.marshalData()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case e.isBinary():
+		object["data_base64"] = base64.StdEncoding.EncodeToString(data)
+	default:
+		object["data"] = json.RawMessage(data)
+	}
+
+	return json.Marshal(object)
+}
+
+// UnmarshalJSON implements json.Unmarshaler, deserializing an event in the
+// structured content mode JSON format.
+func (e *Event[T]) UnmarshalJSON(b []byte) error {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(b, &object); err != nil {
+		return err
+	}
+
+	attributes := make(map[string]string)
+
+	for name, raw := range object {
+		if name == "data" || name == "data_base64" {
+			continue
+		}
+
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			value = string(raw) // non-string extension, e.g. a number or boolean.
+		}
+
+		attributes[name] = value
+	}
+
+	if err := e.setAttributes(attributes); err != nil {
+		return err
+	}
+
+	if raw, ok := object["data_base64"]; ok {
+		var encoded string
+		if err := json.Unmarshal(raw, &encoded); err != nil {
+			return err
+		}
+
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return err
+		}
+
+		return e.unmarshalData(data)
+	}
+
+	if raw, ok := object["data"]; ok {
+		if data, ok := any(&e.Data).(*[]byte); ok {
+			// binary data in a JSON value: strings are unquoted, other
+			// values, e.g. objects, are kept as JSON.
+			var text string
+			if err := json.Unmarshal(raw, &text); err == nil {
+				*data = []byte(text)
+			} else {
+				*data = raw
+			}
+
+			return nil
+		}
+
+		return json.Unmarshal(raw, &e.Data)
+	}
+
+	return nil
+}
+
+// attributes returns the attributes that are set, by name.
+func (a Attributes) attributes() map[string]string {
+	attributes := make(map[string]string, 8+len(a.Extensions))
+
+	for name, value := range a.Extensions {
+		attributes[name] = value
+	}
+
+	for name, value := range map[string]string{
+		"id":              a.ID,
+		"source":          a.Source,
+		"specversion":     a.SpecVersion,
+		"type":            a.Type,
+		"subject":         a.Subject,
+		"datacontenttype": a.DataContentType,
+		"dataschema":      a.DataSchema,
+	} {
+		if value != "" {
+			attributes[name] = value
+		}
+	}
+
+	if !a.Time.IsZero() {
+		attributes["time"] = a.Time.Format(time.RFC3339Nano)
+	}
+
+	return attributes
+}
+
+func (a *Attributes) setAttributes(attributes map[string]string) error {
+	for name, value := range attributes {
+		switch name {
+		case "id":
+			a.ID = value
+		case "source":
+			a.Source = value
+		case "specversion":
+			a.SpecVersion = value
+		case "type":
+			a.Type = value
+		case "subject":
+			a.Subject = value
+		case "datacontenttype":
+			a.DataContentType = value
+		case "dataschema":
+			a.DataSchema = value
+		case "time":
+			t, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				return fmt.Errorf("%w: malformed time: %w", ErrInvalid, err)
+			}
+
+			a.Time = t
+		default:
+			if a.Extensions == nil {
+				a.Extensions = make(map[string]string)
+			}
+
+			a.Extensions[name] = value
+		}
+	}
+
+	return nil
+}
+
+func (e Event[T]) isBinary() bool {
+	_, ok := any(e.Data).([]byte)
+	return ok
+}
+
+func (e Event[T]) marshalData() ([]byte, error) {
+	if data, ok := any(e.Data).([]byte); ok {
+		return data, nil
+	}
+
+	return json.Marshal(e.Data)
+}
+
+func (e *Event[T]) unmarshalData(body []byte) error {
+	if data, ok := any(&e.Data).(*[]byte); ok {
+		*data = body
+		return nil
+	}
+
+	if len(body) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(body, &e.Data)
+}
+
+func validExtensionName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func headerValue(header map[string][]string, name string) string {
+	for k, values := range header {
+		if strings.EqualFold(k, name) && len(values) > 0 {
+			return values[0]
+		}
+	}
+
+	return ""
+}
+
+type contextKey int
+
+// contextKeyAttributes holds the attributes of the event being handled.
+const contextKeyAttributes contextKey = iota
+
+// NewContext returns a copy of ctx carrying the attributes of an event.
+func NewContext(ctx context.Context, attributes Attributes) context.Context {
+	return context.WithValue(ctx, contextKeyAttributes, attributes)
+}
+
+// FromContext returns the attributes of the event carried by ctx, e.g. its ID,
+// source, type, subject and time.
+func FromContext(ctx context.Context) (Attributes, bool) {
+	attributes, ok := ctx.Value(contextKeyAttributes).(Attributes)
+	return attributes, ok
+}
+
+// Endpoint adapts an endpoint taking the data of events into one taking whole
+// events, exposing their attributes in the context.
+func Endpoint[T, Res any](e gkit.Endpoint[T, Res]) gkit.Endpoint[Event[T], Res] {
+	return func(ctx context.Context, event Event[T]) (Res, error) {
+		return e(NewContext(ctx, event.Attributes), event.Data)
+	}
+}
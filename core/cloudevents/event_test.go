@@ -0,0 +1,261 @@
+//go:build unit
+
+package cloudevents_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kikihakiem/gkit/core/cloudevents"
+)
+
+type greeting struct {
+	Name string `json:"name"`
+}
+
+func TestValidateOrder(t *testing.T) {
+	attributes := cloudevents.Attributes{
+		Source:      "/people",
+		SpecVersion: cloudevents.SpecVersion,
+		Extensions:  map[string]string{"Bad": "x", "b-ad": "y"},
+	}
+
+	for i := 0; i < 10; i++ {
+		err := attributes.Validate()
+		if !errors.Is(err, cloudevents.ErrInvalid) {
+			t.Fatalf("want %v, have %v", cloudevents.ErrInvalid, err)
+		}
+
+		if want, have := "cloudevents: invalid event: missing id", err.Error(); want != have {
+			t.Fatalf("want %q, have %q", want, have)
+		}
+	}
+
+	attributes.ID, attributes.Type = "e-1", "com.example.arrived"
+
+	for i := 0; i < 10; i++ {
+		err := attributes.Validate()
+		if want, have := `cloudevents: invalid event: invalid extension name "Bad"`, err.Error(); want != have {
+			t.Fatalf("want %q, have %q", want, have)
+		}
+	}
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	event := cloudevents.New("/people", "com.example.arrived", greeting{Name: "bob"})
+	event.Subject = "bob"
+	event.Extensions = map[string]string{"traceparent": "00-abc"}
+
+	header, body, err := cloudevents.Encode(event, cloudevents.Binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := event.ID, header["ce-id"]; want != have {
+		t.Errorf("want ce-id %q, have %q", want, have)
+	}
+
+	if want, have := "application/json", header["content-type"]; want != have {
+		t.Errorf("want content-type %q, have %q", want, have)
+	}
+
+	multi := make(map[string][]string, len(header))
+	for k, v := range header {
+		multi[k] = []string{v}
+	}
+
+	decoded, err := cloudevents.Decode[greeting](multi, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := event.ID, decoded.ID; want != have {
+		t.Errorf("want id %q, have %q", want, have)
+	}
+
+	if want, have := "bob", decoded.Subject; want != have {
+		t.Errorf("want subject %q, have %q", want, have)
+	}
+
+	if want, have := "00-abc", decoded.Extensions["traceparent"]; want != have {
+		t.Errorf("want traceparent %q, have %q", want, have)
+	}
+
+	if !event.Time.Equal(decoded.Time) {
+		t.Errorf("want time %v, have %v", event.Time, decoded.Time)
+	}
+
+	if want, have := "bob", decoded.Data.Name; want != have {
+		t.Errorf("want data name %q, have %q", want, have)
+	}
+}
+
+func TestStructuredRoundTrip(t *testing.T) {
+	event := cloudevents.New("/people", "com.example.arrived", greeting{Name: "bob"})
+
+	header, body, err := cloudevents.Encode(event, cloudevents.Structured)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := cloudevents.ContentType, header["content-type"]; want != have {
+		t.Errorf("want content-type %q, have %q", want, have)
+	}
+
+	decoded, err := cloudevents.Decode[greeting](map[string][]string{"Content-Type": {cloudevents.ContentType}}, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := event.ID, decoded.ID; want != have {
+		t.Errorf("want id %q, have %q", want, have)
+	}
+
+	if want, have := "bob", decoded.Data.Name; want != have {
+		t.Errorf("want data name %q, have %q", want, have)
+	}
+}
+
+func TestBytesStringDataRoundTrip(t *testing.T) {
+	structured := []byte(`{"specversion":"1.0","id":"e-1","source":"/s","type":"t","datacontenttype":"text/plain","data":"hello"}`)
+
+	event, err := cloudevents.Decode[[]byte](map[string][]string{"Content-Type": {cloudevents.ContentType}}, structured)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := "hello", string(event.Data); want != have {
+		t.Fatalf("want data %q, have %q", want, have)
+	}
+
+	for _, mode := range []cloudevents.Mode{cloudevents.Binary, cloudevents.Structured} {
+		header, body, err := cloudevents.Encode(event, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		multi := make(map[string][]string, len(header))
+		for k, v := range header {
+			multi[k] = []string{v}
+		}
+
+		decoded, err := cloudevents.Decode[[]byte](multi, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, have := "hello", string(decoded.Data); want != have {
+			t.Errorf("mode %d: want data %q, have %q", mode, want, have)
+		}
+
+		if want, have := "text/plain", decoded.DataContentType; want != have {
+			t.Errorf("mode %d: want datacontenttype %q, have %q", mode, want, have)
+		}
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	t.Run("data_base64", func(t *testing.T) {
+		var event cloudevents.Event[[]byte]
+
+		err := event.UnmarshalJSON([]byte(`{"specversion":"1.0","id":"e-1","source":"/s","type":"t","data_base64":"aGVsbG8="}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, have := "hello", string(event.Data); want != have {
+			t.Errorf("want data %q, have %q", want, have)
+		}
+	})
+
+	t.Run("non-string extension", func(t *testing.T) {
+		var event cloudevents.Event[greeting]
+
+		err := event.UnmarshalJSON([]byte(`{"specversion":"1.0","id":"e-1","source":"/s","type":"t","priority":3,"urgent":true}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, have := "3", event.Extensions["priority"]; want != have {
+			t.Errorf("want priority %q, have %q", want, have)
+		}
+
+		if want, have := "true", event.Extensions["urgent"]; want != have {
+			t.Errorf("want urgent %q, have %q", want, have)
+		}
+	})
+
+	t.Run("bytes data", func(t *testing.T) {
+		var event cloudevents.Event[[]byte]
+
+		err := event.UnmarshalJSON([]byte(`{"specversion":"1.0","id":"e-1","source":"/s","type":"t","data":"hi"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, have := "hi", string(event.Data); want != have {
+			t.Errorf("want data %q, have %q", want, have)
+		}
+	})
+
+	t.Run("bytes object data", func(t *testing.T) {
+		var event cloudevents.Event[[]byte]
+
+		err := event.UnmarshalJSON([]byte(`{"specversion":"1.0","id":"e-1","source":"/s","type":"t","data":{"name":"bob"}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, have := `{"name":"bob"}`, string(event.Data); want != have {
+			t.Errorf("want data %s, have %s", want, have)
+		}
+	})
+
+	t.Run("time", func(t *testing.T) {
+		var event cloudevents.Event[greeting]
+
+		err := event.UnmarshalJSON([]byte(`{"specversion":"1.0","id":"e-1","source":"/s","type":"t","time":"2024-05-01T10:00:00Z"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, have := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), event.Time; !want.Equal(have) {
+			t.Errorf("want time %v, have %v", want, have)
+		}
+	})
+
+	t.Run("malformed time", func(t *testing.T) {
+		var event cloudevents.Event[greeting]
+
+		err := event.UnmarshalJSON([]byte(`{"specversion":"1.0","id":"e-1","source":"/s","type":"t","time":"yesterday"}`))
+		if !errors.Is(err, cloudevents.ErrInvalid) {
+			t.Errorf("want %v, have %v", cloudevents.ErrInvalid, err)
+		}
+	})
+}
+
+func TestEndpoint(t *testing.T) {
+	var received cloudevents.Attributes
+
+	endpoint := cloudevents.Endpoint(func(ctx context.Context, g greeting) (string, error) {
+		received, _ = cloudevents.FromContext(ctx)
+		return "hello " + g.Name, nil
+	})
+
+	event := cloudevents.New("/people", "com.example.arrived", greeting{Name: "bob"})
+
+	res, err := endpoint(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := "hello bob", res; want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+
+	if want, have := event.ID, received.ID; want != have {
+		t.Errorf("want id %q in context, have %q", want, have)
+	}
+}
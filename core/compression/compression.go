@@ -0,0 +1,164 @@
+// Package compression implements the compression formats shared by the
+// transports of gkit, with a bound on the size of decompressed data.
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultMaxSize is the default maximum size in bytes of decompressed data.
+const DefaultMaxSize = 16 << 20
+
+var (
+	// ErrUnsupportedEncoding is returned for data compressed with an unknown
+	// encoding.
+	ErrUnsupportedEncoding = errors.New("compression: unsupported encoding")
+
+	// ErrTooLarge is returned when decompressed data exceeds the maximum size.
+	ErrTooLarge = errors.New("compression: decompressed data too large")
+)
+
+// Encoding is a compression format, as named in Content-Encoding headers.
+type Encoding string
+
+const (
+	// Gzip is the gzip format.
+	Gzip Encoding = "gzip"
+
+	// Zstd is the Zstandard format.
+	Zstd Encoding = "zstd"
+
+	// S2 is the S2 stream format, an extension of Snappy trading some
+	// compression ratio for speed.
+	S2 Encoding = "s2"
+)
+
+// Supported reports whether data can be compressed with the encoding.
+func (e Encoding) Supported() bool {
+	switch e {
+	case Gzip, Zstd, S2:
+		return true
+	default:
+		return false
+	}
+}
+
+// Compress returns data compressed with the encoding.
+func (e Encoding) Compress(data []byte) ([]byte, error) {
+	var (
+		buf bytes.Buffer
+		w   io.WriteCloser
+	)
+
+	switch e {
+	case Gzip:
+		w = gzip.NewWriter(&buf)
+	case Zstd:
+		zw, err := zstd.NewWriter(&buf, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+
+		w = zw
+	case S2:
+		w = s2.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedEncoding, e)
+	}
+
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Decompress returns data decompressed with the encoding. It fails with
+// ErrTooLarge when the decompressed data exceeds maxSize bytes.
+func (e Encoding) Decompress(data []byte, maxSize int64) ([]byte, error) {
+	r, err := e.NewReader(bytes.NewReader(data), maxSize)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// NewReader returns a reader decompressing r with the encoding. Reading fails
+// with ErrTooLarge once more than maxSize bytes are decompressed. Closing it
+// releases the decompressor, but doesn't close r.
+func (e Encoding) NewReader(r io.Reader, maxSize int64) (io.ReadCloser, error) {
+	switch e {
+	case Gzip:
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+
+		return &limitedReader{r: gr, n: maxSize, close: func() { gr.Close() }}, nil
+	case Zstd:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(uint64(maxSize)))
+		if err != nil {
+			return nil, err
+		}
+
+		return &limitedReader{r: zr, n: maxSize, close: zr.Close}, nil
+	case S2:
+		return &limitedReader{r: s2.NewReader(r), n: maxSize, close: func() {}}, nil
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedEncoding, e)
+	}
+}
+
+// limitedReader reads up to n bytes from r, and fails with ErrTooLarge when r
+// has more.
+type limitedReader struct {
+	r     io.Reader
+	n     int64
+	close func()
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		// read a single byte to tell the end of data from an excess.
+		var b [1]byte
+		if n, err := l.r.Read(b[:]); n > 0 || errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+			return 0, ErrTooLarge
+		} else if err != nil {
+			return 0, err
+		}
+
+		return 0, nil
+	}
+
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+		err = ErrTooLarge
+	}
+
+	return n, err
+}
+
+func (l *limitedReader) Close() error {
+	l.close()
+	return nil
+}
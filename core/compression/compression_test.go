@@ -0,0 +1,54 @@
+//go:build unit
+
+package compression_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/kikihakiem/gkit/core/compression"
+)
+
+func TestDecompressMaxSize(t *testing.T) {
+	data := bytes.Repeat([]byte("a"), 4096)
+
+	for _, encoding := range []compression.Encoding{compression.Gzip, compression.Zstd, compression.S2} {
+		t.Run(string(encoding), func(t *testing.T) {
+			compressed, err := encoding.Compress(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			have, err := encoding.Decompress(compressed, int64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(data, have) {
+				t.Errorf("want %d bytes, have %d", len(data), len(have))
+			}
+
+			_, err = encoding.Decompress(compressed, int64(len(data)-1))
+			if !errors.Is(err, compression.ErrTooLarge) {
+				t.Errorf("want %v, have %v", compression.ErrTooLarge, err)
+			}
+		})
+	}
+}
+
+func TestUnsupportedEncoding(t *testing.T) {
+	encoding := compression.Encoding("br")
+
+	if encoding.Supported() {
+		t.Errorf("want %q unsupported", encoding)
+	}
+
+	if _, err := encoding.Compress([]byte("a")); !errors.Is(err, compression.ErrUnsupportedEncoding) {
+		t.Errorf("want %v, have %v", compression.ErrUnsupportedEncoding, err)
+	}
+
+	if _, err := encoding.Decompress([]byte("a"), compression.DefaultMaxSize); !errors.Is(err, compression.ErrUnsupportedEncoding) {
+		t.Errorf("want %v, have %v", compression.ErrUnsupportedEncoding, err)
+	}
+}
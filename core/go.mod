@@ -1,3 +1,5 @@
 module github.com/kikihakiem/gkit/core
 
 go 1.21.6
+
+require github.com/klauspost/compress v1.17.5
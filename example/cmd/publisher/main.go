@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"log/slog"
 	"os"
 
+	"github.com/kikihakiem/gkit/core/cloudevents"
+	"github.com/kikihakiem/gkit/example/internal/audit"
+	"github.com/kikihakiem/gkit/example/internal/transport"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 )
@@ -19,6 +23,10 @@ func main() {
 	natsURL := os.Args[1]
 	payload := os.Args[2]
 
+	// publish the payload as a CloudEvent when asked to, e.g.
+	// publisher nats://127.0.0.1:4222 '{"action":"read"}' cloudevent
+	asCloudEvent := len(os.Args) > 3 && os.Args[3] == "cloudevent"
+
 	slog.Info("publishing a message to NATS server", slog.String("url", natsURL), slog.String("payload", payload))
 
 	nc, err := nats.Connect(natsURL)
@@ -35,5 +43,22 @@ func main() {
 		return
 	}
 
-	js.Publish(context.Background(), "events.create", []byte(payload))
+	if !asCloudEvent {
+		js.Publish(context.Background(), "events.create", []byte(payload))
+
+		return
+	}
+
+	var event audit.Event
+	if err := json.Unmarshal([]byte(payload), &event); err != nil {
+		slog.Error("failed to parse payload", slog.String("error", err.Error()))
+
+		return
+	}
+
+	publish := transport.NewCloudEventJetstreamPublisher(js, "events.cloudevents").Endpoint()
+
+	if _, err := publish(context.Background(), cloudevents.New("/example/publisher", "com.example.audit.created", event)); err != nil {
+		slog.Error("failed to publish CloudEvent", slog.String("error", err.Error()))
+	}
 }
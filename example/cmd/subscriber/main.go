@@ -2,20 +2,26 @@ package main
 
 import (
 	"context"
+	_ "embed"
 	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/kikihakiem/gkit/example/internal/audit"
 	"github.com/kikihakiem/gkit/example/internal/repository"
 	"github.com/kikihakiem/gkit/example/internal/transport"
+	jstransport "github.com/kikihakiem/gkit/transport/jetstream"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 
 	natsserver "github.com/nats-io/nats-server/v2/test"
 )
 
+//go:embed provision.yaml
+var provisionSpec []byte
+
 func main() {
 	eventRepo := repository.NewEventRepositroy()
 	eventSvc := audit.NewEventService(eventRepo)
@@ -43,26 +49,34 @@ func main() {
 
 	ctx := context.Background()
 
-	stream, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
-		Name:     "events:create",
-		Subjects: []string{"events.create"},
-	})
+	spec, err := jstransport.ParseProvisionSpec(provisionSpec)
 	if err != nil {
-		slog.ErrorContext(ctx, "failed to subscribe to tasks stream", slog.String("error", err.Error()))
+		slog.ErrorContext(ctx, "failed to parse provision spec", slog.String("error", err.Error()))
 
 		return
 	}
 
-	consumer, err := stream.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
-		Durable: "auditEvent",
-	})
+	report, err := jstransport.NewProvisioner(js).Apply(ctx, spec)
 	if err != nil {
-		slog.ErrorContext(ctx, "failed to create or update consumer", slog.String("error", err.Error()))
+		slog.ErrorContext(ctx, "failed to provision streams and consumers", slog.String("error", err.Error()))
 
 		return
 	}
 
-	consumeContext, err := consumer.Consume(transport.CreateEventJetstreamHandler(js, eventSvc))
+	slog.InfoContext(ctx, "provisioned streams and consumers", slog.String("report", report.String()))
+
+	subscriber := transport.CreateEventJetstreamSubscriber(eventSvc)
+
+	consumeContext, err := subscriber.Bind(ctx, js, "events:create", "auditEvent")
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to start consumer", slog.String("error", err.Error()))
+
+		return
+	}
+
+	cloudEventSubscriber := transport.CreateCloudEventJetstreamSubscriber(eventSvc)
+
+	cloudEventConsumeContext, err := cloudEventSubscriber.Bind(ctx, js, "events:cloudevents", "auditCloudEvent")
 	if err != nil {
 		slog.ErrorContext(ctx, "failed to start consumer", slog.String("error", err.Error()))
 
@@ -74,4 +88,16 @@ func main() {
 	sig := <-sigChannel
 	slog.InfoContext(ctx, "received OS signal. Exiting...", slog.String("signal", sig.String()))
 	consumeContext.Stop()
+	cloudEventConsumeContext.Stop()
+
+	drainCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if err := subscriber.Drain(drainCtx); err != nil {
+		slog.ErrorContext(ctx, "failed to drain subscriber", slog.String("error", err.Error()))
+	}
+
+	if err := cloudEventSubscriber.Drain(drainCtx); err != nil {
+		slog.ErrorContext(ctx, "failed to drain subscriber", slog.String("error", err.Error()))
+	}
 }
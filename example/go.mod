@@ -4,9 +4,9 @@ go 1.21.6
 
 require (
 	github.com/go-chi/chi/v5 v5.0.12
-	github.com/kikihakiem/gkit/core v0.4.0
-	github.com/kikihakiem/gkit/transport/http v0.4.0
-	github.com/kikihakiem/gkit/transport/jetstream v0.3.0
+	github.com/kikihakiem/gkit/core v0.5.0
+	github.com/kikihakiem/gkit/transport/http v0.6.0
+	github.com/kikihakiem/gkit/transport/jetstream v0.4.0
 	github.com/nats-io/nats-server/v2 v2.10.10
 	github.com/nats-io/nats.go v1.32.0
 	github.com/nokusukun/bingo v0.3.1
@@ -33,4 +33,11 @@ require (
 	golang.org/x/sys v0.16.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
 	golang.org/x/time v0.5.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace (
+	github.com/kikihakiem/gkit/core => ../core
+	github.com/kikihakiem/gkit/transport/http => ../transport/http
+	github.com/kikihakiem/gkit/transport/jetstream => ../transport/jetstream
 )
@@ -1,10 +1,12 @@
 package transport
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
 	gkit "github.com/kikihakiem/gkit/core"
+	"github.com/kikihakiem/gkit/core/cloudevents"
 	"github.com/kikihakiem/gkit/example/internal/audit"
 	httptransport "github.com/kikihakiem/gkit/transport/http"
 )
@@ -17,6 +19,16 @@ func createEventHTTPHandler(eventSvc *audit.EventService) http.Handler {
 	)
 }
 
+func createCloudEventHTTPHandler(eventSvc *audit.EventService) http.Handler {
+	return httptransport.NewServer(
+		cloudevents.Endpoint(func(ctx context.Context, event audit.Event) (audit.CreateEventResponse, error) {
+			return eventSvc.CreateEvent(ctx, audit.CreateEventRequest{Event: event})
+		}),
+		httptransport.DecodeCloudEventRequest[audit.Event],
+		httptransport.EncodeJSONResponse,
+	)
+}
+
 func getEventsHTTPHandler(eventSvc *audit.EventService) http.Handler {
 	return httptransport.NewServer(
 		eventSvc.GetList,
@@ -29,5 +41,6 @@ func EventRoutes(eventSvc *audit.EventService) func(r chi.Router) {
 	return func(r chi.Router) {
 		r.Method(http.MethodPost, "/", createEventHTTPHandler(eventSvc))
 		r.Method(http.MethodGet, "/", getEventsHTTPHandler(eventSvc))
+		r.Method(http.MethodPost, "/cloudevents", createCloudEventHTTPHandler(eventSvc))
 	}
 }
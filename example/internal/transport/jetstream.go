@@ -1,16 +1,45 @@
 package transport
 
 import (
+	"context"
+
 	gkit "github.com/kikihakiem/gkit/core"
+	"github.com/kikihakiem/gkit/core/cloudevents"
 	"github.com/kikihakiem/gkit/example/internal/audit"
 	jstransport "github.com/kikihakiem/gkit/transport/jetstream"
 	"github.com/nats-io/nats.go/jetstream"
 )
 
-func CreateEventJetstreamHandler(js jetstream.JetStream, svc *audit.EventService) jetstream.MessageHandler {
+func CreateEventJetstreamSubscriber(svc *audit.EventService) *jstransport.Subscriber[audit.CreateEventRequest, audit.CreateEventResponse] {
 	return jstransport.NewSubscriber(
 		svc.CreateEvent,
 		jstransport.DecodeJSONRequest,
 		gkit.NopResponseEncoder,
-	).HandleMessage(js)
+	)
+}
+
+// CreateCloudEventJetstreamSubscriber consumes audit events published as
+// CloudEvents, in either content mode.
+func CreateCloudEventJetstreamSubscriber(svc *audit.EventService) *jstransport.Subscriber[cloudevents.Event[audit.Event], audit.CreateEventResponse] {
+	return jstransport.NewSubscriber(
+		cloudevents.Endpoint(func(ctx context.Context, event audit.Event) (audit.CreateEventResponse, error) {
+			return svc.CreateEvent(ctx, audit.CreateEventRequest{Event: event})
+		}),
+		jstransport.DecodeCloudEvent[audit.Event],
+		gkit.NopResponseEncoder,
+	)
+}
+
+// NewCloudEventJetstreamPublisher publishes audit events as binary mode
+// CloudEvents to subject.
+func NewCloudEventJetstreamPublisher(js jetstream.JetStream, subject string) *jstransport.Publisher[cloudevents.Event[audit.Event], struct{}] {
+	return jstransport.NewPublisher(
+		js,
+		jstransport.EncodeCloudEvent[audit.Event](subject, cloudevents.Binary),
+		gkit.NopEncoderDecoder[*jetstream.PubAck, struct{}],
+	)
+}
+
+func CreateEventJetstreamHandler(js jetstream.JetStream, svc *audit.EventService) jetstream.MessageHandler {
+	return CreateEventJetstreamSubscriber(svc).HandleMessage(js)
 }
@@ -0,0 +1,48 @@
+package echo
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/kikihakiem/gkit/core/cloudevents"
+)
+
+// DecodeCloudEvent is a DecodeRequestFunc that deserializes an event from the
+// request, in either CloudEvents content mode. Combined with
+// cloudevents.Endpoint, the attributes of the event are exposed in the context.
+func DecodeCloudEvent[T any](_ context.Context, c echo.Context) (cloudevents.Event[T], error) {
+	defer c.Request().Body.Close()
+
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return cloudevents.Event[T]{}, err
+	}
+
+	return cloudevents.Decode[T](c.Request().Header, body)
+}
+
+// EncodeCloudEventResponse returns an EncodeResponseFunc that serializes events
+// to the response, in the given CloudEvents content mode. In binary mode, the
+// attributes of the event are mapped to Ce-* headers, e.g. Ce-Id, and its data
+// content type to the Content-Type header.
+func EncodeCloudEventResponse[T any](mode cloudevents.Mode) EncodeResponseFunc[cloudevents.Event[T]] {
+	return func(_ context.Context, c echo.Context, event cloudevents.Event[T]) error {
+		header, body, err := cloudevents.Encode(event, mode)
+		if err != nil {
+			return err
+		}
+
+		for k, v := range header {
+			c.Response().Header().Set(k, v)
+		}
+
+		c.Response().WriteHeader(http.StatusOK)
+
+		_, err = c.Response().Write(body)
+
+		return err
+	}
+}
@@ -0,0 +1,59 @@
+//go:build unit
+
+package echo_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kikihakiem/gkit/core/cloudevents"
+	echotransport "github.com/kikihakiem/gkit/transport/echo"
+	"github.com/labstack/echo/v4"
+)
+
+type greeting struct {
+	Name string `json:"name"`
+}
+
+func TestCloudEvents(t *testing.T) {
+	var received cloudevents.Attributes
+
+	handlerFunc := echotransport.NewHandlerFunc(
+		cloudevents.Endpoint(func(ctx context.Context, g greeting) (cloudevents.Event[greeting], error) {
+			received, _ = cloudevents.FromContext(ctx)
+			return cloudevents.New("/greeter", "com.example.greeted", greeting{Name: "hello " + g.Name}), nil
+		}),
+		echotransport.DecodeCloudEvent[greeting],
+		echotransport.EncodeCloudEventResponse[greeting](cloudevents.Binary),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(
+		`{"specversion":"1.0","id":"e-1","source":"/people","type":"com.example.arrived","subject":"bob","data":{"name":"bob"}}`,
+	))
+	req.Header.Set("Content-Type", cloudevents.ContentType)
+
+	rec := httptest.NewRecorder()
+
+	if err := handlerFunc(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := "e-1", received.ID; want != have {
+		t.Errorf("want id %q in context, have %q", want, have)
+	}
+
+	if want, have := "bob", received.Subject; want != have {
+		t.Errorf("want subject %q in context, have %q", want, have)
+	}
+
+	if want, have := "com.example.greeted", rec.Header().Get("Ce-Type"); want != have {
+		t.Errorf("want Ce-Type %q, have %q", want, have)
+	}
+
+	if want, have := `"hello bob"`, rec.Body.String(); !strings.Contains(have, want) {
+		t.Errorf("want body containing %s, have %s", want, have)
+	}
+}
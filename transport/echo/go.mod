@@ -3,19 +3,24 @@ module github.com/kikihakiem/gkit/transport/echo
 go 1.21.6
 
 require (
-	github.com/kikihakiem/gkit/core v0.4.0
-	github.com/kikihakiem/gkit/transport/http v0.5.0
+	github.com/kikihakiem/gkit/core v0.5.0
 	github.com/labstack/echo/v4 v4.12.0
+	github.com/stretchr/testify v1.8.4
 )
 
 require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/labstack/gommon v0.4.2 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/valyala/fasttemplate v1.2.2 // indirect
 	golang.org/x/crypto v0.22.0 // indirect
 	golang.org/x/net v0.24.0 // indirect
 	golang.org/x/sys v0.19.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace github.com/kikihakiem/gkit/core => ../../core
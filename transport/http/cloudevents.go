@@ -0,0 +1,80 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/kikihakiem/gkit/core/cloudevents"
+)
+
+// EncodeCloudEventRequest returns an EncodeRequestFunc that serializes events
+// to the request, in the given CloudEvents content mode. In binary mode, the
+// attributes of the event are mapped to Ce-* headers, e.g. Ce-Id, and its data
+// content type to the Content-Type header.
+func EncodeCloudEventRequest[T any](mode cloudevents.Mode) EncodeRequestFunc[cloudevents.Event[T]] {
+	return func(_ context.Context, r *http.Request, event cloudevents.Event[T]) error {
+		header, body, err := cloudevents.Encode(event, mode)
+		if err != nil {
+			return err
+		}
+
+		for k, v := range header {
+			r.Header.Set(k, v)
+		}
+
+		r.ContentLength = int64(len(body))
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		return nil
+	}
+}
+
+// DecodeCloudEventRequest is a DecodeRequestFunc that deserializes an event
+// from the request, in either CloudEvents content mode. Combined with
+// cloudevents.Endpoint, the attributes of the event are exposed in the context.
+func DecodeCloudEventRequest[T any](_ context.Context, r *http.Request) (cloudevents.Event[T], error) {
+	defer r.Body.Close()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return cloudevents.Event[T]{}, err
+	}
+
+	return cloudevents.Decode[T](r.Header, body)
+}
+
+// EncodeCloudEventResponse returns an EncodeResponseFunc that serializes events
+// to the response, in the given CloudEvents content mode.
+func EncodeCloudEventResponse[T any](mode cloudevents.Mode) EncodeResponseFunc[cloudevents.Event[T]] {
+	return func(_ context.Context, w http.ResponseWriter, event cloudevents.Event[T]) error {
+		header, body, err := cloudevents.Encode(event, mode)
+		if err != nil {
+			return err
+		}
+
+		for k, v := range header {
+			w.Header().Set(k, v)
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+		_, err = w.Write(body)
+
+		return err
+	}
+}
+
+// DecodeCloudEventResponse is a DecodeResponseFunc that deserializes an event
+// from the response, in either CloudEvents content mode.
+func DecodeCloudEventResponse[T any](_ context.Context, resp *http.Response) (cloudevents.Event[T], error) {
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return cloudevents.Event[T]{}, err
+	}
+
+	return cloudevents.Decode[T](resp.Header, body)
+}
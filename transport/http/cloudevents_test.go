@@ -0,0 +1,114 @@
+//go:build unit
+
+package http_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/kikihakiem/gkit/core/cloudevents"
+	httptransport "github.com/kikihakiem/gkit/transport/http"
+)
+
+type order struct {
+	ID    string `json:"id"`
+	Total int    `json:"total"`
+}
+
+func TestCloudEvents(t *testing.T) {
+	for name, mode := range map[string]cloudevents.Mode{
+		"binary":     cloudevents.Binary,
+		"structured": cloudevents.Structured,
+	} {
+		t.Run(name, func(t *testing.T) {
+			var (
+				received    cloudevents.Attributes
+				contentType string
+			)
+
+			handler := httptransport.NewServer(
+				cloudevents.Endpoint(func(ctx context.Context, o order) (cloudevents.Event[order], error) {
+					received, _ = cloudevents.FromContext(ctx)
+					o.Total *= 2
+
+					return cloudevents.New("/orders", "com.example.order.doubled", o), nil
+				}),
+				httptransport.DecodeCloudEventRequest[order],
+				httptransport.EncodeCloudEventResponse[order](mode),
+				httptransport.ServerBefore[cloudevents.Event[order], cloudevents.Event[order]](func(ctx context.Context, r *http.Request) context.Context {
+					contentType = r.Header.Get("Content-Type")
+					return ctx
+				}),
+			)
+
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			serverURL, _ := url.Parse(server.URL)
+
+			client := httptransport.NewClient(
+				http.MethodPost,
+				serverURL,
+				httptransport.EncodeCloudEventRequest[order](mode),
+				httptransport.DecodeCloudEventResponse[order],
+			)
+
+			event := cloudevents.New("/checkout", "com.example.order.placed", order{ID: "o-1", Total: 21})
+			event.Subject = "o-1"
+			event.Extensions = map[string]string{"tenant": "acme"}
+
+			res, err := client.Endpoint()(context.Background(), event)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want, have := 42, res.Data.Total; want != have {
+				t.Errorf("want total %d, have %d", want, have)
+			}
+
+			if want, have := "com.example.order.doubled", res.Type; want != have {
+				t.Errorf("want type %q, have %q", want, have)
+			}
+
+			if want, have := event.ID, received.ID; want != have {
+				t.Errorf("want id %q in context, have %q", want, have)
+			}
+
+			if want, have := "o-1", received.Subject; want != have {
+				t.Errorf("want subject %q in context, have %q", want, have)
+			}
+
+			if !event.Time.Equal(received.Time) {
+				t.Errorf("want time %v in context, have %v", event.Time, received.Time)
+			}
+
+			if want, have := "acme", received.Extensions["tenant"]; want != have {
+				t.Errorf("want extension %q, have %q", want, have)
+			}
+
+			want := "application/json"
+			if mode == cloudevents.Structured {
+				want = cloudevents.ContentType
+			}
+
+			if have := contentType; want != have {
+				t.Errorf("want content type %q, have %q", want, have)
+			}
+		})
+	}
+}
+
+func TestDecodeCloudEventRequestInvalid(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"id":"o-1"}`))
+	r.Header.Set("Content-Type", "application/json")
+
+	_, err := httptransport.DecodeCloudEventRequest[order](context.Background(), r)
+	if !errors.Is(err, cloudevents.ErrInvalid) {
+		t.Errorf("want %v, have %v", cloudevents.ErrInvalid, err)
+	}
+}
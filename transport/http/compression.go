@@ -0,0 +1,345 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	"github.com/kikihakiem/gkit/core/compression"
+)
+
+// ContentEncoding is a compression format of HTTP bodies, as named in the
+// Content-Encoding and Accept-Encoding headers.
+type ContentEncoding = compression.Encoding
+
+const (
+	// ContentEncodingGzip is the gzip format.
+	ContentEncodingGzip = compression.Gzip
+
+	// ContentEncodingZstd is the Zstandard format.
+	ContentEncodingZstd = compression.Zstd
+
+	// ContentEncodingS2 is the S2 stream format, an extension of Snappy
+	// trading some compression ratio for speed.
+	ContentEncodingS2 = compression.S2
+)
+
+// newReader returns a reader decompressing body with encoding, failing with a
+// DecompressedSizeError past maxSize bytes. Closing it closes body.
+func newReader(encoding ContentEncoding, body io.ReadCloser, maxSize int64) (io.ReadCloser, error) {
+	if !encoding.Supported() {
+		return nil, UnsupportedEncodingError{Encoding: encoding}
+	}
+
+	r, err := encoding.NewReader(body, maxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return decompressingReader{ReadCloser: r, body: body, maxSize: maxSize}, nil
+}
+
+type decompressingReader struct {
+	io.ReadCloser
+	body    io.ReadCloser
+	maxSize int64
+}
+
+func (r decompressingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if errors.Is(err, compression.ErrTooLarge) {
+		err = DecompressedSizeError{MaxSize: r.maxSize}
+	}
+
+	return n, err
+}
+
+func (r decompressingReader) Close() error {
+	r.ReadCloser.Close()
+	return r.body.Close()
+}
+
+// UnsupportedEncodingError is returned when decompressing a body whose
+// Content-Encoding is unknown. Servers respond to it with the 415 Unsupported
+// Media Type status code.
+type UnsupportedEncodingError struct {
+	Encoding ContentEncoding
+}
+
+// Error implements error.
+func (e UnsupportedEncodingError) Error() string {
+	return fmt.Sprintf("unsupported content encoding %q", e.Encoding)
+}
+
+// StatusCode implements StatusCoder.
+func (e UnsupportedEncodingError) StatusCode() int {
+	return http.StatusUnsupportedMediaType
+}
+
+// DecompressedSizeError is returned when reading a body decompressing to more
+// than the maximum size. Servers respond to it with the 413 Request Entity Too
+// Large status code.
+type DecompressedSizeError struct {
+	MaxSize int64
+}
+
+// Error implements error.
+func (e DecompressedSizeError) Error() string {
+	return fmt.Sprintf("decompressed body exceeds %d bytes", e.MaxSize)
+}
+
+// StatusCode implements StatusCoder.
+func (e DecompressedSizeError) StatusCode() int {
+	return http.StatusRequestEntityTooLarge
+}
+
+// Unwrap returns compression.ErrTooLarge.
+func (e DecompressedSizeError) Unwrap() error {
+	return compression.ErrTooLarge
+}
+
+// Compression compresses and decompresses HTTP bodies.
+type Compression struct {
+	encodings []ContentEncoding
+	threshold int
+	maxSize   int64
+}
+
+// NewCompression constructs a compression. By default, bodies of at least 1 KiB
+// are compressed with gzip, and bodies decompressing to more than 16 MiB are
+// rejected.
+func NewCompression(options ...gkit.Option[*Compression]) *Compression {
+	c := &Compression{
+		encodings: []ContentEncoding{ContentEncodingGzip},
+		threshold: 1024,
+		maxSize:   compression.DefaultMaxSize,
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	return c
+}
+
+// CompressionEncodings sets the encodings bodies may be compressed with, by
+// order of preference. Clients compress requests with the first one, and
+// accept responses compressed with any of them. Servers compress responses
+// with the first one accepted by the client.
+func CompressionEncodings(encodings ...ContentEncoding) gkit.Option[*Compression] {
+	return func(c *Compression) { c.encodings = encodings }
+}
+
+// CompressionThreshold sets the body size in bytes below which bodies are sent
+// uncompressed, since compressing small payloads isn't worth it.
+func CompressionThreshold(threshold int) gkit.Option[*Compression] {
+	return func(c *Compression) { c.threshold = threshold }
+}
+
+// CompressionMaxDecompressedSize sets the size in bytes above which
+// decompressed bodies are rejected, guarding against compression bombs.
+// Reading past it fails with a DecompressedSizeError. Values below one are
+// ignored.
+func CompressionMaxDecompressedSize(maxSize int64) gkit.Option[*Compression] {
+	return func(c *Compression) {
+		if maxSize > 0 {
+			c.maxSize = maxSize
+		}
+	}
+}
+
+// negotiate returns the preferred encoding accepted by acceptEncoding, the
+// value of an Accept-Encoding header, or false if none is.
+func (c *Compression) negotiate(acceptEncoding string) (ContentEncoding, bool) {
+	accepted := make(map[ContentEncoding]bool)
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, _ = strconv.ParseFloat(value, 64)
+		}
+
+		accepted[ContentEncoding(strings.ToLower(strings.TrimSpace(name)))] = q > 0
+	}
+
+	for _, encoding := range c.encodings {
+		if accepted[encoding] {
+			return encoding, true
+		}
+	}
+
+	return "", false
+}
+
+// CompressRequestEncoder wraps a client request encoder. Encoded requests
+// whose body reaches the threshold have it compressed with the preferred
+// encoding, and the Accept-Encoding header is set to the supported encodings,
+// so that responses are to be decoded with DecompressResponseDecoder. The
+// server must support compressed requests, e.g. with DecompressRequestDecoder.
+func CompressRequestEncoder[Req any](c *Compression, enc EncodeRequestFunc[Req]) EncodeRequestFunc[Req] {
+	return func(ctx context.Context, r *http.Request, request Req) error {
+		if err := enc(ctx, r, request); err != nil {
+			return err
+		}
+
+		names := make([]string, len(c.encodings))
+		for i, encoding := range c.encodings {
+			names[i] = string(encoding)
+		}
+
+		r.Header.Set("Accept-Encoding", strings.Join(names, ", "))
+
+		if r.Body == nil || len(c.encodings) == 0 {
+			return nil
+		}
+
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+
+		r.Body.Close()
+
+		if len(data) >= c.threshold {
+			data, err = c.encodings[0].Compress(data)
+			if err != nil {
+				return err
+			}
+
+			r.Header.Set("Content-Encoding", string(c.encodings[0]))
+		}
+
+		r.ContentLength = int64(len(data))
+		r.Body = io.NopCloser(bytes.NewReader(data))
+		r.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		}
+
+		return nil
+	}
+}
+
+// DecompressResponseDecoder wraps a client response decoder. Responses with a
+// Content-Encoding header are decompressed before being decoded, up to the
+// maximum decompressed size of c.
+func DecompressResponseDecoder[Res any](c *Compression, dec gkit.EncodeDecodeFunc[*http.Response, Res]) gkit.EncodeDecodeFunc[*http.Response, Res] {
+	return func(ctx context.Context, resp *http.Response) (Res, error) {
+		if resp.Header.Get("Content-Encoding") != "" {
+			if err := c.decompressBody(resp.Header, &resp.Body); err != nil {
+				var response Res
+				return response, err
+			}
+
+			resp.ContentLength = -1
+			resp.Uncompressed = true
+		}
+
+		return dec(ctx, resp)
+	}
+}
+
+// DecompressRequestDecoder wraps a server request decoder. Requests with a
+// Content-Encoding header are decompressed before being decoded, up to the
+// maximum decompressed size of c. Requests with an unsupported encoding fail
+// with an UnsupportedEncodingError, and requests exceeding the maximum size
+// with a DecompressedSizeError.
+func DecompressRequestDecoder[Req any](c *Compression, dec gkit.EncodeDecodeFunc[*http.Request, Req]) gkit.EncodeDecodeFunc[*http.Request, Req] {
+	return func(ctx context.Context, r *http.Request) (Req, error) {
+		if err := c.decompressBody(r.Header, &r.Body); err != nil {
+			var request Req
+			return request, err
+		}
+
+		return dec(ctx, r)
+	}
+}
+
+// CompressResponseEncoder wraps a server response encoder. Encoded responses
+// whose body reaches the threshold are compressed with the preferred encoding
+// accepted by the client. Servers using it must also use
+// PopulateRequestContext, from which the Accept-Encoding header is taken.
+func CompressResponseEncoder[Res any](c *Compression, enc EncodeResponseFunc[Res]) EncodeResponseFunc[Res] {
+	return func(ctx context.Context, w http.ResponseWriter, response Res) error {
+		acceptEncoding, _ := ctx.Value(ContextKeyRequestAcceptEncoding).(string)
+
+		encoding, ok := c.negotiate(acceptEncoding)
+		if !ok {
+			return enc(ctx, w, response)
+		}
+
+		bw := &bufferingWriter{ResponseWriter: w, code: http.StatusOK}
+		if err := enc(ctx, bw, response); err != nil {
+			return err
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		data := bw.buf.Bytes()
+		if len(data) >= c.threshold && w.Header().Get("Content-Encoding") == "" {
+			compressed, err := encoding.Compress(data)
+			if err != nil {
+				return err
+			}
+
+			data = compressed
+
+			w.Header().Set("Content-Encoding", string(encoding))
+			w.Header().Del("Content-Length")
+		}
+
+		w.WriteHeader(bw.code)
+
+		if len(data) == 0 {
+			return nil
+		}
+
+		_, err := w.Write(data)
+
+		return err
+	}
+}
+
+// bufferingWriter holds back the status code and the body of a response, so
+// that they can be written once compressed.
+type bufferingWriter struct {
+	http.ResponseWriter
+	code int
+	buf  bytes.Buffer
+}
+
+func (w *bufferingWriter) WriteHeader(code int) {
+	w.code = code
+}
+
+func (w *bufferingWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+// decompressBody replaces body with a reader decompressing it according to the
+// Content-Encoding header, which is then removed.
+func (c *Compression) decompressBody(header http.Header, body *io.ReadCloser) error {
+	encoding := ContentEncoding(strings.ToLower(header.Get("Content-Encoding")))
+	if encoding == "" || encoding == "identity" {
+		return nil
+	}
+
+	r, err := newReader(encoding, *body, c.maxSize)
+	if err != nil {
+		return err
+	}
+
+	*body = r
+
+	header.Del("Content-Encoding")
+	header.Del("Content-Length")
+
+	return nil
+}
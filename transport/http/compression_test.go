@@ -0,0 +1,183 @@
+//go:build unit
+
+package http_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	httptransport "github.com/kikihakiem/gkit/transport/http"
+)
+
+type compressionPayload struct {
+	Text string `json:"text"`
+}
+
+func decodeCompressionPayload(_ context.Context, resp *http.Response) (compressionPayload, error) {
+	var res compressionPayload
+	err := json.NewDecoder(resp.Body).Decode(&res)
+
+	return res, err
+}
+
+func TestCompression(t *testing.T) {
+	for _, encoding := range []httptransport.ContentEncoding{
+		httptransport.ContentEncodingGzip,
+		httptransport.ContentEncodingZstd,
+		httptransport.ContentEncodingS2,
+	} {
+		for name, size := range map[string]int{"compressed": 4096, "below threshold": 16} {
+			t.Run(string(encoding)+" "+name, func(t *testing.T) {
+				compression := httptransport.NewCompression(
+					httptransport.CompressionEncodings(encoding),
+					httptransport.CompressionThreshold(1024),
+				)
+
+				var requestEncoding, responseEncoding string
+
+				handler := httptransport.NewServer(
+					func(_ context.Context, req compressionPayload) (compressionPayload, error) { return req, nil },
+					httptransport.DecompressRequestDecoder(compression, httptransport.DecodeJSONRequest[compressionPayload]),
+					httptransport.CompressResponseEncoder(compression, httptransport.EncodeJSONResponse[compressionPayload]),
+					httptransport.ServerBefore[compressionPayload, compressionPayload](
+						httptransport.PopulateRequestContext,
+						func(ctx context.Context, r *http.Request) context.Context {
+							requestEncoding = r.Header.Get("Content-Encoding")
+							return ctx
+						},
+					),
+				)
+
+				server := httptest.NewServer(handler)
+				defer server.Close()
+
+				serverURL, _ := url.Parse(server.URL)
+
+				client := httptransport.NewClient(
+					http.MethodPost,
+					serverURL,
+					httptransport.CompressRequestEncoder(compression, httptransport.EncodeJSONRequest[compressionPayload]),
+					httptransport.DecompressResponseDecoder(compression, decodeCompressionPayload),
+					httptransport.ClientAfter[compressionPayload, compressionPayload](func(ctx context.Context, resp *http.Response) context.Context {
+						responseEncoding = resp.Header.Get("Content-Encoding")
+						return ctx
+					}),
+				)
+
+				req := compressionPayload{Text: strings.Repeat("a", size)}
+
+				res, err := client.Endpoint()(context.Background(), req)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if want, have := req.Text, res.Text; want != have {
+					t.Errorf("want %d bytes echoed, have %d", len(want), len(have))
+				}
+
+				want := string(encoding)
+				if size < 1024 {
+					want = ""
+				}
+
+				if have := requestEncoding; want != have {
+					t.Errorf("want request encoding %q, have %q", want, have)
+				}
+
+				if have := responseEncoding; want != have {
+					t.Errorf("want response encoding %q, have %q", want, have)
+				}
+			})
+		}
+	}
+}
+
+func TestCompressResponseNotAccepted(t *testing.T) {
+	compression := httptransport.NewCompression(
+		httptransport.CompressionEncodings(httptransport.ContentEncodingZstd),
+		httptransport.CompressionThreshold(0),
+	)
+
+	handler := httptransport.NewServer(
+		func(context.Context, struct{}) (compressionPayload, error) { return compressionPayload{Text: "a"}, nil },
+		func(context.Context, *http.Request) (struct{}, error) { return struct{}{}, nil },
+		httptransport.CompressResponseEncoder(compression, httptransport.EncodeJSONResponse[compressionPayload]),
+		httptransport.ServerBefore[struct{}, compressionPayload](httptransport.PopulateRequestContext),
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip, zstd;q=0")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if want, have := "", rec.Header().Get("Content-Encoding"); want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+
+	if want, have := `{"text":"a"}`, strings.TrimSpace(rec.Body.String()); want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+}
+
+func TestDecompressRequestUnsupportedEncoding(t *testing.T) {
+	handler := httptransport.NewServer(
+		func(_ context.Context, req compressionPayload) (compressionPayload, error) { return req, nil },
+		httptransport.DecompressRequestDecoder(httptransport.NewCompression(), httptransport.DecodeJSONRequest[compressionPayload]),
+		httptransport.EncodeJSONResponse[compressionPayload],
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"text":"a"}`))
+	req.Header.Set("Content-Encoding", "br")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if want, have := http.StatusUnsupportedMediaType, rec.Code; want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+}
+
+func TestDecompressRequestMaxSize(t *testing.T) {
+	compression := httptransport.NewCompression(
+		httptransport.CompressionThreshold(0),
+		httptransport.CompressionMaxDecompressedSize(1024),
+	)
+
+	handler := httptransport.NewServer(
+		func(_ context.Context, req compressionPayload) (compressionPayload, error) { return req, nil },
+		httptransport.DecompressRequestDecoder(compression, httptransport.DecodeJSONRequest[compressionPayload]),
+		httptransport.EncodeJSONResponse[compressionPayload],
+	)
+
+	for name, tc := range map[string]struct {
+		size int
+		code int
+	}{
+		"below limit": {size: 512, code: http.StatusOK},
+		"above limit": {size: 4096, code: http.StatusRequestEntityTooLarge},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+
+			err := httptransport.CompressRequestEncoder(compression, httptransport.EncodeJSONRequest[compressionPayload])(
+				context.Background(), req, compressionPayload{Text: strings.Repeat("a", tc.size)},
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if want, have := tc.code, rec.Code; want != have {
+				t.Errorf("want %d, have %d", want, have)
+			}
+		})
+	}
+}
@@ -2,4 +2,8 @@ module github.com/kikihakiem/gkit/transport/http
 
 go 1.21.6
 
-require github.com/kikihakiem/gkit/core v0.4.0
+require github.com/kikihakiem/gkit/core v0.5.0
+
+require github.com/klauspost/compress v1.17.5 // indirect
+
+replace github.com/kikihakiem/gkit/core => ../../core
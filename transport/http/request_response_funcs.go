@@ -61,6 +61,7 @@ func PopulateRequestContext(ctx context.Context, r *http.Request) context.Contex
 		ContextKeyRequestUserAgent:       r.Header.Get("User-Agent"),
 		ContextKeyRequestXRequestID:      r.Header.Get("X-Request-Id"),
 		ContextKeyRequestAccept:          r.Header.Get("Accept"),
+		ContextKeyRequestAcceptEncoding:  r.Header.Get("Accept-Encoding"),
 	} {
 		ctx = context.WithValue(ctx, k, v)
 	}
@@ -122,6 +123,10 @@ const (
 	// PopulateRequestContext. Its value is r.Header.Get("Accept").
 	ContextKeyRequestAccept
 
+	// ContextKeyRequestAcceptEncoding is populated in the context by
+	// PopulateRequestContext. Its value is r.Header.Get("Accept-Encoding").
+	ContextKeyRequestAcceptEncoding
+
 	// ContextKeyResponseHeaders is populated in the context whenever a
 	// ServerFinalizerFunc is specified. Its value is of type http.Header, and
 	// is captured only once the entire response has been written.
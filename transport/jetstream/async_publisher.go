@@ -0,0 +1,281 @@
+package jetstream
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// ErrPublisherClosed is returned when publishing through an AsyncPublisher that
+// has been closed.
+var ErrPublisherClosed = errors.New("jstransport: publisher closed")
+
+// ErrAckTimeout is returned when the server doesn't acknowledge an asynchronous
+// publish within the publisher timeout.
+var ErrAckTimeout = errors.New("jstransport: timed out waiting for publish ack")
+
+// Future holds the decoded response of an asynchronous publish. It is resolved
+// once the server acknowledges the message or the publish fails.
+type Future[Res any] struct {
+	done     chan struct{}
+	response Res
+	err      error
+}
+
+func newFuture[Res any]() *Future[Res] {
+	return &Future[Res]{done: make(chan struct{})}
+}
+
+func (f *Future[Res]) resolve(response Res, err error) {
+	f.response, f.err = response, err
+	close(f.done)
+}
+
+// Done returns a channel that is closed when the future is resolved.
+func (f *Future[Res]) Done() <-chan struct{} {
+	return f.done
+}
+
+// Result blocks until the future is resolved and returns its outcome.
+func (f *Future[Res]) Result() (Res, error) {
+	<-f.done
+	return f.response, f.err
+}
+
+// Wait is like Result but gives up when ctx is done.
+func (f *Future[Res]) Wait(ctx context.Context) (Res, error) {
+	select {
+	case <-f.done:
+		return f.response, f.err
+	case <-ctx.Done():
+		var response Res
+		return response, ctx.Err()
+	}
+}
+
+// AsyncCallback is invoked with the outcome of every asynchronous publish.
+type AsyncCallback[Res any] func(ctx context.Context, response Res, err error)
+
+// AsyncPublisher publishes messages without waiting for the server to
+// acknowledge them. Acknowledgements are decoded in the background and handed
+// to the caller through a Future and the registered callbacks.
+type AsyncPublisher[Req, Res any] struct {
+	publisher    jetstream.JetStream
+	enc          gkit.EncodeDecodeFunc[Req, *nats.Msg]
+	dec          gkit.EncodeDecodeFunc[*jetstream.PubAck, Res]
+	before       []gkit.BeforeRequestFunc[*nats.Msg]
+	after        []gkit.AfterResponseFunc[*jetstream.PubAck]
+	callback     []AsyncCallback[Res]
+	errorHandler gkit.ErrorHandler
+	timeout      time.Duration
+	maxPending   int
+
+	pending  chan struct{}
+	mu       sync.Mutex
+	inflight int
+	idle     chan struct{}
+	closed   bool
+}
+
+// NewAsyncPublisher constructs a usable AsyncPublisher for a single remote method.
+func NewAsyncPublisher[Req, Res any](
+	publisher jetstream.JetStream,
+	enc gkit.EncodeDecodeFunc[Req, *nats.Msg],
+	dec gkit.EncodeDecodeFunc[*jetstream.PubAck, Res],
+	options ...gkit.Option[*AsyncPublisher[Req, Res]],
+) *AsyncPublisher[Req, Res] {
+	p := &AsyncPublisher[Req, Res]{
+		publisher:    publisher,
+		enc:          enc,
+		dec:          dec,
+		errorHandler: gkit.LogErrorHandler(nil),
+		timeout:      10 * time.Second,
+		maxPending:   256,
+	}
+
+	for _, option := range options {
+		option(p)
+	}
+
+	p.pending = make(chan struct{}, p.maxPending)
+
+	return p
+}
+
+// AsyncPublisherBefore sets the PublisherRequestFuncs that are applied to the outgoing NATS
+// request before it's invoked.
+func AsyncPublisherBefore[Req, Res any](before ...gkit.BeforeRequestFunc[*nats.Msg]) gkit.Option[*AsyncPublisher[Req, Res]] {
+	return func(p *AsyncPublisher[Req, Res]) { p.before = append(p.before, before...) }
+}
+
+// AsyncPublisherAfter sets the functions applied to the publish ack prior to it being decoded.
+func AsyncPublisherAfter[Req, Res any](after ...gkit.AfterResponseFunc[*jetstream.PubAck]) gkit.Option[*AsyncPublisher[Req, Res]] {
+	return func(p *AsyncPublisher[Req, Res]) { p.after = append(p.after, after...) }
+}
+
+// AsyncPublisherCallback adds callbacks that are invoked with the outcome of
+// every publish, in addition to resolving the returned Future.
+func AsyncPublisherCallback[Req, Res any](callback ...AsyncCallback[Res]) gkit.Option[*AsyncPublisher[Req, Res]] {
+	return func(p *AsyncPublisher[Req, Res]) { p.callback = append(p.callback, callback...) }
+}
+
+// AsyncPublisherErrorHandler is used to handle failed publish acks. By default,
+// they are logged.
+func AsyncPublisherErrorHandler[Req, Res any](errorHandler gkit.ErrorHandler) gkit.Option[*AsyncPublisher[Req, Res]] {
+	return func(p *AsyncPublisher[Req, Res]) { p.errorHandler = errorHandler }
+}
+
+// AsyncPublisherTimeout sets how long to wait for the publish ack.
+func AsyncPublisherTimeout[Req, Res any](timeout time.Duration) gkit.Option[*AsyncPublisher[Req, Res]] {
+	return func(p *AsyncPublisher[Req, Res]) { p.timeout = timeout }
+}
+
+// AsyncPublisherMaxPending sets the maximum number of publishes awaiting an ack.
+// Once the window is full, the endpoint blocks until an ack arrives or its
+// context is done. By default, it is 256. Values below one are ignored.
+func AsyncPublisherMaxPending[Req, Res any](maxPending int) gkit.Option[*AsyncPublisher[Req, Res]] {
+	return func(p *AsyncPublisher[Req, Res]) {
+		if maxPending > 0 {
+			p.maxPending = maxPending
+		}
+	}
+}
+
+// Endpoint returns a usable endpoint that publishes the request and returns a
+// Future for its decoded ack.
+func (p *AsyncPublisher[Req, Res]) Endpoint() gkit.Endpoint[Req, *Future[Res]] {
+	return func(ctx context.Context, request Req) (*Future[Res], error) {
+		msg, err := p.enc(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range p.before {
+			ctx = f(ctx, msg)
+		}
+
+		select {
+		case p.pending <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		if !p.acquire() {
+			<-p.pending
+			return nil, ErrPublisherClosed
+		}
+
+		ack, err := p.publisher.PublishMsgAsync(msg)
+		if err != nil {
+			<-p.pending
+			p.release()
+
+			return nil, err
+		}
+
+		future := newFuture[Res]()
+
+		go p.await(context.WithoutCancel(ctx), ack, future)
+
+		return future, nil
+	}
+}
+
+func (p *AsyncPublisher[Req, Res]) await(ctx context.Context, ack jetstream.PubAckFuture, future *Future[Res]) {
+	defer p.release()
+
+	var (
+		response Res
+		resp     *jetstream.PubAck
+		err      error
+	)
+
+	select {
+	case resp = <-ack.Ok():
+	case err = <-ack.Err():
+	case <-time.After(p.timeout):
+		err = ErrAckTimeout
+	}
+
+	<-p.pending
+
+	if err == nil {
+		for _, f := range p.after {
+			ctx = f(ctx, resp, err)
+		}
+
+		response, err = p.dec(ctx, resp)
+	}
+
+	if err != nil {
+		p.errorHandler.Handle(ctx, err)
+	}
+
+	future.resolve(response, err)
+
+	for _, f := range p.callback {
+		f(ctx, response, err)
+	}
+}
+
+// acquire registers an in-flight publish. It reports false once the publisher is closed.
+func (p *AsyncPublisher[Req, Res]) acquire() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return false
+	}
+
+	if p.inflight == 0 {
+		p.idle = make(chan struct{})
+	}
+
+	p.inflight++
+
+	return true
+}
+
+func (p *AsyncPublisher[Req, Res]) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.inflight--
+	if p.inflight == 0 {
+		close(p.idle)
+	}
+}
+
+// Flush blocks until every pending publish is resolved or ctx is done.
+func (p *AsyncPublisher[Req, Res]) Flush(ctx context.Context) error {
+	p.mu.Lock()
+	if p.inflight == 0 {
+		p.mu.Unlock()
+		return nil
+	}
+
+	idle := p.idle
+	p.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting new publishes and waits for the pending ones to be
+// resolved. It is meant to be called on shutdown.
+func (p *AsyncPublisher[Req, Res]) Close(ctx context.Context) error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	return p.Flush(ctx)
+}
@@ -0,0 +1,134 @@
+//go:build unit
+
+package jetstream_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	jstransport "github.com/kikihakiem/gkit/transport/jetstream"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestAsyncPublisher(t *testing.T) {
+	js, _, stop := newJetstream(context.Background(), t)
+	defer stop()
+
+	callbacks := make(chan error, 10)
+
+	publisher := jstransport.NewAsyncPublisher[struct{}](
+		js,
+		jstransport.EncodeJSONRequest,
+		gkit.PassThroughEncoderDecoder,
+		jstransport.AsyncPublisherMaxPending[struct{}, *jetstream.PubAck](2),
+		jstransport.AsyncPublisherCallback[struct{}](func(_ context.Context, _ *jetstream.PubAck, err error) {
+			callbacks <- err
+		}),
+	)
+
+	futures := make([]*jstransport.Future[*jetstream.PubAck], 0, 10)
+
+	for i := 0; i < 10; i++ {
+		future, err := publisher.Endpoint()(context.Background(), struct{}{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		futures = append(futures, future)
+	}
+
+	if err := publisher.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, future := range futures {
+		select {
+		case <-future.Done():
+		default:
+			t.Fatal("future not resolved after Close")
+		}
+
+		res, err := future.Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, have := "test:stream", res.Stream; want != have {
+			t.Errorf("want %q, have %q", want, have)
+		}
+	}
+
+	if want, have := 10, len(callbacks); want != have {
+		t.Errorf("want %d callbacks, have %d", want, have)
+	}
+
+	if _, err := publisher.Endpoint()(context.Background(), struct{}{}); !errors.Is(err, jstransport.ErrPublisherClosed) {
+		t.Errorf("want %s, have %v", jstransport.ErrPublisherClosed, err)
+	}
+}
+
+func TestAsyncPublisherInvalidMaxPending(t *testing.T) {
+	js, _, stop := newJetstream(context.Background(), t)
+	defer stop()
+
+	for _, maxPending := range []int{0, -1} {
+		publisher := jstransport.NewAsyncPublisher[struct{}](
+			js,
+			jstransport.EncodeJSONRequest,
+			gkit.PassThroughEncoderDecoder,
+			jstransport.AsyncPublisherMaxPending[struct{}, *jetstream.PubAck](maxPending),
+		)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+
+		future, err := publisher.Endpoint()(ctx, struct{}{})
+		if err != nil {
+			t.Fatalf("max pending %d: %v", maxPending, err)
+		}
+
+		if _, err := future.Wait(ctx); err != nil {
+			t.Errorf("max pending %d: %v", maxPending, err)
+		}
+
+		cancel()
+		publisher.Close(context.Background()) //nolint:errcheck
+	}
+}
+
+func TestAsyncPublisherFailedAck(t *testing.T) {
+	js, _, stop := newJetstream(context.Background(), t)
+	defer stop()
+
+	errChan := make(chan error, 1)
+
+	publisher := jstransport.NewAsyncPublisher(
+		js,
+		func(_ context.Context, _ struct{}) (*nats.Msg, error) {
+			return nats.NewMsg("not.bound.to.stream"), nil
+		},
+		gkit.PassThroughEncoderDecoder[*jetstream.PubAck],
+		jstransport.AsyncPublisherErrorHandler[struct{}, *jetstream.PubAck](gkit.ErrorHandlerFunc(func(_ context.Context, err error) {
+			errChan <- err
+		})),
+	)
+
+	future, err := publisher.Endpoint()(context.Background(), struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := future.Wait(ctx); err == nil {
+		t.Error("want error, have nil")
+	}
+
+	if err := <-errChan; err == nil {
+		t.Error("want error handled, have nil")
+	}
+}
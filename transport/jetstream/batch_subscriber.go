@@ -0,0 +1,221 @@
+package jetstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// ErrBatchResultSize is reported when a batch endpoint returns a BatchResult
+// whose number of outcomes differs from the number of requests, in which case
+// the whole batch is negatively acknowledged.
+var ErrBatchResultSize = errors.New("jstransport: batch result size mismatch")
+
+// BatchResult reports the outcome of every item of a batch handed to a batch
+// endpoint. Errors is indexed like the request slice, and must hold one entry
+// per request: a nil entry means the corresponding message is acknowledged, a
+// non-nil entry means it is negatively acknowledged and will be redelivered.
+type BatchResult struct {
+	Errors []error
+}
+
+// NewBatchResult returns a BatchResult able to hold the outcome of n items.
+func NewBatchResult(n int) BatchResult {
+	return BatchResult{Errors: make([]error, n)}
+}
+
+// Fail records err as the outcome of the i-th item. Like indexing a slice, it
+// panics if i is out of the range the result was created with by
+// NewBatchResult, rather than losing the failure.
+func (r BatchResult) Fail(i int, err error) {
+	if i < 0 || i >= len(r.Errors) {
+		panic(fmt.Sprintf("jstransport: batch result index %d out of range [0:%d]", i, len(r.Errors)))
+	}
+
+	r.Errors[i] = err
+}
+
+// Err returns the outcome of the i-th item.
+func (r BatchResult) Err(i int) error {
+	if i < 0 || i >= len(r.Errors) {
+		return nil
+	}
+
+	return r.Errors[i]
+}
+
+// BatchSubscriber wraps an endpoint that accepts a slice of requests. It pulls
+// messages from a consumer and hands them to the endpoint in batches.
+type BatchSubscriber[Req any] struct {
+	e            gkit.Endpoint[[]Req, BatchResult]
+	dec          gkit.EncodeDecodeFunc[jetstream.Msg, Req]
+	before       []gkit.BeforeRequestFunc[[]jetstream.Msg]
+	after        []gkit.AfterResponseFunc[BatchResult]
+	finalizer    []gkit.FinalizerFunc[[]jetstream.Msg]
+	errorHandler gkit.ErrorHandler
+	batchSize    int
+	maxWait      time.Duration
+}
+
+// NewBatchSubscriber constructs a new batch subscriber, which collects up to
+// batch size messages, or whatever arrived within max wait, before invoking the
+// provided endpoint.
+func NewBatchSubscriber[Req any](
+	e gkit.Endpoint[[]Req, BatchResult],
+	dec gkit.EncodeDecodeFunc[jetstream.Msg, Req],
+	options ...gkit.Option[*BatchSubscriber[Req]],
+) *BatchSubscriber[Req] {
+	s := &BatchSubscriber[Req]{
+		e:            e,
+		dec:          dec,
+		errorHandler: gkit.LogErrorHandler(nil),
+		batchSize:    100,
+		maxWait:      5 * time.Second,
+	}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	return s
+}
+
+// BatchSubscriberSize sets the maximum number of messages handed to the
+// endpoint at once. By default, it is 100.
+func BatchSubscriberSize[Req any](size int) gkit.Option[*BatchSubscriber[Req]] {
+	return func(s *BatchSubscriber[Req]) { s.batchSize = size }
+}
+
+// BatchSubscriberMaxWait sets how long to wait for a batch to fill up before
+// handing the messages received so far to the endpoint. By default, it is 5 seconds.
+func BatchSubscriberMaxWait[Req any](maxWait time.Duration) gkit.Option[*BatchSubscriber[Req]] {
+	return func(s *BatchSubscriber[Req]) { s.maxWait = maxWait }
+}
+
+// BatchSubscriberBefore functions are executed on the fetched messages before
+// they are decoded.
+func BatchSubscriberBefore[Req any](before ...gkit.BeforeRequestFunc[[]jetstream.Msg]) gkit.Option[*BatchSubscriber[Req]] {
+	return func(s *BatchSubscriber[Req]) { s.before = append(s.before, before...) }
+}
+
+// BatchSubscriberAfter functions are executed on the batch result after the
+// endpoint is invoked, but before the messages are acknowledged.
+func BatchSubscriberAfter[Req any](after ...gkit.AfterResponseFunc[BatchResult]) gkit.Option[*BatchSubscriber[Req]] {
+	return func(s *BatchSubscriber[Req]) { s.after = append(s.after, after...) }
+}
+
+// BatchSubscriberErrorHandler is used to handle non-terminal errors. By default,
+// non-terminal errors are logged.
+func BatchSubscriberErrorHandler[Req any](errorHandler gkit.ErrorHandler) gkit.Option[*BatchSubscriber[Req]] {
+	return func(s *BatchSubscriber[Req]) { s.errorHandler = errorHandler }
+}
+
+// BatchSubscriberFinalizer is executed at the end of every batch.
+// By default, no finalizer is registered.
+func BatchSubscriberFinalizer[Req any](finalizerFunc ...gkit.FinalizerFunc[[]jetstream.Msg]) gkit.Option[*BatchSubscriber[Req]] {
+	return func(s *BatchSubscriber[Req]) { s.finalizer = append(s.finalizer, finalizerFunc...) }
+}
+
+// Consume fetches batches from the consumer and handles them until ctx is done.
+func (s BatchSubscriber[Req]) Consume(ctx context.Context, consumer jetstream.Consumer) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		batch, err := consumer.Fetch(s.batchSize, jetstream.FetchMaxWait(s.maxWait))
+		if err != nil {
+			return err
+		}
+
+		msgs := make([]jetstream.Msg, 0, s.batchSize)
+		for msg := range batch.Messages() {
+			msgs = append(msgs, msg)
+		}
+
+		if err := batch.Error(); err != nil && !errors.Is(err, nats.ErrTimeout) && !errors.Is(err, jetstream.ErrNoMessages) {
+			s.errorHandler.Handle(ctx, err)
+		}
+
+		if len(msgs) > 0 {
+			s.HandleBatch(ctx, msgs)
+		}
+	}
+}
+
+// HandleBatch decodes the messages, invokes the endpoint with the decoded
+// requests and acknowledges every message according to the batch result.
+// Messages that fail to decode are negatively acknowledged and left out of the batch.
+// The whole batch is negatively acknowledged when the endpoint fails, or when
+// its result doesn't hold one outcome per request.
+func (s BatchSubscriber[Req]) HandleBatch(ctx context.Context, msgs []jetstream.Msg) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var err error
+
+	defer func() {
+		for _, f := range s.finalizer {
+			f(ctx, msgs, err)
+		}
+	}()
+
+	for _, f := range s.before {
+		ctx = f(ctx, msgs)
+	}
+
+	requests := make([]Req, 0, len(msgs))
+	decoded := make([]jetstream.Msg, 0, len(msgs))
+
+	for _, msg := range msgs {
+		request, decErr := s.dec(ctx, msg)
+		if decErr != nil {
+			s.errorHandler.Handle(ctx, decErr)
+			msg.Nak() //nolint:errcheck
+
+			continue
+		}
+
+		requests = append(requests, request)
+		decoded = append(decoded, msg)
+	}
+
+	if len(requests) == 0 {
+		return
+	}
+
+	result, err := s.e(ctx, requests)
+	if err == nil && len(result.Errors) != len(requests) {
+		err = fmt.Errorf("%w: %d outcomes for %d requests", ErrBatchResultSize, len(result.Errors), len(requests))
+	}
+
+	if err != nil {
+		s.errorHandler.Handle(ctx, err)
+
+		for _, msg := range decoded {
+			msg.Nak() //nolint:errcheck
+		}
+
+		return
+	}
+
+	for _, f := range s.after {
+		ctx = f(ctx, result, err)
+	}
+
+	for i, msg := range decoded {
+		if itemErr := result.Err(i); itemErr != nil {
+			s.errorHandler.Handle(ctx, itemErr)
+			msg.Nak() //nolint:errcheck
+		} else {
+			msg.Ack() //nolint:errcheck
+		}
+	}
+}
@@ -0,0 +1,175 @@
+//go:build unit
+
+package jetstream_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	jstransport "github.com/kikihakiem/gkit/transport/jetstream"
+)
+
+func newBatchConsumer(ctx context.Context, t *testing.T) (jetstream.JetStream, jetstream.Consumer, func()) {
+	t.Helper()
+
+	js, stream, stop := newJetstream(ctx, t)
+	if err := stream.Purge(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	consumer, err := stream.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
+		Durable:   t.Name(),
+		AckPolicy: jetstream.AckExplicitPolicy,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	return js, consumer, stop
+}
+
+func TestBatchSubscriber(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	js, consumer, stop := newBatchConsumer(ctx, t)
+	defer stop()
+
+	for _, data := range []string{"a", "b", "c"} {
+		publish(t, js, data)
+	}
+
+	batches := make(chan []string, 2)
+
+	handler := jstransport.NewBatchSubscriber(
+		func(_ context.Context, reqs []string) (jstransport.BatchResult, error) {
+			batches <- reqs
+
+			result := jstransport.NewBatchResult(len(reqs))
+			if len(reqs) == 3 {
+				result.Fail(1, errors.New("dang"))
+			}
+
+			return result, nil
+		},
+		func(_ context.Context, msg jetstream.Msg) (string, error) {
+			return string(msg.Data()), nil
+		},
+		jstransport.BatchSubscriberSize[string](3),
+		jstransport.BatchSubscriberMaxWait[string](100*time.Millisecond),
+	)
+
+	go handler.Consume(ctx, consumer) //nolint:errcheck
+
+	if want, have := []string{"a", "b", "c"}, <-batches; !reflect.DeepEqual(want, have) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+
+	// only the failed item gets redelivered.
+	if want, have := []string{"b"}, <-batches; !reflect.DeepEqual(want, have) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
+
+func TestBatchSubscriberBadDecode(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	js, consumer, stop := newBatchConsumer(ctx, t)
+	defer stop()
+
+	publish(t, js, "a")
+	publish(t, js, "b")
+
+	var (
+		batches = make(chan []string, 2)
+		errChan = make(chan error, 1)
+	)
+
+	handler := jstransport.NewBatchSubscriber(
+		func(_ context.Context, reqs []string) (jstransport.BatchResult, error) {
+			batches <- reqs
+			return jstransport.NewBatchResult(len(reqs)), nil
+		},
+		func(_ context.Context, msg jetstream.Msg) (string, error) {
+			if string(msg.Data()) == "a" {
+				return "", errors.New("dang")
+			}
+
+			return string(msg.Data()), nil
+		},
+		jstransport.BatchSubscriberSize[string](2),
+		jstransport.BatchSubscriberMaxWait[string](100*time.Millisecond),
+		jstransport.BatchSubscriberErrorHandler[string](gkit.ErrorHandlerFunc(func(_ context.Context, err error) {
+			select {
+			case errChan <- err:
+			default:
+			}
+		})),
+	)
+
+	go handler.Consume(ctx, consumer) //nolint:errcheck
+
+	if want, have := "dang", (<-errChan).Error(); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+
+	if want, have := []string{"b"}, <-batches; !reflect.DeepEqual(want, have) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
+
+func TestBatchResultOutOfRange(t *testing.T) {
+	for name, result := range map[string]jstransport.BatchResult{
+		"zero value": {},
+		"sized":      jstransport.NewBatchResult(2),
+	} {
+		for _, i := range []int{-1, 2} {
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Errorf("%s: want Fail(%d) to panic", name, i)
+					}
+				}()
+
+				result.Fail(i, errors.New("dang"))
+			}()
+		}
+	}
+}
+
+func TestBatchSubscriberResultSizeMismatch(t *testing.T) {
+	var handled []error
+
+	handler := jstransport.NewBatchSubscriber(
+		func(context.Context, []string) (jstransport.BatchResult, error) {
+			return jstransport.BatchResult{}, nil
+		},
+		func(_ context.Context, msg jetstream.Msg) (string, error) {
+			return string(msg.Data()), nil
+		},
+		jstransport.BatchSubscriberErrorHandler[string](gkit.ErrorHandlerFunc(func(_ context.Context, err error) {
+			handled = append(handled, err)
+		})),
+	)
+
+	msgs := []*messageMock{{data: []byte("a")}, {data: []byte("b")}}
+
+	handler.HandleBatch(context.Background(), []jetstream.Msg{msgs[0], msgs[1]})
+
+	for _, msg := range msgs {
+		if want, have := []string{"nak"}, msg.acks; !reflect.DeepEqual(want, have) {
+			t.Errorf("want %v, have %v", want, have)
+		}
+	}
+
+	if want, have := 1, len(handled); want != have || !errors.Is(handled[0], jstransport.ErrBatchResultSize) {
+		t.Errorf("want %d ErrBatchResultSize, have %v", want, handled)
+	}
+}
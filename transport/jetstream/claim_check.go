@@ -0,0 +1,175 @@
+package jetstream
+
+import (
+	"context"
+	"errors"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nuid"
+)
+
+// ClaimCheckHeader carries the name of the Object Store object holding the
+// payload of a message whose data was moved out by a claim check.
+const ClaimCheckHeader = "Claim-Check"
+
+// ClaimCheckRetention tells when checked payloads are removed from the Object
+// Store once the message referencing them has been handled.
+type ClaimCheckRetention int
+
+const (
+	// ClaimCheckKeep never deletes the payload. Expiring payloads is left to
+	// the TTL or the size limits of the Object Store bucket, which must be set
+	// so that payloads outlive every consumer that may read them, including
+	// replays and dead letter queues. Payloads of messages whose publishing
+	// failed after the payload was stored expire the same way.
+	ClaimCheckKeep ClaimCheckRetention = iota
+
+	// ClaimCheckDeleteOnAck deletes the payload once the message is
+	// acknowledged. Messages that are nak'd keep their payload to be
+	// redelivered. It's only safe when the message is read by a single
+	// consumer, and is neither replayed nor forwarded elsewhere, e.g. to a
+	// dead letter queue, since other readers would find the payload gone.
+	ClaimCheckDeleteOnAck
+
+	// ClaimCheckDeleteOnDone deletes the payload once the message is either
+	// acknowledged or terminated, i.e. whenever it won't be redelivered. The
+	// same caveats as ClaimCheckDeleteOnAck apply.
+	ClaimCheckDeleteOnDone
+)
+
+// ClaimCheck moves message payloads above a size threshold into a JetStream
+// Object Store bucket, so that they don't hit the max payload of the server.
+// The message then only carries a reference to the object in the
+// ClaimCheckHeader. Publishers and subscribers sharing a bucket must agree on
+// it, but not on the threshold.
+type ClaimCheck struct {
+	store        jetstream.ObjectStore
+	threshold    int
+	retention    ClaimCheckRetention
+	errorHandler gkit.ErrorHandler
+}
+
+// NewClaimCheck constructs a claim check storing payloads in the given bucket.
+// By default, payloads larger than 512 KiB are checked and kept in the bucket,
+// which should thus be created with a TTL.
+func NewClaimCheck(store jetstream.ObjectStore, options ...gkit.Option[*ClaimCheck]) *ClaimCheck {
+	c := &ClaimCheck{
+		store:        store,
+		threshold:    512 * 1024,
+		retention:    ClaimCheckKeep,
+		errorHandler: gkit.LogErrorHandler(nil),
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	return c
+}
+
+// ClaimCheckThreshold sets the payload size in bytes above which payloads are
+// moved to the Object Store.
+func ClaimCheckThreshold(threshold int) gkit.Option[*ClaimCheck] {
+	return func(c *ClaimCheck) { c.threshold = threshold }
+}
+
+// ClaimCheckRetentionPolicy sets when checked payloads are deleted. By default,
+// they are kept, see ClaimCheckKeep.
+func ClaimCheckRetentionPolicy(retention ClaimCheckRetention) gkit.Option[*ClaimCheck] {
+	return func(c *ClaimCheck) { c.retention = retention }
+}
+
+// ClaimCheckErrorHandler is used to handle errors deleting payloads. By default,
+// they are logged.
+func ClaimCheckErrorHandler(errorHandler gkit.ErrorHandler) gkit.Option[*ClaimCheck] {
+	return func(c *ClaimCheck) { c.errorHandler = errorHandler }
+}
+
+// ClaimCheckEncoder wraps a publisher encoder. Encoded messages whose data is
+// larger than the threshold have it put into the Object Store, and are sent
+// with an empty body and the ClaimCheckHeader instead.
+func ClaimCheckEncoder[Req any](c *ClaimCheck, enc gkit.EncodeDecodeFunc[Req, *nats.Msg]) gkit.EncodeDecodeFunc[Req, *nats.Msg] {
+	return func(ctx context.Context, request Req) (*nats.Msg, error) {
+		msg, err := enc(ctx, request)
+		if err != nil || len(msg.Data) <= c.threshold {
+			return msg, err
+		}
+
+		name := nuid.Next()
+
+		if _, err := c.store.PutBytes(ctx, name, msg.Data); err != nil {
+			return nil, err
+		}
+
+		if msg.Header == nil {
+			msg.Header = nats.Header{}
+		}
+
+		msg.Header.Set(ClaimCheckHeader, name)
+		msg.Data = nil
+
+		return msg, nil
+	}
+}
+
+// ClaimCheckDecoder wraps a subscriber decoder. Messages carrying the
+// ClaimCheckHeader have their payload fetched from the Object Store, and are
+// decoded as if it had been sent in the message.
+func ClaimCheckDecoder[Req any](c *ClaimCheck, dec gkit.EncodeDecodeFunc[jetstream.Msg, Req]) gkit.EncodeDecodeFunc[jetstream.Msg, Req] {
+	return func(ctx context.Context, msg jetstream.Msg) (Req, error) {
+		name := msg.Headers().Get(ClaimCheckHeader)
+		if name == "" {
+			return dec(ctx, msg)
+		}
+
+		data, err := c.store.GetBytes(ctx, name)
+		if err != nil {
+			var request Req
+			return request, err
+		}
+
+		return dec(ctx, msgWithData{Msg: msg, data: data})
+	}
+}
+
+// Finalizer returns a subscriber finalizer deleting the checked payload of
+// handled messages according to the retention policy.
+func (c *ClaimCheck) Finalizer() gkit.FinalizerFunc[jetstream.Msg] {
+	return func(ctx context.Context, msg jetstream.Msg, _ error) {
+		name := msg.Headers().Get(ClaimCheckHeader)
+		if name == "" || !c.shouldDelete(ctx) {
+			return
+		}
+
+		err := c.store.Delete(context.WithoutCancel(ctx), name)
+		if err != nil && !errors.Is(err, jetstream.ErrObjectNotFound) {
+			c.errorHandler.Handle(ctx, err)
+		}
+	}
+}
+
+func (c *ClaimCheck) shouldDelete(ctx context.Context) bool {
+	disposition, _ := ctx.Value(ContextKeyAckDisposition).(AckDisposition)
+
+	switch c.retention {
+	case ClaimCheckDeleteOnAck:
+		return disposition == AckDispositionAck
+	case ClaimCheckDeleteOnDone:
+		return disposition == AckDispositionAck || disposition == AckDispositionTerm
+	default:
+		return false
+	}
+}
+
+// msgWithData is a message whose data was replaced, e.g. fetched from the Object
+// Store or decrypted.
+type msgWithData struct {
+	jetstream.Msg
+	data []byte
+}
+
+func (m msgWithData) Data() []byte {
+	return m.data
+}
@@ -0,0 +1,122 @@
+//go:build unit
+
+package jetstream_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	jstransport "github.com/kikihakiem/gkit/transport/jetstream"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestClaimCheck(t *testing.T) {
+	t.Run("keep", func(t *testing.T) {
+		testClaimCheck(t, false)
+	})
+
+	t.Run("delete on ack", func(t *testing.T) {
+		testClaimCheck(t, true, jstransport.ClaimCheckRetentionPolicy(jstransport.ClaimCheckDeleteOnAck))
+	})
+}
+
+// testClaimCheck sends a small and a large payload through a claim check, and
+// checks whether the large payload's object is left once it has been handled.
+func testClaimCheck(t *testing.T, wantDeleted bool, options ...gkit.Option[*jstransport.ClaimCheck]) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	js, stream, stop := newJetstream(ctx, t)
+	defer stop()
+
+	if err := stream.Purge(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := js.CreateObjectStore(ctx, jetstream.ObjectStoreConfig{Bucket: "claims"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claimCheck := jstransport.NewClaimCheck(store, append([]gkit.Option[*jstransport.ClaimCheck]{jstransport.ClaimCheckThreshold(16)}, options...)...)
+
+	var (
+		received = make(chan string, 2)
+		names    = make(chan string, 2)
+	)
+
+	subscriber := jstransport.NewSubscriber(
+		func(_ context.Context, req string) (struct{}, error) {
+			received <- req
+			return struct{}{}, nil
+		},
+		jstransport.ClaimCheckDecoder(claimCheck, func(_ context.Context, msg jetstream.Msg) (string, error) {
+			return string(msg.Data()), nil
+		}),
+		gkit.NopResponseEncoder,
+		jstransport.SubscriberFinalizer[string, struct{}](
+			claimCheck.Finalizer(),
+			func(_ context.Context, msg jetstream.Msg, _ error) {
+				names <- msg.Headers().Get(jstransport.ClaimCheckHeader)
+			},
+		),
+	)
+
+	consumer, err := stream.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	consumeCtx, err := consumer.Consume(subscriber.HandleMessage(js))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumeCtx.Stop()
+
+	publisher := jstransport.NewPublisher(
+		js,
+		jstransport.ClaimCheckEncoder(claimCheck, func(_ context.Context, req string) (*nats.Msg, error) {
+			msg := nats.NewMsg("jstransport.test.claim")
+			msg.Data = []byte(req)
+
+			return msg, nil
+		}),
+		gkit.NopEncoderDecoder[*jetstream.PubAck, *jetstream.PubAck],
+	).Endpoint()
+
+	large := strings.Repeat("x", 64)
+
+	for _, payload := range []string{"small", large} {
+		if _, err := publisher(ctx, payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, want := range []string{"small", large} {
+		if have := <-received; want != have {
+			t.Errorf("want %q, have %q", want, have)
+		}
+	}
+
+	if name := <-names; name != "" {
+		t.Errorf("want small payload sent inline, have claim check %q", name)
+	}
+
+	name := <-names
+	if name == "" {
+		t.Fatal("want large payload claim checked")
+	}
+
+	_, err = store.GetInfo(ctx, name)
+
+	if deleted := errors.Is(err, jetstream.ErrObjectNotFound); deleted != wantDeleted {
+		t.Errorf("want payload deleted after ack %t, have %v", wantDeleted, err)
+	}
+}
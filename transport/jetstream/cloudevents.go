@@ -0,0 +1,39 @@
+package jetstream
+
+import (
+	"context"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	"github.com/kikihakiem/gkit/core/cloudevents"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// EncodeCloudEvent returns an EncodeRequestFunc that serializes events to
+// messages published to subject, in the given CloudEvents content mode. In
+// binary mode, the attributes of the event are mapped to ce-* headers, e.g.
+// ce-id, and its data content type to the content-type header.
+func EncodeCloudEvent[T any](subject string, mode cloudevents.Mode) gkit.EncodeDecodeFunc[cloudevents.Event[T], *nats.Msg] {
+	return func(_ context.Context, event cloudevents.Event[T]) (*nats.Msg, error) {
+		header, body, err := cloudevents.Encode(event, mode)
+		if err != nil {
+			return nil, err
+		}
+
+		msg := nats.NewMsg(subject)
+		msg.Data = body
+
+		for k, v := range header {
+			msg.Header[k] = []string{v}
+		}
+
+		return msg, nil
+	}
+}
+
+// DecodeCloudEvent is a DecodeRequestFunc that deserializes an event from the
+// message, in either CloudEvents content mode. Combined with
+// cloudevents.Endpoint, the attributes of the event are exposed in the context.
+func DecodeCloudEvent[T any](_ context.Context, msg jetstream.Msg) (cloudevents.Event[T], error) {
+	return cloudevents.Decode[T](msg.Headers(), msg.Data())
+}
@@ -0,0 +1,102 @@
+//go:build unit
+
+package jetstream_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	"github.com/kikihakiem/gkit/core/cloudevents"
+	jstransport "github.com/kikihakiem/gkit/transport/jetstream"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type shipment struct {
+	Parcel string `json:"parcel"`
+}
+
+func TestCloudEvents(t *testing.T) {
+	for name, mode := range map[string]cloudevents.Mode{
+		"binary":     cloudevents.Binary,
+		"structured": cloudevents.Structured,
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			js, _, stop := newJetstream(ctx, t)
+			defer stop()
+
+			subject := "jstransport.cloudevents." + strconv.FormatInt(time.Now().UnixNano(), 10)
+
+			publisher := jstransport.NewPublisher(
+				js,
+				jstransport.EncodeCloudEvent[shipment](subject, mode),
+				gkit.NopEncoderDecoder[*jetstream.PubAck, struct{}],
+			)
+
+			event := cloudevents.New("/warehouse", "com.example.shipped", shipment{Parcel: "p-1"})
+			event.Subject = "p-1"
+
+			if _, err := publisher.Endpoint()(ctx, event); err != nil {
+				t.Fatal(err)
+			}
+
+			consumer, err := js.OrderedConsumer(ctx, "test:stream", jetstream.OrderedConsumerConfig{FilterSubjects: []string{subject}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			msg, err := consumer.Next(jetstream.FetchMaxWait(5 * time.Second))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if mode == cloudevents.Binary {
+				if want, have := event.ID, msg.Headers().Get("ce-id"); want != have {
+					t.Errorf("want ce-id %q, have %q", want, have)
+				}
+			}
+
+			received := make(chan cloudevents.Event[shipment], 1)
+
+			subscriber := jstransport.NewSubscriber(
+				cloudevents.Endpoint(func(ctx context.Context, s shipment) (struct{}, error) {
+					attributes, _ := cloudevents.FromContext(ctx)
+					received <- cloudevents.Event[shipment]{Attributes: attributes, Data: s}
+
+					return struct{}{}, nil
+				}),
+				jstransport.DecodeCloudEvent[shipment],
+				gkit.NopResponseEncoder,
+			)
+
+			subscriber.HandleMessage(js)(msg)
+
+			consumed := <-received
+			attributes := consumed.Attributes
+
+			for want, have := range map[string]string{
+				event.ID:     attributes.ID,
+				event.Source: attributes.Source,
+				event.Type:   attributes.Type,
+				"p-1":        attributes.Subject,
+			} {
+				if want != have {
+					t.Errorf("want %q, have %q", want, have)
+				}
+			}
+
+			if !event.Time.Equal(attributes.Time) {
+				t.Errorf("want time %v, have %v", event.Time, attributes.Time)
+			}
+
+			if want, have := event.Data, consumed.Data; want != have {
+				t.Errorf("want data %v, have %v", want, have)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package jetstream
+
+import (
+	"context"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	"github.com/kikihakiem/gkit/core/compression"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// ContentEncodingHeader carries the encoding the message data was compressed
+// with.
+const ContentEncodingHeader = "Content-Encoding"
+
+// ContentEncoding is a compression format of message data.
+type ContentEncoding = compression.Encoding
+
+const (
+	// ContentEncodingGzip is the gzip format.
+	ContentEncodingGzip = compression.Gzip
+
+	// ContentEncodingZstd is the Zstandard format.
+	ContentEncodingZstd = compression.Zstd
+
+	// ContentEncodingS2 is the S2 stream format, an extension of Snappy
+	// trading some compression ratio for speed.
+	ContentEncodingS2 = compression.S2
+)
+
+// Compression compresses and decompresses message data.
+type Compression struct {
+	encoding  ContentEncoding
+	threshold int
+	maxSize   int64
+}
+
+// NewCompression constructs a compression. By default, data of at least 1 KiB
+// is compressed with gzip, and data decompressing to more than 16 MiB is
+// rejected.
+func NewCompression(options ...gkit.Option[*Compression]) *Compression {
+	c := &Compression{
+		encoding:  ContentEncodingGzip,
+		threshold: 1024,
+		maxSize:   compression.DefaultMaxSize,
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	return c
+}
+
+// CompressionEncoding sets the encoding data is compressed with. Messages are
+// decompressed with the encoding named in their ContentEncodingHeader.
+func CompressionEncoding(encoding ContentEncoding) gkit.Option[*Compression] {
+	return func(c *Compression) { c.encoding = encoding }
+}
+
+// CompressionThreshold sets the data size in bytes below which data is sent
+// uncompressed, since compressing small payloads isn't worth it.
+func CompressionThreshold(threshold int) gkit.Option[*Compression] {
+	return func(c *Compression) { c.threshold = threshold }
+}
+
+// CompressionMaxDecompressedSize sets the size in bytes above which
+// decompressed data is rejected, guarding against compression bombs. Messages
+// exceeding it fail to be decoded with compression.ErrTooLarge. Values below
+// one are ignored.
+func CompressionMaxDecompressedSize(maxSize int64) gkit.Option[*Compression] {
+	return func(c *Compression) {
+		if maxSize > 0 {
+			c.maxSize = maxSize
+		}
+	}
+}
+
+// CompressEncoder wraps a publisher encoder. Encoded messages whose data
+// reaches the threshold have it compressed, and are sent with the
+// ContentEncodingHeader. When combined with EncryptEncoder, compression must
+// come first, i.e. be wrapped by the encryption, since encrypted data doesn't
+// compress.
+func CompressEncoder[Req any](c *Compression, enc gkit.EncodeDecodeFunc[Req, *nats.Msg]) gkit.EncodeDecodeFunc[Req, *nats.Msg] {
+	return func(ctx context.Context, request Req) (*nats.Msg, error) {
+		msg, err := enc(ctx, request)
+		if err != nil || len(msg.Data) < c.threshold {
+			return msg, err
+		}
+
+		msg.Data, err = c.encoding.Compress(msg.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		if msg.Header == nil {
+			msg.Header = nats.Header{}
+		}
+
+		msg.Header.Set(ContentEncodingHeader, string(c.encoding))
+
+		return msg, nil
+	}
+}
+
+// DecompressDecoder wraps a subscriber decoder. Messages carrying the
+// ContentEncodingHeader are decompressed before being decoded, whatever their
+// encoding, up to the maximum decompressed size of c. Other messages are
+// decoded as is.
+func DecompressDecoder[Req any](c *Compression, dec gkit.EncodeDecodeFunc[jetstream.Msg, Req]) gkit.EncodeDecodeFunc[jetstream.Msg, Req] {
+	return func(ctx context.Context, msg jetstream.Msg) (Req, error) {
+		encoding := ContentEncoding(msg.Headers().Get(ContentEncodingHeader))
+		if encoding == "" {
+			return dec(ctx, msg)
+		}
+
+		data, err := encoding.Decompress(msg.Data(), c.maxSize)
+		if err != nil {
+			var request Req
+			return request, err
+		}
+
+		return dec(ctx, msgWithData{Msg: msg, data: data})
+	}
+}
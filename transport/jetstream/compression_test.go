@@ -0,0 +1,147 @@
+//go:build unit
+
+package jetstream_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	gkitcompression "github.com/kikihakiem/gkit/core/compression"
+	jstransport "github.com/kikihakiem/gkit/transport/jetstream"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestCompression(t *testing.T) {
+	for _, encoding := range []jstransport.ContentEncoding{
+		jstransport.ContentEncodingGzip,
+		jstransport.ContentEncodingZstd,
+		jstransport.ContentEncodingS2,
+	} {
+		for name, size := range map[string]int{"compressed": 4096, "below threshold": 16} {
+			t.Run(string(encoding)+" "+name, func(t *testing.T) {
+				compression := jstransport.NewCompression(
+					jstransport.CompressionEncoding(encoding),
+					jstransport.CompressionThreshold(1024),
+				)
+
+				enc := jstransport.CompressEncoder(compression, func(_ context.Context, s string) (*nats.Msg, error) {
+					msg := nats.NewMsg("jstransport.test.compress")
+					msg.Data = []byte(s)
+
+					return msg, nil
+				})
+
+				dec := jstransport.DecompressDecoder(compression, func(_ context.Context, msg jetstream.Msg) (string, error) {
+					return string(msg.Data()), nil
+				})
+
+				payload := strings.Repeat("a", size)
+
+				msg, err := enc(context.Background(), payload)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				want := string(encoding)
+				if size < 1024 {
+					want = ""
+				}
+
+				if have := msg.Header.Get(jstransport.ContentEncodingHeader); want != have {
+					t.Errorf("want encoding %q, have %q", want, have)
+				}
+
+				if want != "" && len(msg.Data) >= size {
+					t.Errorf("want data compressed, have %d bytes", len(msg.Data))
+				}
+
+				have, err := dec(context.Background(), &messageMock{subject: msg.Subject, data: msg.Data, headers: msg.Header})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if payload != have {
+					t.Errorf("want %d bytes, have %d", len(payload), len(have))
+				}
+			})
+		}
+	}
+}
+
+func TestCompressionWithEncryption(t *testing.T) {
+	encryption := jstransport.NewEncryption(jstransport.NewStaticKeyring("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}))
+	compression := jstransport.NewCompression(jstransport.CompressionThreshold(0))
+
+	enc := jstransport.EncryptEncoder(encryption, jstransport.CompressEncoder(compression, func(_ context.Context, s string) (*nats.Msg, error) {
+		msg := nats.NewMsg("jstransport.test.compress")
+		msg.Data = []byte(s)
+
+		return msg, nil
+	}))
+
+	dec := jstransport.DecryptDecoder(encryption, jstransport.DecompressDecoder(compression, func(_ context.Context, msg jetstream.Msg) (string, error) {
+		return string(msg.Data()), nil
+	}))
+
+	payload := strings.Repeat("personal data ", 100)
+
+	msg, err := enc(context.Background(), payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(msg.Data) >= len(payload) {
+		t.Errorf("want data compressed, have %d bytes", len(msg.Data))
+	}
+
+	have, err := dec(context.Background(), &messageMock{subject: msg.Subject, data: msg.Data, headers: msg.Header})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if payload != have {
+		t.Errorf("want %q, have %q", payload, have)
+	}
+}
+
+func TestDecompressMaxSize(t *testing.T) {
+	compression := jstransport.NewCompression(
+		jstransport.CompressionThreshold(0),
+		jstransport.CompressionMaxDecompressedSize(1024),
+	)
+
+	enc := jstransport.CompressEncoder(compression, func(_ context.Context, s string) (*nats.Msg, error) {
+		msg := nats.NewMsg("jstransport.test.compress")
+		msg.Data = []byte(s)
+
+		return msg, nil
+	})
+
+	dec := jstransport.DecompressDecoder(compression, func(_ context.Context, msg jetstream.Msg) (string, error) {
+		return string(msg.Data()), nil
+	})
+
+	for name, tc := range map[string]struct {
+		size int
+		err  error
+	}{
+		"at limit":    {size: 1024},
+		"above limit": {size: 1025, err: gkitcompression.ErrTooLarge},
+	} {
+		t.Run(name, func(t *testing.T) {
+			msg, err := enc(context.Background(), strings.Repeat("a", tc.size))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = dec(context.Background(), &messageMock{subject: msg.Subject, data: msg.Data, headers: msg.Header})
+			if !errors.Is(err, tc.err) {
+				t.Errorf("want %v, have %v", tc.err, err)
+			}
+		})
+	}
+}
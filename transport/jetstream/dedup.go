@@ -0,0 +1,235 @@
+package jetstream
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// DedupRace tells how a Deduplicator handles a delivery of a message that is
+// still being processed, e.g. redelivered once its AckWait expired.
+type DedupRace int
+
+const (
+	// DedupRaceNak negatively acknowledges the delivery with a delay, so that
+	// it is only processed if the ongoing processing fails. This is the
+	// default.
+	DedupRaceNak DedupRace = iota
+
+	// DedupRaceAck acknowledges the delivery as a duplicate. Since this also
+	// acknowledges the message on the server, it is lost if the ongoing
+	// processing fails.
+	DedupRaceAck
+
+	// DedupRaceProcess processes the delivery as well. Messages are only
+	// recorded once processed, so concurrent deliveries may both invoke the
+	// endpoint.
+	DedupRaceProcess
+)
+
+// DedupKeyFunc derives the key identifying a message across its deliveries. It
+// reports false when the message can't be deduplicated.
+type DedupKeyFunc func(ctx context.Context, msg jetstream.Msg) (string, bool)
+
+// DedupKeyMsgID is the default DedupKeyFunc. It takes the key from the
+// Nats-Msg-Id header, or else from the stream sequence of the message.
+func DedupKeyMsgID(_ context.Context, msg jetstream.Msg) (string, bool) {
+	if id := msg.Headers().Get(jetstream.MsgIDHeader); id != "" {
+		return id, true
+	}
+
+	meta, err := msg.Metadata()
+	if err != nil {
+		return "", false
+	}
+
+	return fmt.Sprintf("%s:%d", meta.Stream, meta.Sequence.Stream), true
+}
+
+const dedupDone = "done"
+
+// Deduplicator records the messages processed by a subscriber in a JetStream
+// Key-Value bucket, so that redeliveries of a processed message are
+// acknowledged without invoking the endpoint again. Records expire with the
+// TTL of the bucket, which should exceed the time a message can be redelivered.
+type Deduplicator struct {
+	kv         jetstream.KeyValue
+	key        DedupKeyFunc
+	race       DedupRace
+	lease      time.Duration
+	retryDelay time.Duration
+}
+
+// NewDeduplicator constructs a deduplicator recording messages in kv.
+func NewDeduplicator(kv jetstream.KeyValue, options ...gkit.Option[*Deduplicator]) *Deduplicator {
+	d := &Deduplicator{
+		kv:         kv,
+		key:        DedupKeyMsgID,
+		race:       DedupRaceNak,
+		lease:      30 * time.Second,
+		retryDelay: 5 * time.Second,
+	}
+
+	for _, option := range options {
+		option(d)
+	}
+
+	return d
+}
+
+// DeduplicatorKey sets the function deriving the key of a message. By default,
+// DedupKeyMsgID is used.
+func DeduplicatorKey(key DedupKeyFunc) gkit.Option[*Deduplicator] {
+	return func(d *Deduplicator) { d.key = key }
+}
+
+// DeduplicatorRace sets how deliveries of a message being processed are
+// handled. By default, they're negatively acknowledged.
+func DeduplicatorRace(race DedupRace) gkit.Option[*Deduplicator] {
+	return func(d *Deduplicator) { d.race = race }
+}
+
+// DeduplicatorLease sets how long a message is considered being processed,
+// after which another delivery may take over, e.g. when the subscriber
+// processing it crashed. It should match the AckWait of the consumer. By
+// default, it is 30 seconds.
+func DeduplicatorLease(lease time.Duration) gkit.Option[*Deduplicator] {
+	return func(d *Deduplicator) { d.lease = lease }
+}
+
+// DeduplicatorRetryDelay sets the delay before redelivering a message being
+// processed, with DedupRaceNak. By default, it is five seconds.
+func DeduplicatorRetryDelay(retryDelay time.Duration) gkit.Option[*Deduplicator] {
+	return func(d *Deduplicator) { d.retryDelay = retryDelay }
+}
+
+// SubscriberDeduplicator makes the subscriber skip the messages already
+// processed, as recorded by d. Skipped messages reach StageDedup.
+func SubscriberDeduplicator[Req, Res any](d *Deduplicator) gkit.Option[*Subscriber[Req, Res]] {
+	return func(s *Subscriber[Req, Res]) { s.dedup = d }
+}
+
+// dedupClaim is the record of a message by a deduplicator. A non-empty skip
+// tells how to acknowledge a message that isn't to be processed.
+type dedupClaim struct {
+	key      string
+	revision uint64
+	skip     AckDisposition
+	delay    time.Duration
+}
+
+// claim records that msg is being processed, unless it has already been or is
+// being processed. A nil claim means the message can't be deduplicated.
+func (d *Deduplicator) claim(ctx context.Context, msg jetstream.Msg) (*dedupClaim, error) {
+	id, ok := d.key(ctx, msg)
+	if !ok {
+		return nil, nil
+	}
+
+	c := &dedupClaim{key: base64.RawURLEncoding.EncodeToString([]byte(id))}
+
+	if d.race == DedupRaceProcess {
+		entry, err := d.kv.Get(ctx, c.key)
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			return c, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if string(entry.Value()) == dedupDone {
+			c.skip = AckDispositionAck
+		}
+
+		return c, nil
+	}
+
+	for {
+		revision, err := d.kv.Create(ctx, c.key, claimValue(time.Now()))
+		if err == nil {
+			c.revision = revision
+			return c, nil
+		}
+
+		if !errors.Is(err, jetstream.ErrKeyExists) {
+			return nil, err
+		}
+
+		entry, err := d.kv.Get(ctx, c.key)
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			continue // released in the meantime
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		value := string(entry.Value())
+		if value == dedupDone {
+			c.skip = AckDispositionAck
+			return c, nil
+		}
+
+		if claimedAt, err := parseClaimValue(value); err == nil && time.Since(claimedAt) > d.lease {
+			revision, err := d.kv.Update(ctx, c.key, claimValue(time.Now()), entry.Revision())
+			if err == nil {
+				c.revision = revision
+				return c, nil
+			}
+		}
+
+		if d.race == DedupRaceAck {
+			c.skip = AckDispositionAck
+		} else {
+			c.skip, c.delay = AckDispositionNak, d.retryDelay
+		}
+
+		return c, nil
+	}
+}
+
+// complete records the outcome of a processed message: acknowledged messages
+// are recorded as done, while the claim of other messages is released so that
+// they can be processed again when redelivered.
+func (d *Deduplicator) complete(ctx context.Context, c *dedupClaim, disposition AckDisposition) error {
+	ctx = context.WithoutCancel(ctx)
+
+	if disposition == AckDispositionAck {
+		_, err := d.kv.PutString(ctx, c.key, dedupDone)
+		return err
+	}
+
+	if c.revision == 0 {
+		return nil
+	}
+
+	err := d.kv.Delete(ctx, c.key, jetstream.LastRevision(c.revision))
+
+	var apiErr *jetstream.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode == jetstream.JSErrCodeStreamWrongLastSequence {
+		return nil // taken over by another delivery
+	}
+
+	return err
+}
+
+func claimValue(at time.Time) []byte {
+	return []byte("claimed " + strconv.FormatInt(at.UnixNano(), 10))
+}
+
+func parseClaimValue(value string) (time.Time, error) {
+	nanos, err := strconv.ParseInt(strings.TrimPrefix(value, "claimed "), 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(0, nanos), nil
+}
@@ -0,0 +1,154 @@
+//go:build unit
+
+package jetstream_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	jstransport "github.com/kikihakiem/gkit/transport/jetstream"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func newDedupKV(ctx context.Context, t *testing.T) (jetstream.KeyValue, func()) {
+	t.Helper()
+
+	js, _, stop := newJetstream(ctx, t)
+
+	kv, err := js.CreateKeyValue(ctx, jetstream.KeyValueConfig{Bucket: "dedup", TTL: time.Hour})
+	if err != nil {
+		stop()
+		t.Fatal(err)
+	}
+
+	return kv, stop
+}
+
+func newDedupSubscriber(d *jstransport.Deduplicator, calls *int, fail *bool) *jstransport.Subscriber[string, struct{}] {
+	return jstransport.NewSubscriber(
+		func(context.Context, string) (struct{}, error) {
+			*calls++
+			if *fail {
+				return struct{}{}, errors.New("dang")
+			}
+
+			return struct{}{}, nil
+		},
+		func(_ context.Context, msg jetstream.Msg) (string, error) { return string(msg.Data()), nil },
+		gkit.NopResponseEncoder,
+		jstransport.SubscriberDeduplicator[string, struct{}](d),
+		jstransport.SubscriberErrorEncoder[string, struct{}](func(context.Context, jetstream.JetStream, error) {}),
+		jstransport.SubscriberErrorHandler[string, struct{}](gkit.ErrorHandlerFunc(func(context.Context, error) {})),
+	)
+}
+
+func dedupMessage(id string) *messageMock {
+	headers := nats.Header{}
+	headers.Set(jetstream.MsgIDHeader, id)
+
+	return &messageMock{subject: "jstransport.test.dedup", data: []byte("event"), headers: headers}
+}
+
+func TestDeduplicator(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	kv, stop := newDedupKV(ctx, t)
+	defer stop()
+
+	nonce := strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	var (
+		calls int
+		fail  bool
+	)
+
+	handle := newDedupSubscriber(jstransport.NewDeduplicator(kv), &calls, &fail).HandleMessage(nil)
+
+	first, second := dedupMessage(nonce+"a"), dedupMessage(nonce+"a")
+	handle(first)
+	handle(second)
+
+	if want, have := 1, calls; want != have {
+		t.Errorf("want %d endpoint calls, have %d", want, have)
+	}
+
+	if want, have := "ack", second.acks[0]; want != have {
+		t.Errorf("want duplicate %s'd, have %s", want, have)
+	}
+
+	calls, fail = 0, true
+	failing := dedupMessage(nonce + "b")
+	handle(failing)
+
+	fail = false
+	retried := dedupMessage(nonce + "b")
+	handle(retried)
+
+	if want, have := 2, calls; want != have {
+		t.Errorf("want failed message processed again, have %d endpoint calls", have)
+	}
+
+	if want, have := "ack", retried.acks[0]; want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
+
+func TestDeduplicatorRace(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	kv, stop := newDedupKV(ctx, t)
+	defer stop()
+
+	nonce := strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	claim := func(id string, at time.Time) {
+		key := base64.RawURLEncoding.EncodeToString([]byte(id))
+		if _, err := kv.PutString(ctx, key, "claimed "+strconv.FormatInt(at.UnixNano(), 10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, tc := range map[string]struct {
+		race    jstransport.DedupRace
+		claimed time.Time
+		calls   int
+		ack     string
+	}{
+		"nak":             {race: jstransport.DedupRaceNak, claimed: time.Now(), calls: 0, ack: "nak"},
+		"ack":             {race: jstransport.DedupRaceAck, claimed: time.Now(), calls: 0, ack: "ack"},
+		"process":         {race: jstransport.DedupRaceProcess, claimed: time.Now(), calls: 1, ack: "ack"},
+		"stale claim":     {race: jstransport.DedupRaceNak, claimed: time.Now().Add(-time.Hour), calls: 1, ack: "ack"},
+		"stale claim ack": {race: jstransport.DedupRaceAck, claimed: time.Now().Add(-time.Hour), calls: 1, ack: "ack"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			id := nonce + name
+			claim(id, tc.claimed)
+
+			var (
+				calls int
+				fail  bool
+			)
+
+			d := jstransport.NewDeduplicator(kv, jstransport.DeduplicatorRace(tc.race), jstransport.DeduplicatorLease(time.Minute))
+
+			msg := dedupMessage(id)
+			newDedupSubscriber(d, &calls, &fail).HandleMessage(nil)(msg)
+
+			if want, have := tc.calls, calls; want != have {
+				t.Errorf("want %d endpoint calls, have %d", want, have)
+			}
+
+			if want, have := tc.ack, msg.acks[0]; want != have {
+				t.Errorf("want %s, have %s", want, have)
+			}
+		})
+	}
+}
@@ -0,0 +1,348 @@
+package jetstream
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	// EncryptionAlgorithmHeader carries the algorithm the message data was
+	// encrypted with.
+	EncryptionAlgorithmHeader = "Encryption-Algorithm"
+
+	// EncryptionKeyIDHeader carries the ID of the key the message data, or its
+	// data key, was encrypted with.
+	EncryptionKeyIDHeader = "Encryption-Key-Id"
+
+	// EncryptionDataKeyHeader carries the encrypted data key of a message
+	// encrypted with envelope encryption, encoded as base64.
+	EncryptionDataKeyHeader = "Encryption-Data-Key"
+)
+
+// ErrNotEncrypted is the error of the DecryptError returned for messages that
+// aren't encrypted, unless plaintext is allowed with EncryptionAllowPlaintext.
+var ErrNotEncrypted = errors.New("jstransport: message isn't encrypted")
+
+// EncryptionAlgorithm is an AEAD algorithm encrypting message data.
+type EncryptionAlgorithm string
+
+const (
+	// AES256GCM is AES-256 in Galois/Counter Mode.
+	AES256GCM EncryptionAlgorithm = "AES-256-GCM"
+
+	// XChaCha20Poly1305 is XChaCha20-Poly1305, whose larger nonce is safer to
+	// pick at random for a high volume of messages.
+	XChaCha20Poly1305 EncryptionAlgorithm = "XChaCha20-Poly1305"
+)
+
+func (a EncryptionAlgorithm) aead(key []byte) (cipher.AEAD, error) {
+	switch a {
+	case AES256GCM:
+		if len(key) != 32 {
+			return nil, fmt.Errorf("jstransport: %s requires a 32 bytes key", a)
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+
+		return cipher.NewGCM(block)
+	case XChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	default:
+		return nil, fmt.Errorf("jstransport: unknown encryption algorithm %q", a)
+	}
+}
+
+// Keyring provides the keys encrypting and decrypting message data. The key ID
+// sent along every message lets keys be rotated: new messages are encrypted
+// with the current key, while older messages can still be decrypted as long as
+// their key is in the keyring.
+type Keyring interface {
+	// EncryptionKey returns the key to encrypt a message with, and its ID.
+	// Keyrings using envelope encryption also return the data key wrapped by
+	// the key identified by id, which is sent along the message.
+	EncryptionKey(ctx context.Context) (key []byte, id string, wrapped []byte, err error)
+
+	// DecryptionKey returns the key to decrypt a message with, from the key ID
+	// and the wrapped data key it was sent with.
+	DecryptionKey(ctx context.Context, id string, wrapped []byte) ([]byte, error)
+}
+
+// StaticKeyring is a Keyring holding its keys in memory. Messages are
+// encrypted with the current key directly.
+type StaticKeyring struct {
+	current string
+	keys    map[string][]byte
+}
+
+// NewStaticKeyring constructs a keyring encrypting with the key identified by
+// current, and decrypting with any of the keys.
+func NewStaticKeyring(current string, keys map[string][]byte) *StaticKeyring {
+	return &StaticKeyring{current: current, keys: keys}
+}
+
+// EncryptionKey implements Keyring.
+func (k *StaticKeyring) EncryptionKey(context.Context) ([]byte, string, []byte, error) {
+	key, ok := k.keys[k.current]
+	if !ok {
+		return nil, "", nil, fmt.Errorf("jstransport: unknown encryption key %q", k.current)
+	}
+
+	return key, k.current, nil, nil
+}
+
+// DecryptionKey implements Keyring.
+func (k *StaticKeyring) DecryptionKey(_ context.Context, id string, _ []byte) ([]byte, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("jstransport: unknown encryption key %q", id)
+	}
+
+	return key, nil
+}
+
+// EnvelopeKeyring is a Keyring using envelope encryption: every message is
+// encrypted with a random data key, which is itself encrypted with AES-256-GCM
+// by a key encryption key of the underlying keyring. Only the key encryption
+// keys need to be kept, e.g. in a KMS, and rotating them doesn't require
+// encrypting the messages again.
+type EnvelopeKeyring struct {
+	kek Keyring
+}
+
+// NewEnvelopeKeyring constructs a keyring wrapping data keys with the keys of kek.
+func NewEnvelopeKeyring(kek Keyring) *EnvelopeKeyring {
+	return &EnvelopeKeyring{kek: kek}
+}
+
+// EncryptionKey implements Keyring.
+func (k *EnvelopeKeyring) EncryptionKey(ctx context.Context) ([]byte, string, []byte, error) {
+	kek, id, _, err := k.kek.EncryptionKey(ctx)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, "", nil, err
+	}
+
+	wrapped, err := seal(AES256GCM, kek, dataKey, []byte(id))
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return dataKey, id, wrapped, nil
+}
+
+// DecryptionKey implements Keyring.
+func (k *EnvelopeKeyring) DecryptionKey(ctx context.Context, id string, wrapped []byte) ([]byte, error) {
+	kek, err := k.kek.DecryptionKey(ctx, id, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(AES256GCM, kek, wrapped, []byte(id))
+}
+
+// DecryptError is returned by decoders wrapped with DecryptDecoder when a
+// message can't be decrypted, e.g. because its key isn't in the keyring or
+// its data was tampered with. Redelivering such a message won't help, so
+// subscribers would rather terminate it with TermOnDecryptError.
+type DecryptError struct {
+	// KeyID is the ID of the key the message was encrypted with.
+	KeyID string
+
+	Err error
+}
+
+// Error implements error.
+func (e *DecryptError) Error() string {
+	return fmt.Sprintf("jstransport: cannot decrypt message with key %q: %v", e.KeyID, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *DecryptError) Unwrap() error {
+	return e.Err
+}
+
+// Encryption encrypts and decrypts message data with the keys of a keyring.
+type Encryption struct {
+	keyring        Keyring
+	algorithm      EncryptionAlgorithm
+	allowPlaintext bool
+}
+
+// NewEncryption constructs an encryption using the keyring. By default, data
+// is encrypted with AES256GCM.
+func NewEncryption(keyring Keyring, options ...gkit.Option[*Encryption]) *Encryption {
+	e := &Encryption{
+		keyring:   keyring,
+		algorithm: AES256GCM,
+	}
+
+	for _, option := range options {
+		option(e)
+	}
+
+	return e
+}
+
+// EncryptionWithAlgorithm sets the algorithm data is encrypted with. Messages
+// are decrypted with the algorithm named in their EncryptionAlgorithmHeader.
+func EncryptionWithAlgorithm(algorithm EncryptionAlgorithm) gkit.Option[*Encryption] {
+	return func(e *Encryption) { e.algorithm = algorithm }
+}
+
+// EncryptionAllowPlaintext lets DecryptDecoder decode messages that aren't
+// encrypted as is, e.g. while publishers are being migrated to encryption. By
+// default, they fail with a DecryptError, since anyone able to publish could
+// otherwise bypass the encryption, and its authentication, by leaving out the
+// EncryptionAlgorithmHeader.
+func EncryptionAllowPlaintext() gkit.Option[*Encryption] {
+	return func(e *Encryption) { e.allowPlaintext = true }
+}
+
+// EncryptEncoder wraps a publisher encoder. The data of encoded messages is
+// encrypted, and the algorithm and key ID are sent in the message headers.
+func EncryptEncoder[Req any](e *Encryption, enc gkit.EncodeDecodeFunc[Req, *nats.Msg]) gkit.EncodeDecodeFunc[Req, *nats.Msg] {
+	return func(ctx context.Context, request Req) (*nats.Msg, error) {
+		msg, err := enc(ctx, request)
+		if err != nil {
+			return msg, err
+		}
+
+		key, id, wrapped, err := e.keyring.EncryptionKey(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		msg.Data, err = seal(e.algorithm, key, msg.Data, additionalData(e.algorithm, id))
+		if err != nil {
+			return nil, err
+		}
+
+		if msg.Header == nil {
+			msg.Header = nats.Header{}
+		}
+
+		msg.Header.Set(EncryptionAlgorithmHeader, string(e.algorithm))
+		msg.Header.Set(EncryptionKeyIDHeader, id)
+
+		if wrapped != nil {
+			msg.Header.Set(EncryptionDataKeyHeader, base64.StdEncoding.EncodeToString(wrapped))
+		}
+
+		return msg, nil
+	}
+}
+
+// DecryptDecoder wraps a subscriber decoder. Messages are decrypted before
+// being decoded, and fail with a DecryptError if they can't be. Messages
+// lacking the EncryptionAlgorithmHeader fail with a DecryptError wrapping
+// ErrNotEncrypted, unless plaintext is allowed with EncryptionAllowPlaintext,
+// in which case they are decoded as is.
+func DecryptDecoder[Req any](e *Encryption, dec gkit.EncodeDecodeFunc[jetstream.Msg, Req]) gkit.EncodeDecodeFunc[jetstream.Msg, Req] {
+	return func(ctx context.Context, msg jetstream.Msg) (Req, error) {
+		var request Req
+
+		algorithm := EncryptionAlgorithm(msg.Headers().Get(EncryptionAlgorithmHeader))
+		if algorithm == "" {
+			if e.allowPlaintext {
+				return dec(ctx, msg)
+			}
+
+			return request, &DecryptError{Err: ErrNotEncrypted}
+		}
+
+		id := msg.Headers().Get(EncryptionKeyIDHeader)
+
+		data, err := e.decrypt(ctx, msg, algorithm, id)
+		if err != nil {
+			return request, &DecryptError{KeyID: id, Err: err}
+		}
+
+		return dec(ctx, msgWithData{Msg: msg, data: data})
+	}
+}
+
+func (e *Encryption) decrypt(ctx context.Context, msg jetstream.Msg, algorithm EncryptionAlgorithm, id string) ([]byte, error) {
+	var wrapped []byte
+
+	if header := msg.Headers().Get(EncryptionDataKeyHeader); header != "" {
+		var err error
+
+		wrapped, err = base64.StdEncoding.DecodeString(header)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := e.keyring.DecryptionKey(ctx, id, wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(algorithm, key, msg.Data(), additionalData(algorithm, id))
+}
+
+// TermOnDecryptError is an AckPolicy terminating messages failing with a
+// DecryptError, and negatively acknowledging messages failing otherwise.
+func TermOnDecryptError(ctx context.Context, err error) AckDisposition {
+	var decryptErr *DecryptError
+	if errors.As(err, &decryptErr) {
+		return AckDispositionTerm
+	}
+
+	return DefaultAckPolicy(ctx, err)
+}
+
+// additionalData binds the ciphertext to the algorithm and the key ID it was
+// sent with, so that tampering with the headers fails the decryption.
+func additionalData(algorithm EncryptionAlgorithm, id string) []byte {
+	return []byte(string(algorithm) + "\x00" + id)
+}
+
+// seal encrypts plaintext with a random nonce, which is prepended to the
+// ciphertext.
+func seal(algorithm EncryptionAlgorithm, key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := algorithm.aead(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// open decrypts ciphertext sealed by seal.
+func open(algorithm EncryptionAlgorithm, key, ciphertext, additionalData []byte) ([]byte, error) {
+	aead, err := algorithm.aead(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("jstransport: ciphertext too short")
+	}
+
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
@@ -0,0 +1,178 @@
+//go:build unit
+
+package jetstream_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	jstransport "github.com/kikihakiem/gkit/transport/jetstream"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func encryptString(t *testing.T, e *jstransport.Encryption, s string) *nats.Msg {
+	t.Helper()
+
+	enc := jstransport.EncryptEncoder(e, func(_ context.Context, s string) (*nats.Msg, error) {
+		msg := nats.NewMsg("jstransport.test.encrypt")
+		msg.Data = []byte(s)
+
+		return msg, nil
+	})
+
+	msg, err := enc(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return msg
+}
+
+func decryptString(e *jstransport.Encryption, msg *nats.Msg) (string, error) {
+	dec := jstransport.DecryptDecoder(e, func(_ context.Context, msg jetstream.Msg) (string, error) {
+		return string(msg.Data()), nil
+	})
+
+	return dec(context.Background(), &messageMock{subject: msg.Subject, data: msg.Data, headers: msg.Header})
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	keyring := jstransport.NewStaticKeyring("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+
+	for name, e := range map[string]*jstransport.Encryption{
+		"aes-gcm":          jstransport.NewEncryption(keyring),
+		"xchacha":          jstransport.NewEncryption(keyring, jstransport.EncryptionWithAlgorithm(jstransport.XChaCha20Poly1305)),
+		"envelope":         jstransport.NewEncryption(jstransport.NewEnvelopeKeyring(keyring)),
+		"envelope-xchacha": jstransport.NewEncryption(jstransport.NewEnvelopeKeyring(keyring), jstransport.EncryptionWithAlgorithm(jstransport.XChaCha20Poly1305)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			msg := encryptString(t, e, "personal data")
+			if bytes.Contains(msg.Data, []byte("personal data")) {
+				t.Fatal("want data encrypted")
+			}
+
+			if want, have := "k1", msg.Header.Get(jstransport.EncryptionKeyIDHeader); want != have {
+				t.Errorf("want key ID %q, have %q", want, have)
+			}
+
+			have, err := decryptString(e, msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want := "personal data"; want != have {
+				t.Errorf("want %q, have %q", want, have)
+			}
+		})
+	}
+}
+
+func TestEncryptionKeyRotation(t *testing.T) {
+	keys := map[string][]byte{
+		"k1": bytes.Repeat([]byte{1}, 32),
+		"k2": bytes.Repeat([]byte{2}, 32),
+	}
+
+	old := encryptString(t, jstransport.NewEncryption(jstransport.NewStaticKeyring("k1", keys)), "old")
+
+	e := jstransport.NewEncryption(jstransport.NewStaticKeyring("k2", keys))
+
+	msg := encryptString(t, e, "new")
+	if want, have := "k2", msg.Header.Get(jstransport.EncryptionKeyIDHeader); want != have {
+		t.Errorf("want key ID %q, have %q", want, have)
+	}
+
+	have, err := decryptString(e, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "old"; want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+}
+
+func TestDecryptError(t *testing.T) {
+	keyring := jstransport.NewStaticKeyring("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+	e := jstransport.NewEncryption(keyring)
+
+	for name, tamper := range map[string]func(*nats.Msg){
+		"unknown key":   func(msg *nats.Msg) { msg.Header.Set(jstransport.EncryptionKeyIDHeader, "k0") },
+		"tampered data": func(msg *nats.Msg) { msg.Data[len(msg.Data)-1] ^= 1 },
+		"tampered algorithm": func(msg *nats.Msg) {
+			msg.Header.Set(jstransport.EncryptionAlgorithmHeader, string(jstransport.XChaCha20Poly1305))
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			msg := encryptString(t, e, "personal data")
+			tamper(msg)
+
+			_, err := decryptString(e, msg)
+
+			var decryptErr *jstransport.DecryptError
+			if !errors.As(err, &decryptErr) {
+				t.Fatalf("want DecryptError, have %v", err)
+			}
+		})
+	}
+}
+
+func TestDecryptPlaintext(t *testing.T) {
+	keyring := jstransport.NewStaticKeyring("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+	plaintext := &nats.Msg{Subject: "jstransport.test.encrypt", Data: []byte("forged"), Header: nats.Header{}}
+
+	_, err := decryptString(jstransport.NewEncryption(keyring), plaintext)
+
+	var decryptErr *jstransport.DecryptError
+	if !errors.As(err, &decryptErr) || !errors.Is(err, jstransport.ErrNotEncrypted) {
+		t.Errorf("want DecryptError wrapping ErrNotEncrypted, have %v", err)
+	}
+
+	have, err := decryptString(jstransport.NewEncryption(keyring, jstransport.EncryptionAllowPlaintext()), plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "forged"; want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+}
+
+func TestTermOnDecryptError(t *testing.T) {
+	keyring := jstransport.NewStaticKeyring("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+	e := jstransport.NewEncryption(keyring)
+
+	subscriber := jstransport.NewSubscriber(
+		func(context.Context, string) (struct{}, error) { return struct{}{}, errors.New("dang") },
+		jstransport.DecryptDecoder(e, func(_ context.Context, msg jetstream.Msg) (string, error) {
+			return string(msg.Data()), nil
+		}),
+		gkit.NopResponseEncoder,
+		jstransport.SubscriberErrorEncoder[string, struct{}](func(context.Context, jetstream.JetStream, error) {}),
+		jstransport.SubscriberErrorHandler[string, struct{}](gkit.ErrorHandlerFunc(func(context.Context, error) {})),
+		jstransport.SubscriberAckPolicy[string, struct{}](jstransport.TermOnDecryptError),
+	)
+
+	msg := encryptString(t, e, "personal data")
+	msg.Header.Set(jstransport.EncryptionKeyIDHeader, "k0")
+
+	undecryptable := &messageMock{subject: msg.Subject, data: msg.Data, headers: msg.Header}
+	subscriber.HandleMessage(nil)(undecryptable)
+
+	decrypted := encryptString(t, e, "personal data")
+
+	failing := &messageMock{subject: decrypted.Subject, data: decrypted.Data, headers: decrypted.Header}
+	subscriber.HandleMessage(nil)(failing)
+
+	if want, have := []string{"term"}, undecryptable.acks; len(have) != 1 || want[0] != have[0] {
+		t.Errorf("want %v, have %v", want, have)
+	}
+
+	if want, have := []string{"nak"}, failing.acks; len(have) != 1 || want[0] != have[0] {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
@@ -0,0 +1,108 @@
+package jetstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// ExpectFunc derives an expectation of an outgoing message from the request or
+// the context. It reports false when the message shouldn't carry any.
+type ExpectFunc[Req, T any] func(ctx context.Context, request Req) (T, bool)
+
+// ExpectedLastSubjectSequenceFromContext returns an ExpectFunc that takes the
+// expected last subject sequence from the context, where it was put by
+// WithExpectedLastSubjectSequence.
+func ExpectedLastSubjectSequenceFromContext[Req any]() ExpectFunc[Req, uint64] {
+	return func(ctx context.Context, _ Req) (uint64, bool) {
+		seq, ok := ctx.Value(ContextKeyExpectedLastSubjectSequence).(uint64)
+		return seq, ok
+	}
+}
+
+// ExpectedLastMsgIDFromContext returns an ExpectFunc that takes the expected
+// last message ID from the context, where it was put by WithExpectedLastMsgID.
+func ExpectedLastMsgIDFromContext[Req any]() ExpectFunc[Req, string] {
+	return func(ctx context.Context, _ Req) (string, bool) {
+		id, ok := ctx.Value(ContextKeyExpectedLastMsgID).(string)
+		return id, ok
+	}
+}
+
+// WithExpectedLastSubjectSequence returns a context carrying the sequence of
+// the last message the caller read from the subject it is about to publish to.
+// Zero means the subject is expected to have no message yet.
+func WithExpectedLastSubjectSequence(ctx context.Context, seq uint64) context.Context {
+	return context.WithValue(ctx, ContextKeyExpectedLastSubjectSequence, seq)
+}
+
+// WithExpectedLastMsgID returns a context carrying the ID of the last message
+// the caller expects the stream to hold.
+func WithExpectedLastMsgID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ContextKeyExpectedLastMsgID, id)
+}
+
+// ErrConflict is matched by errors.Is for every ConflictError.
+var ErrConflict = errors.New("jstransport: conflict")
+
+// ConflictError is returned by Publisher when the server rejects a message
+// because one of its expectations doesn't hold, e.g. someone else has written
+// to the subject since the expected last subject sequence.
+type ConflictError struct {
+	// Subject is the subject the message was published to.
+	Subject string
+
+	// Code is the JetStream error code of the rejection.
+	Code jetstream.ErrorCode
+
+	// Err is the API error returned by the server.
+	Err error
+}
+
+// Error implements error.
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("jstransport: conflict publishing to %s: %v", e.Subject, e.Err)
+}
+
+// Unwrap returns the API error returned by the server.
+func (e *ConflictError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrConflict.
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict //nolint:errorlint
+}
+
+const (
+	errCodeStreamWrongLastMsgID jetstream.ErrorCode = 10070
+	errCodeStreamNotMatch       jetstream.ErrorCode = 10060
+)
+
+// expectHeader returns a prepareFunc setting an expectation header.
+func expectHeader[Req, T any](header string, f ExpectFunc[Req, T], format func(T) string) prepareFunc[Req] {
+	return func(ctx context.Context, request Req, msg *nats.Msg) {
+		value, ok := f(ctx, request)
+		if !ok {
+			return
+		}
+
+		if msg.Header == nil {
+			msg.Header = nats.Header{}
+		}
+
+		msg.Header.Set(header, format(value))
+	}
+}
+
+func formatSequence(seq uint64) string {
+	return strconv.FormatUint(seq, 10)
+}
+
+func formatString(s string) string {
+	return s
+}
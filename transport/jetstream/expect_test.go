@@ -0,0 +1,128 @@
+//go:build unit
+
+package jetstream_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	jstransport "github.com/kikihakiem/gkit/transport/jetstream"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func decodePubAck(_ context.Context, ack *jetstream.PubAck) (*jetstream.PubAck, error) {
+	return ack, nil
+}
+
+func TestPublisherExpectLastSubjectSequence(t *testing.T) {
+	js, _, stop := newJetstream(context.Background(), t)
+	defer stop()
+
+	subject := "jstransport.entity.42"
+
+	publisher := jstransport.NewPublisher(
+		js,
+		func(_ context.Context, data string) (*nats.Msg, error) {
+			msg := nats.NewMsg(subject)
+			msg.Data = []byte(data)
+
+			return msg, nil
+		},
+		decodePubAck,
+		jstransport.PublisherExpectLastSubjectSequence[string, *jetstream.PubAck](jstransport.ExpectedLastSubjectSequenceFromContext[string]()),
+	).Endpoint()
+
+	ack, err := publisher(jstransport.WithExpectedLastSubjectSequence(context.Background(), 0), "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = publisher(jstransport.WithExpectedLastSubjectSequence(context.Background(), 0), "stale")
+
+	var conflict *jstransport.ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("want conflict error, have %v", err)
+	}
+
+	if !errors.Is(err, jstransport.ErrConflict) {
+		t.Errorf("want error matching ErrConflict, have %v", err)
+	}
+
+	if want, have := jetstream.JSErrCodeStreamWrongLastSequence, conflict.Code; want != have {
+		t.Errorf("want %v, have %v", want, have)
+	}
+
+	if want, have := subject, conflict.Subject; want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+
+	if _, err := publisher(jstransport.WithExpectedLastSubjectSequence(context.Background(), ack.Sequence), "second"); err != nil {
+		t.Fatal(err)
+	}
+
+	// without an expectation in the context, the message is published unconditionally.
+	if _, err := publisher(context.Background(), "third"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPublisherExpectStream(t *testing.T) {
+	js, _, stop := newJetstream(context.Background(), t)
+	defer stop()
+
+	publisher := jstransport.NewPublisher(
+		js,
+		func(_ context.Context, data string) (*nats.Msg, error) {
+			msg := nats.NewMsg("jstransport.test.99")
+			msg.Data = []byte(data)
+
+			return msg, nil
+		},
+		decodePubAck,
+		jstransport.PublisherExpectStream[string, *jetstream.PubAck]("other:stream"),
+	).Endpoint()
+
+	if _, err := publisher(context.Background(), "data"); !errors.Is(err, jstransport.ErrConflict) {
+		t.Errorf("want conflict error, have %v", err)
+	}
+}
+
+func TestPublisherExpectLastMsgID(t *testing.T) {
+	type event struct {
+		ID     string
+		LastID string
+	}
+
+	msgs := make(chan *nats.Msg, 1)
+
+	publisher := jstransport.NewPublisher(
+		&jetstreamMock{dataChan: make(chan string, 2)},
+		jstransport.EncodeJSONRequest[event],
+		decodePubAck,
+		jstransport.PublisherExpectLastMsgID[event, *jetstream.PubAck](func(_ context.Context, e event) (string, bool) {
+			return e.LastID, e.LastID != ""
+		}),
+		jstransport.PublisherBefore[event, *jetstream.PubAck](func(ctx context.Context, msg *nats.Msg) context.Context {
+			msgs <- msg
+			return ctx
+		}),
+	).Endpoint()
+
+	for _, test := range []struct {
+		event event
+		want  string
+	}{
+		{event{ID: "2", LastID: "1"}, "1"},
+		{event{ID: "1"}, ""},
+	} {
+		if _, err := publisher(context.Background(), test.event); err != nil {
+			t.Fatal(err)
+		}
+
+		if have := (<-msgs).Header.Get(jetstream.ExpectedLastMsgIDHeader); test.want != have {
+			t.Errorf("want %q, have %q", test.want, have)
+		}
+	}
+}
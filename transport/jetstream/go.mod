@@ -3,19 +3,22 @@ module github.com/kikihakiem/gkit/transport/jetstream
 go 1.21.6
 
 require (
-	github.com/kikihakiem/gkit/core v0.4.0
+	github.com/kikihakiem/gkit/core v0.5.0
 	github.com/nats-io/nats-server/v2 v2.10.10
 	github.com/nats-io/nats.go v1.32.0
+	github.com/nats-io/nkeys v0.4.7
+	github.com/nats-io/nuid v1.0.1
+	golang.org/x/crypto v0.18.0
+	golang.org/x/time v0.5.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/klauspost/compress v1.17.5 // indirect
 	github.com/minio/highwayhash v1.0.2 // indirect
 	github.com/nats-io/jwt/v2 v2.5.3 // indirect
-	github.com/nats-io/nkeys v0.4.7 // indirect
-	github.com/nats-io/nuid v1.0.1 // indirect
-	golang.org/x/crypto v0.18.0 // indirect
 	golang.org/x/sys v0.16.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
-	golang.org/x/time v0.5.0 // indirect
 )
+
+replace github.com/kikihakiem/gkit/core => ../../core
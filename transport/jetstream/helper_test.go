@@ -20,6 +20,7 @@ func newNATSConn(t *testing.T) (*server.Server, *nats.Conn) {
 	opts := natsserver.DefaultTestOptions
 	opts.Port = -1
 	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
 	srv := natsserver.RunServer(&opts)
 
 	nc, err := nats.Connect(srv.ClientURL())
@@ -105,3 +106,26 @@ func (jm *jetstreamMock) PublishMsg(_ context.Context, msg *nats.Msg, _ ...jetst
 	jm.dataChan <- string(msg.Data)
 	return nil, nil
 }
+
+type messageMock struct {
+	jetstream.Msg
+	subject string
+	data    []byte
+	headers nats.Header
+	acks    []string
+}
+
+func (m *messageMock) Subject() string      { return m.subject }
+func (m *messageMock) Data() []byte         { return m.data }
+func (m *messageMock) Headers() nats.Header { return m.headers }
+func (m *messageMock) Reply() string        { return "" }
+func (m *messageMock) Ack() error           { m.acks = append(m.acks, "ack"); return nil }
+func (m *messageMock) Nak() error           { m.acks = append(m.acks, "nak"); return nil }
+func (m *messageMock) Term() error          { m.acks = append(m.acks, "term"); return nil }
+
+func (m *messageMock) Metadata() (*jetstream.MsgMetadata, error) { return nil, jetstream.ErrNotJSMessage }
+
+func (m *messageMock) NakWithDelay(time.Duration) error {
+	m.acks = append(m.acks, "nak")
+	return nil
+}
@@ -0,0 +1,127 @@
+package jetstream
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// ErrDuplicateWindowTooShort is reported to the error handler of a publisher or
+// an outbox relay whose retry budget exceeds the duplicate window of the stream
+// it publishes to.
+var ErrDuplicateWindowTooShort = errors.New("jstransport: duplicate window shorter than retry budget")
+
+// MsgIDFunc derives the Nats-Msg-Id header of an outgoing message from the
+// request it was encoded from. JetStream uses that header to discard messages
+// it has already stored within the stream's duplicate window.
+type MsgIDFunc[Req any] func(ctx context.Context, request Req, msg *nats.Msg) (string, error)
+
+// MsgIDFromField returns a MsgIDFunc that uses the named field of the request
+// struct (or pointer to struct) as the message ID. The field is formatted with
+// fmt.Sprint, so strings, numbers and fmt.Stringer values are all supported.
+func MsgIDFromField[Req any](name string) MsgIDFunc[Req] {
+	return func(_ context.Context, request Req, _ *nats.Msg) (string, error) {
+		v := reflect.Indirect(reflect.ValueOf(request))
+		if v.Kind() != reflect.Struct {
+			return "", fmt.Errorf("jstransport: cannot take message ID field %q from %T", name, request)
+		}
+
+		field := v.FieldByName(name)
+		if !field.IsValid() {
+			return "", fmt.Errorf("jstransport: %T has no message ID field %q", request, name)
+		}
+
+		if !field.CanInterface() {
+			return "", fmt.Errorf("jstransport: message ID field %q of %T is unexported", name, request)
+		}
+
+		return fmt.Sprint(field.Interface()), nil
+	}
+}
+
+// MsgIDFromContentHash returns a MsgIDFunc that uses the SHA-256 hash of the
+// encoded message subject and data as the message ID. Publishing the same
+// content twice is therefore treated as a duplicate.
+func MsgIDFromContentHash[Req any]() MsgIDFunc[Req] {
+	return func(_ context.Context, _ Req, msg *nats.Msg) (string, error) {
+		h := sha256.New()
+		h.Write([]byte(msg.Subject))
+		h.Write([]byte{0})
+		h.Write(msg.Data)
+
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+}
+
+// PublishOutcome tells how the server handled a published message. It is
+// populated in the context under ContextKeyPublishOutcome before the publish
+// ack is decoded.
+type PublishOutcome int
+
+const (
+	// PublishOutcomeStored means the message was stored in the stream.
+	PublishOutcomeStored PublishOutcome = iota
+
+	// PublishOutcomeDuplicate means the server recognized the message ID and
+	// discarded the message as a duplicate of one already stored.
+	PublishOutcomeDuplicate
+
+	// PublishOutcomeSpooled means the message couldn't reach the server and was
+	// appended to the spool of the publisher, to be forwarded later.
+	PublishOutcomeSpooled
+)
+
+// String implements fmt.Stringer.
+func (o PublishOutcome) String() string {
+	switch o {
+	case PublishOutcomeStored:
+		return "stored"
+	case PublishOutcomeDuplicate:
+		return "duplicate"
+	case PublishOutcomeSpooled:
+		return "spooled"
+	default:
+		return "unknown"
+	}
+}
+
+// PublishOutcomeFromContext returns the PublishOutcome populated in the
+// context by the publisher.
+func PublishOutcomeFromContext(ctx context.Context) (PublishOutcome, bool) {
+	outcome, ok := ctx.Value(ContextKeyPublishOutcome).(PublishOutcome)
+	return outcome, ok
+}
+
+func publishOutcome(ack *jetstream.PubAck) PublishOutcome {
+	if ack != nil && ack.Duplicate {
+		return PublishOutcomeDuplicate
+	}
+
+	return PublishOutcomeStored
+}
+
+// checkDuplicateWindow reports an error when the duplicate window of the
+// stream the message was stored in is shorter than the retry budget, in which
+// case a late retry would be stored again instead of being detected as a
+// duplicate.
+func checkDuplicateWindow(ctx context.Context, js jetstream.JetStream, streamName string, retryBudget time.Duration, errorHandler gkit.ErrorHandler) {
+	stream, err := js.Stream(ctx, streamName)
+	if err != nil {
+		errorHandler.Handle(ctx, fmt.Errorf("jstransport: unable to check duplicate window of stream %s: %w", streamName, err))
+		return
+	}
+
+	window := stream.CachedInfo().Config.Duplicates
+	if window < retryBudget {
+		errorHandler.Handle(ctx, fmt.Errorf("%w: stream %s has a duplicate window of %s, retry budget is %s",
+			ErrDuplicateWindowTooShort, streamName, window, retryBudget))
+	}
+}
@@ -0,0 +1,208 @@
+package jetstream
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// OutboxMessage is a message recorded in an outbox, waiting to be published.
+type OutboxMessage struct {
+	// ID identifies the message. It is published as the Nats-Msg-Id header, so
+	// that a message published again after a crash is discarded by the server
+	// as a duplicate.
+	ID string
+
+	Subject string
+	Header  nats.Header
+	Data    []byte
+
+	// Attempts is the number of failed attempts to publish the message.
+	Attempts int
+}
+
+// OutboxStore persists the messages of an outbox. Messages are recorded in the
+// same transaction as the business data they relate to, and then published by
+// an OutboxRelay.
+type OutboxStore interface {
+	// Claim returns up to limit messages due to be published, and hides them
+	// from other claims for the lease duration, so that concurrent relays don't
+	// publish them at once.
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]OutboxMessage, error)
+
+	// MarkDispatched records that the message was published.
+	MarkDispatched(ctx context.Context, id string) error
+
+	// MarkFailed records a failed attempt to publish the message, which is to
+	// be retried at retryAt.
+	MarkFailed(ctx context.Context, id string, retryAt time.Time, cause error) error
+}
+
+// OutboxBackoff returns how long to wait before retrying a message that failed
+// to be published the given number of times.
+type OutboxBackoff func(attempts int) time.Duration
+
+// ExponentialOutboxBackoff returns an OutboxBackoff doubling the delay after
+// every attempt, starting from base and capped to maxDelay.
+func ExponentialOutboxBackoff(base, maxDelay time.Duration) OutboxBackoff {
+	return func(attempts int) time.Duration {
+		delay := base
+		for i := 1; i < attempts && delay < maxDelay; i++ {
+			delay *= 2
+		}
+
+		if delay > maxDelay {
+			return maxDelay
+		}
+
+		return delay
+	}
+}
+
+// OutboxRelay publishes the messages recorded in an outbox store to JetStream.
+// A message failing to be published may have been stored by the server anyway,
+// e.g. when the acknowledgement was lost, so it must be published again within
+// the duplicate window of the stream to be discarded as a duplicate. Both the
+// backoff and the lease must thus be shorter than the window, which is checked
+// on the first successful publish, see OutboxRelayRetryBudget.
+type OutboxRelay struct {
+	store        OutboxStore
+	js           jetstream.JetStream
+	interval     time.Duration
+	batchSize    int
+	lease        time.Duration
+	backoff      OutboxBackoff
+	retryBudget  time.Duration
+	windowCheck  sync.Once
+	errorHandler gkit.ErrorHandler
+}
+
+// NewOutboxRelay constructs a relay publishing the messages of the store. By
+// default, it polls the store every second for batches of up to 100 messages,
+// and retries failed messages with an exponential backoff from one second up
+// to one minute, which is within the default duplicate window of two minutes.
+func NewOutboxRelay(store OutboxStore, js jetstream.JetStream, options ...gkit.Option[*OutboxRelay]) *OutboxRelay {
+	r := &OutboxRelay{
+		store:        store,
+		js:           js,
+		interval:     time.Second,
+		batchSize:    100,
+		lease:        30 * time.Second,
+		backoff:      ExponentialOutboxBackoff(time.Second, time.Minute),
+		retryBudget:  time.Minute,
+		errorHandler: gkit.LogErrorHandler(nil),
+	}
+
+	for _, option := range options {
+		option(r)
+	}
+
+	return r
+}
+
+// OutboxRelayInterval sets how often the store is polled.
+func OutboxRelayInterval(interval time.Duration) gkit.Option[*OutboxRelay] {
+	return func(r *OutboxRelay) { r.interval = interval }
+}
+
+// OutboxRelayBatchSize sets how many messages are claimed at once.
+func OutboxRelayBatchSize(batchSize int) gkit.Option[*OutboxRelay] {
+	return func(r *OutboxRelay) { r.batchSize = batchSize }
+}
+
+// OutboxRelayLease sets how long claimed messages are hidden from other relays.
+// It should be longer than publishing a batch takes.
+func OutboxRelayLease(lease time.Duration) gkit.Option[*OutboxRelay] {
+	return func(r *OutboxRelay) { r.lease = lease }
+}
+
+// OutboxRelayBackoff sets the delay before retrying failed messages. Its
+// longest delay should be set with OutboxRelayRetryBudget as well.
+func OutboxRelayBackoff(backoff OutboxBackoff) gkit.Option[*OutboxRelay] {
+	return func(r *OutboxRelay) { r.backoff = backoff }
+}
+
+// OutboxRelayRetryBudget sets the longest delay before a failed message is
+// published again. On the first successful publish, ErrDuplicateWindowTooShort
+// is reported to the error handler if the stream's duplicate window is shorter
+// than the retry budget or the lease. By default, it is one minute, the longest
+// delay of the default backoff.
+func OutboxRelayRetryBudget(retryBudget time.Duration) gkit.Option[*OutboxRelay] {
+	return func(r *OutboxRelay) { r.retryBudget = retryBudget }
+}
+
+// OutboxRelayErrorHandler is used to handle errors claiming, publishing or
+// marking messages. By default, they are logged.
+func OutboxRelayErrorHandler(errorHandler gkit.ErrorHandler) gkit.Option[*OutboxRelay] {
+	return func(r *OutboxRelay) { r.errorHandler = errorHandler }
+}
+
+// Run relays messages until ctx is done.
+func (r *OutboxRelay) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := r.RelayOnce(ctx)
+			if err != nil {
+				r.errorHandler.Handle(ctx, err)
+			}
+
+			// keep going while there is a backlog.
+			if err != nil || n < r.batchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayOnce claims a batch of messages and publishes them. It returns the
+// number of messages claimed. Messages failing to be published are marked to
+// be retried after the backoff.
+func (r *OutboxRelay) RelayOnce(ctx context.Context) (int, error) {
+	msgs, err := r.store.Claim(ctx, r.batchSize, r.lease)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, m := range msgs {
+		msg := &nats.Msg{Subject: m.Subject, Header: nats.Header{}, Data: m.Data}
+		for k, v := range m.Header {
+			msg.Header[k] = v
+		}
+
+		msg.Header.Set(jetstream.MsgIDHeader, m.ID)
+
+		ack, err := r.js.PublishMsg(ctx, msg)
+		if err != nil {
+			r.errorHandler.Handle(ctx, err)
+
+			if err := r.store.MarkFailed(ctx, m.ID, time.Now().Add(r.backoff(m.Attempts+1)), err); err != nil {
+				r.errorHandler.Handle(ctx, err)
+			}
+
+			continue
+		}
+
+		r.windowCheck.Do(func() {
+			checkDuplicateWindow(ctx, r.js, ack.Stream, max(r.retryBudget, r.lease), r.errorHandler)
+		})
+
+		if err := r.store.MarkDispatched(ctx, m.ID); err != nil {
+			r.errorHandler.Handle(ctx, err)
+		}
+	}
+
+	return len(msgs), nil
+}
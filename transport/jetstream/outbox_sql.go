@@ -0,0 +1,196 @@
+package jetstream
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nuid"
+)
+
+// SQLOutboxSchema is the SQLite schema of the outbox table, with the table name
+// as the format verb. Column types may need to be adapted for other databases,
+// e.g. BYTEA instead of BLOB on PostgreSQL. Times are stored as Unix
+// nanoseconds.
+const SQLOutboxSchema = `CREATE TABLE IF NOT EXISTS %s (
+	id              TEXT PRIMARY KEY,
+	subject         TEXT NOT NULL,
+	header          TEXT NOT NULL,
+	data            BLOB,
+	attempts        INTEGER NOT NULL DEFAULT 0,
+	last_error      TEXT,
+	created_at      BIGINT NOT NULL,
+	next_attempt_at BIGINT NOT NULL,
+	claimed_until   BIGINT NOT NULL DEFAULT 0,
+	dispatched_at   BIGINT
+)`
+
+// SQLExecer is implemented by *sql.DB, *sql.Tx and *sql.Conn.
+type SQLExecer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// SQLOutboxStore is an OutboxStore backed by a database/sql table.
+type SQLOutboxStore struct {
+	db          *sql.DB
+	table       string
+	placeholder func(n int) string
+}
+
+// NewSQLOutboxStore constructs an outbox store using the given table, which
+// must have the columns of SQLOutboxSchema. By default, queries use ? as
+// placeholder.
+func NewSQLOutboxStore(db *sql.DB, table string, options ...gkit.Option[*SQLOutboxStore]) *SQLOutboxStore {
+	s := &SQLOutboxStore{
+		db:          db,
+		table:       table,
+		placeholder: func(int) string { return "?" },
+	}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	return s
+}
+
+// SQLOutboxDollarPlaceholders makes queries use $1, $2, ... as placeholders,
+// as PostgreSQL expects.
+func SQLOutboxDollarPlaceholders() gkit.Option[*SQLOutboxStore] {
+	return func(s *SQLOutboxStore) {
+		s.placeholder = func(n int) string { return "$" + strconv.Itoa(n) }
+	}
+}
+
+// CreateTable creates the outbox table with SQLOutboxSchema if it doesn't exist.
+func (s *SQLOutboxStore) CreateTable(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(SQLOutboxSchema, s.table))
+	return err
+}
+
+// Record inserts the message into the outbox with tx, which should be the
+// transaction writing the business data the message relates to. The message
+// ID is taken from the Nats-Msg-Id header, or generated if missing, and
+// returned.
+func (s *SQLOutboxStore) Record(ctx context.Context, tx SQLExecer, msg *nats.Msg) (string, error) {
+	id := msg.Header.Get(jetstream.MsgIDHeader)
+	if id == "" {
+		id = nuid.Next()
+	}
+
+	header, err := json.Marshal(msg.Header)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UnixNano()
+
+	_, err = tx.ExecContext(ctx, s.query(
+		"INSERT INTO %s (id, subject, header, data, created_at, next_attempt_at) VALUES (%s, %s, %s, %s, %s, %s)", 6),
+		id, msg.Subject, string(header), msg.Data, now, now,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// Claim implements OutboxStore. Messages are claimed one by one with a
+// conditional update, so that concurrent relays never claim the same message
+// without relying on row locking.
+func (s *SQLOutboxStore) Claim(ctx context.Context, limit int, lease time.Duration) ([]OutboxMessage, error) {
+	now := time.Now().UnixNano()
+
+	rows, err := s.db.QueryContext(ctx, s.query(
+		"SELECT id, subject, header, data, attempts FROM %s"+
+			" WHERE dispatched_at IS NULL AND next_attempt_at <= %s AND claimed_until <= %s"+
+			" ORDER BY created_at LIMIT %s", 3),
+		now, now, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []OutboxMessage
+
+	for rows.Next() {
+		var (
+			m      OutboxMessage
+			header string
+		)
+
+		if err := rows.Scan(&m.ID, &m.Subject, &header, &m.Data, &m.Attempts); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		if err := json.Unmarshal([]byte(header), &m.Header); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		candidates = append(candidates, m)
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	claimed := candidates[:0]
+
+	for _, m := range candidates {
+		res, err := s.db.ExecContext(ctx, s.query(
+			"UPDATE %s SET claimed_until = %s WHERE id = %s AND dispatched_at IS NULL AND claimed_until <= %s", 3),
+			now+int64(lease), m.ID, now,
+		)
+		if err != nil {
+			return claimed, err
+		}
+
+		if n, err := res.RowsAffected(); err == nil && n == 1 {
+			claimed = append(claimed, m)
+		}
+	}
+
+	return claimed, nil
+}
+
+// MarkDispatched implements OutboxStore.
+func (s *SQLOutboxStore) MarkDispatched(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, s.query("UPDATE %s SET dispatched_at = %s WHERE id = %s", 2),
+		time.Now().UnixNano(), id,
+	)
+
+	return err
+}
+
+// MarkFailed implements OutboxStore.
+func (s *SQLOutboxStore) MarkFailed(ctx context.Context, id string, retryAt time.Time, cause error) error {
+	_, err := s.db.ExecContext(ctx, s.query(
+		"UPDATE %s SET attempts = attempts + 1, last_error = %s, next_attempt_at = %s, claimed_until = 0 WHERE id = %s", 3),
+		cause.Error(), retryAt.UnixNano(), id,
+	)
+
+	return err
+}
+
+// query formats the table name and n placeholders into the query.
+func (s *SQLOutboxStore) query(format string, n int) string {
+	args := make([]any, 0, n+1)
+	args = append(args, s.table)
+
+	for i := 1; i <= n; i++ {
+		args = append(args, s.placeholder(i))
+	}
+
+	return fmt.Sprintf(format, args...)
+}
@@ -0,0 +1,90 @@
+//go:build unit
+
+package jetstream_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	jstransport "github.com/kikihakiem/gkit/transport/jetstream"
+)
+
+// memoryOutboxStore hands out its messages once, and records the dispatched ones.
+type memoryOutboxStore struct {
+	msgs       []jstransport.OutboxMessage
+	dispatched []string
+}
+
+func (s *memoryOutboxStore) Claim(context.Context, int, time.Duration) ([]jstransport.OutboxMessage, error) {
+	msgs := s.msgs
+	s.msgs = nil
+
+	return msgs, nil
+}
+
+func (s *memoryOutboxStore) MarkDispatched(_ context.Context, id string) error {
+	s.dispatched = append(s.dispatched, id)
+	return nil
+}
+
+func (s *memoryOutboxStore) MarkFailed(context.Context, string, time.Time, error) error {
+	return nil
+}
+
+func TestOutboxRelayDuplicateWindow(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	js, _, stop := newJetstream(ctx, t)
+	defer stop()
+
+	for _, tc := range []struct {
+		name    string
+		options []gkit.Option[*jstransport.OutboxRelay]
+		want    bool
+	}{
+		{name: "default"},
+		{name: "retry budget", options: []gkit.Option[*jstransport.OutboxRelay]{jstransport.OutboxRelayRetryBudget(5 * time.Minute)}, want: true},
+		{name: "lease", options: []gkit.Option[*jstransport.OutboxRelay]{jstransport.OutboxRelayLease(5 * time.Minute)}, want: true},
+	} {
+		var tooShort bool
+
+		store := &memoryOutboxStore{msgs: []jstransport.OutboxMessage{
+			{ID: tc.name + "-1", Subject: "jstransport.outbox.orders"},
+			{ID: tc.name + "-2", Subject: "jstransport.outbox.orders"},
+		}}
+
+		options := append(tc.options, jstransport.OutboxRelayErrorHandler(gkit.ErrorHandlerFunc(func(_ context.Context, err error) {
+			if !errors.Is(err, jstransport.ErrDuplicateWindowTooShort) {
+				t.Errorf("%s: unexpected error %v", tc.name, err)
+			}
+
+			tooShort = true
+		})))
+
+		if _, err := jstransport.NewOutboxRelay(store, js, options...).RelayOnce(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		if want, have := 2, len(store.dispatched); want != have {
+			t.Errorf("%s: want %d dispatched, have %d", tc.name, want, have)
+		}
+
+		if want, have := tc.want, tooShort; want != have {
+			t.Errorf("%s: want duplicate window reported too short %t, have %t", tc.name, want, have)
+		}
+	}
+}
+
+func TestExponentialOutboxBackoff(t *testing.T) {
+	backoff := jstransport.ExponentialOutboxBackoff(time.Second, 5*time.Second)
+
+	for attempts, want := range []time.Duration{time.Second, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if have := backoff(attempts); want != have {
+			t.Errorf("%d attempts: want %v, have %v", attempts, want, have)
+		}
+	}
+}
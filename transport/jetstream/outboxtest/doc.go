@@ -0,0 +1,4 @@
+// Package outboxtest tests the SQL outbox store of the jetstream transport
+// against SQLite. It's a module of its own, so that the SQLite driver isn't a
+// requirement of the transport.
+package outboxtest
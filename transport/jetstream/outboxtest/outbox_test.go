@@ -0,0 +1,206 @@
+//go:build unit
+
+package outboxtest_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	jstransport "github.com/kikihakiem/gkit/transport/jetstream"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	_ "modernc.org/sqlite"
+)
+
+func newJetstream(ctx context.Context, t *testing.T) (jetstream.JetStream, jetstream.Stream, func()) {
+	t.Helper()
+
+	opts := natsserver.DefaultTestOptions
+	opts.Port = -1
+	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
+	srv := natsserver.RunServer(&opts)
+
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	stream, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:     "test:stream",
+		Subjects: []string{"jstransport.>"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	return js, stream, func() {
+		nc.Close()
+		srv.Shutdown()
+		srv.WaitForShutdown()
+	}
+}
+
+type failingJetStream struct {
+	jetstream.JetStream
+}
+
+func (failingJetStream) PublishMsg(context.Context, *nats.Msg, ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	return nil, errors.New("dang")
+}
+
+func TestOutboxRelay(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	js, stream, stop := newJetstream(ctx, t)
+	defer stop()
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "outbox.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	store := jstransport.NewSQLOutboxStore(db, "outbox")
+	if err := store.CreateTable(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.ExecContext(ctx, "CREATE TABLE events (id TEXT PRIMARY KEY)"); err != nil {
+		t.Fatal(err)
+	}
+
+	record := func(id string, commit bool) {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := tx.ExecContext(ctx, "INSERT INTO events (id) VALUES (?)", id); err != nil {
+			t.Fatal(err)
+		}
+
+		msg := nats.NewMsg("jstransport.outbox.orders")
+		msg.Data = []byte(id)
+		msg.Header.Set(jetstream.MsgIDHeader, id)
+
+		if _, err := store.Record(ctx, tx, msg); err != nil {
+			t.Fatal(err)
+		}
+
+		if commit {
+			err = tx.Commit()
+		} else {
+			err = tx.Rollback()
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	record("committed", true)
+	record("rolled back", false)
+
+	errs := make(chan error, 1)
+	options := []gkit.Option[*jstransport.OutboxRelay]{
+		jstransport.OutboxRelayBackoff(func(int) time.Duration { return 0 }),
+		jstransport.OutboxRelayErrorHandler(gkit.ErrorHandlerFunc(func(_ context.Context, err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		})),
+	}
+
+	n, err := jstransport.NewOutboxRelay(store, failingJetStream{}, options...).RelayOnce(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := 1, n; want != have {
+		t.Errorf("want %d claimed, have %d", want, have)
+	}
+
+	if err := <-errs; err == nil || err.Error() != "dang" {
+		t.Errorf("want publish error, have %v", err)
+	}
+
+	relay := jstransport.NewOutboxRelay(store, js, options...)
+
+	// the failed message is retried after the backoff.
+	if n, err = relay.RelayOnce(ctx); err != nil || n != 1 {
+		t.Fatalf("want 1 claimed, have %d (%v)", n, err)
+	}
+
+	if n, err = relay.RelayOnce(ctx); err != nil || n != 0 {
+		t.Fatalf("want dispatched message not claimed again, have %d (%v)", n, err)
+	}
+
+	var attempts int
+	if err := db.QueryRowContext(ctx, "SELECT attempts FROM outbox WHERE dispatched_at IS NOT NULL").Scan(&attempts); err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := 1, attempts; want != have {
+		t.Errorf("want %d failed attempt, have %d", want, have)
+	}
+
+	msg, err := stream.GetLastMsgForSubject(ctx, "jstransport.outbox.orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := "committed", string(msg.Data); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+
+	if want, have := "committed", msg.Header.Get(jetstream.MsgIDHeader); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
+
+func TestSQLOutboxStoreClaimLease(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "outbox.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	store := jstransport.NewSQLOutboxStore(db, "outbox")
+	if err := store.CreateTable(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Record(ctx, db, nats.NewMsg("foo")); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := store.Claim(ctx, 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := store.Claim(ctx, 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(first) != 1 || len(second) != 0 {
+		t.Errorf("want message claimed once during its lease, have %d then %d", len(first), len(second))
+	}
+}
@@ -0,0 +1,494 @@
+package jetstream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"gopkg.in/yaml.v3"
+)
+
+// ProvisionSpec declares the streams, consumers and key-value buckets an
+// application relies on. Field names follow the JetStream API JSON
+// representation, e.g. "max_age" or "durable_name", and durations are given
+// either as Go duration strings, e.g. "30s", or in nanoseconds.
+type ProvisionSpec struct {
+	Streams   []StreamSpec   `json:"streams,omitempty"`
+	KeyValues []KeyValueSpec `json:"key_values,omitempty"`
+}
+
+// StreamSpec declares a stream along with the consumers bound to it. Every
+// consumer must be named, either through name or durable_name.
+type StreamSpec struct {
+	jetstream.StreamConfig
+	Consumers []jetstream.ConsumerConfig `json:"consumers,omitempty"`
+}
+
+// KeyValueSpec declares a key-value bucket. Fields left out keep their current
+// value when the bucket already exists, and the defaults of the server, e.g.
+// file storage, when it's created.
+type KeyValueSpec struct {
+	Bucket       string                 `json:"bucket"`
+	Description  string                 `json:"description,omitempty"`
+	MaxValueSize int32                  `json:"max_value_size,omitempty"`
+	History      uint8                  `json:"history,omitempty"`
+	TTL          time.Duration          `json:"ttl,omitempty"`
+	MaxBytes     int64                  `json:"max_bytes,omitempty"`
+	Storage      *jetstream.StorageType `json:"storage,omitempty"`
+	Replicas     int                    `json:"num_replicas,omitempty"`
+}
+
+// LoadProvisionSpec reads a ProvisionSpec from a YAML or JSON file.
+func LoadProvisionSpec(path string) (ProvisionSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ProvisionSpec{}, err
+	}
+
+	return ParseProvisionSpec(data)
+}
+
+// ParseProvisionSpec parses a ProvisionSpec from YAML or JSON. Since JSON is a
+// subset of YAML, both are parsed the same way.
+func ParseProvisionSpec(data []byte) (ProvisionSpec, error) {
+	var (
+		raw  any
+		spec ProvisionSpec
+	)
+
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return spec, fmt.Errorf("jstransport: parse provision spec: %w", err)
+	}
+
+	raw, err := parseDurations(raw)
+	if err != nil {
+		return spec, fmt.Errorf("jstransport: parse provision spec: %w", err)
+	}
+
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return spec, fmt.Errorf("jstransport: parse provision spec: %w", err)
+	}
+
+	if err := json.Unmarshal(b, &spec); err != nil {
+		return spec, fmt.Errorf("jstransport: parse provision spec: %w", err)
+	}
+
+	return spec, nil
+}
+
+// durationFields are the names of the duration fields of a ProvisionSpec.
+var durationFields = map[string]bool{
+	"max_age":            true,
+	"duplicate_window":   true,
+	"ack_wait":           true,
+	"backoff":            true,
+	"idle_heartbeat":     true,
+	"inactive_threshold": true,
+	"max_expires":        true,
+	"ttl":                true,
+}
+
+// parseDurations replaces the Go duration strings of the duration fields of a
+// raw spec with nanoseconds, as expected by their JSON representation.
+func parseDurations(raw any) (any, error) {
+	switch v := raw.(type) {
+	case map[string]any:
+		for name, value := range v {
+			var err error
+
+			if name == "metadata" {
+				continue // free-form strings, which may be named alike.
+			}
+
+			if durationFields[name] {
+				v[name], err = parseDuration(name, value)
+			} else {
+				v[name], err = parseDurations(value)
+			}
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	case []any:
+		for i, value := range v {
+			var err error
+			if v[i], err = parseDurations(value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return raw, nil
+}
+
+func parseDuration(name string, value any) (any, error) {
+	switch v := value.(type) {
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		return int64(d), nil
+	case []any:
+		// e.g. the backoff of a consumer.
+		for i, value := range v {
+			var err error
+			if v[i], err = parseDuration(name, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return value, nil
+}
+
+// ProvisionAction is what provisioning does, or would do, to a resource.
+type ProvisionAction string
+
+const (
+	ProvisionCreate    ProvisionAction = "create"
+	ProvisionUpdate    ProvisionAction = "update"
+	ProvisionUnchanged ProvisionAction = "unchanged"
+)
+
+// ProvisionChange describes the action taken on a single resource. Fields lists
+// the configuration fields that differ from the server state on update.
+type ProvisionChange struct {
+	Resource string
+	Name     string
+	Action   ProvisionAction
+	Fields   []string
+}
+
+// String implements fmt.Stringer.
+func (c ProvisionChange) String() string {
+	s := fmt.Sprintf("%s %s %s", c.Action, c.Resource, c.Name)
+	if len(c.Fields) > 0 {
+		s += " (" + strings.Join(c.Fields, ", ") + ")"
+	}
+
+	return s
+}
+
+// ProvisionReport lists the changes of a provisioning run, in the order they
+// are applied.
+type ProvisionReport []ProvisionChange
+
+// Changed reports whether any resource was, or would be, created or updated.
+func (r ProvisionReport) Changed() bool {
+	for _, c := range r {
+		if c.Action != ProvisionUnchanged {
+			return true
+		}
+	}
+
+	return false
+}
+
+// String implements fmt.Stringer, one change per line.
+func (r ProvisionReport) String() string {
+	lines := make([]string, 0, len(r))
+	for _, c := range r {
+		lines = append(lines, c.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Provisioner reconciles a ProvisionSpec with the server state.
+type Provisioner struct {
+	js jetstream.JetStream
+}
+
+// NewProvisioner constructs a Provisioner operating on js.
+func NewProvisioner(js jetstream.JetStream) *Provisioner {
+	return &Provisioner{js: js}
+}
+
+// Plan diffs the spec against the server state without changing anything and
+// reports what Apply would do. It can be used as a dry run.
+func (p *Provisioner) Plan(ctx context.Context, spec ProvisionSpec) (ProvisionReport, error) {
+	return p.provision(ctx, spec, false)
+}
+
+// Apply creates the resources missing from the server and updates the ones
+// whose configuration differs from the spec. Applying the same spec twice is a
+// no-op the second time.
+func (p *Provisioner) Apply(ctx context.Context, spec ProvisionSpec) (ProvisionReport, error) {
+	return p.provision(ctx, spec, true)
+}
+
+func (p *Provisioner) provision(ctx context.Context, spec ProvisionSpec, apply bool) (ProvisionReport, error) {
+	var report ProvisionReport
+
+	for _, s := range spec.Streams {
+		changes, err := p.provisionStream(ctx, s, apply)
+		report = append(report, changes...)
+
+		if err != nil {
+			return report, err
+		}
+	}
+
+	for _, kv := range spec.KeyValues {
+		change, err := p.provisionKeyValue(ctx, kv, apply)
+		report = append(report, change)
+
+		if err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+func (p *Provisioner) provisionStream(ctx context.Context, spec StreamSpec, apply bool) (ProvisionReport, error) {
+	change := ProvisionChange{Resource: "stream", Name: spec.Name, Action: ProvisionCreate}
+	exists := true
+
+	stream, err := p.js.Stream(ctx, spec.Name)
+	switch {
+	case errors.Is(err, jetstream.ErrStreamNotFound):
+		exists = false
+	case err != nil:
+		return ProvisionReport{change}, err
+	default:
+		change.Fields, err = diffConfig(spec.StreamConfig, stream.CachedInfo().Config)
+		if err != nil {
+			return ProvisionReport{change}, err
+		}
+
+		change.Action = actionFor(change.Fields)
+	}
+
+	if apply && change.Action != ProvisionUnchanged {
+		if _, err := p.js.CreateOrUpdateStream(ctx, spec.StreamConfig); err != nil {
+			return ProvisionReport{change}, fmt.Errorf("jstransport: %s stream %s: %w", change.Action, spec.Name, err)
+		}
+
+		exists = true
+	}
+
+	report := ProvisionReport{change}
+
+	for _, cfg := range spec.Consumers {
+		consumerChange, err := p.provisionConsumer(ctx, spec.Name, cfg, exists, apply)
+		report = append(report, consumerChange)
+
+		if err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+func (p *Provisioner) provisionConsumer(ctx context.Context, stream string, cfg jetstream.ConsumerConfig, streamExists, apply bool) (ProvisionChange, error) {
+	name := cfg.Durable
+	if name == "" {
+		name = cfg.Name
+	}
+
+	change := ProvisionChange{Resource: "consumer", Name: stream + "/" + name, Action: ProvisionCreate}
+	if name == "" {
+		return change, fmt.Errorf("jstransport: consumer of stream %s has no name", stream)
+	}
+
+	if streamExists {
+		consumer, err := p.js.Consumer(ctx, stream, name)
+		switch {
+		case errors.Is(err, jetstream.ErrConsumerNotFound):
+		case err != nil:
+			return change, err
+		default:
+			change.Fields, err = diffConfig(cfg, consumer.CachedInfo().Config)
+			if err != nil {
+				return change, err
+			}
+
+			change.Action = actionFor(change.Fields)
+		}
+	}
+
+	if apply && change.Action != ProvisionUnchanged {
+		if _, err := p.js.CreateOrUpdateConsumer(ctx, stream, cfg); err != nil {
+			return change, fmt.Errorf("jstransport: %s consumer %s: %w", change.Action, change.Name, err)
+		}
+	}
+
+	return change, nil
+}
+
+// provisionKeyValue diffs and updates the stream backing the bucket, since
+// key-value buckets can only be created through the key-value API.
+func (p *Provisioner) provisionKeyValue(ctx context.Context, spec KeyValueSpec, apply bool) (ProvisionChange, error) {
+	change := ProvisionChange{Resource: "key_value", Name: spec.Bucket, Action: ProvisionCreate}
+
+	stream, err := p.js.Stream(ctx, "KV_"+spec.Bucket)
+	switch {
+	case errors.Is(err, jetstream.ErrStreamNotFound):
+		if apply {
+			if _, err := p.js.CreateKeyValue(ctx, spec.config()); err != nil {
+				return change, fmt.Errorf("jstransport: create key value %s: %w", spec.Bucket, err)
+			}
+		}
+
+		return change, nil
+	case err != nil:
+		return change, err
+	}
+
+	current := stream.CachedInfo().Config
+	desired := spec.streamConfig(current)
+
+	change.Fields, err = diffConfig(desired, current)
+	if err != nil {
+		return change, err
+	}
+
+	change.Action = actionFor(change.Fields)
+
+	if apply && change.Action != ProvisionUnchanged {
+		if _, err := p.js.UpdateStream(ctx, desired); err != nil {
+			return change, fmt.Errorf("jstransport: update key value %s: %w", spec.Bucket, err)
+		}
+	}
+
+	return change, nil
+}
+
+func (spec KeyValueSpec) config() jetstream.KeyValueConfig {
+	cfg := jetstream.KeyValueConfig{
+		Bucket:       spec.Bucket,
+		Description:  spec.Description,
+		MaxValueSize: spec.MaxValueSize,
+		History:      spec.History,
+		TTL:          spec.TTL,
+		MaxBytes:     spec.MaxBytes,
+		Replicas:     spec.Replicas,
+	}
+
+	if spec.Storage != nil {
+		cfg.Storage = *spec.Storage
+	}
+
+	return cfg
+}
+
+// streamConfig overlays the fields declared in the spec on the current config
+// of the stream backing the bucket.
+func (spec KeyValueSpec) streamConfig(current jetstream.StreamConfig) jetstream.StreamConfig {
+	cfg := current
+
+	if spec.Storage != nil {
+		cfg.Storage = *spec.Storage
+	}
+
+	if spec.Description != "" {
+		cfg.Description = spec.Description
+	}
+
+	if spec.MaxValueSize != 0 {
+		cfg.MaxMsgSize = spec.MaxValueSize
+	}
+
+	if spec.History != 0 {
+		cfg.MaxMsgsPerSubject = int64(spec.History)
+	}
+
+	if spec.TTL != 0 {
+		cfg.MaxAge = spec.TTL
+	}
+
+	if spec.MaxBytes != 0 {
+		cfg.MaxBytes = spec.MaxBytes
+	}
+
+	if spec.Replicas != 0 {
+		cfg.Replicas = spec.Replicas
+	}
+
+	return cfg
+}
+
+func actionFor(fields []string) ProvisionAction {
+	if len(fields) > 0 {
+		return ProvisionUpdate
+	}
+
+	return ProvisionUnchanged
+}
+
+// diffConfig compares the JSON representations of two configurations and
+// returns the names of the fields that differ. Fields left to their zero value
+// in desired are considered server defaults and are not compared.
+func diffConfig(desired, current any) ([]string, error) {
+	want, err := toJSONMap(desired)
+	if err != nil {
+		return nil, err
+	}
+
+	have, err := toJSONMap(current)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []string
+
+	for k, v := range want {
+		if isZeroJSON(v) {
+			continue
+		}
+
+		if !reflect.DeepEqual(v, have[k]) {
+			fields = append(fields, k)
+		}
+	}
+
+	sort.Strings(fields)
+
+	return fields, nil
+}
+
+func toJSONMap(v any) (map[string]any, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]any
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func isZeroJSON(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case bool:
+		return !v
+	case float64:
+		return v == 0
+	case string:
+		return v == ""
+	case []any:
+		return len(v) == 0
+	case map[string]any:
+		return len(v) == 0
+	}
+
+	return false
+}
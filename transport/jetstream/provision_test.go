@@ -0,0 +1,210 @@
+//go:build unit
+
+package jetstream_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	jstransport "github.com/kikihakiem/gkit/transport/jetstream"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const provisionSpec = `
+streams:
+  - name: provision
+    subjects: ["provision.>"]
+    consumers:
+      - durable_name: worker
+        ack_wait: 30000000000
+key_values:
+  - bucket: provision
+    history: 5
+`
+
+func TestProvisioner(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	js, _, stop := newJetstream(ctx, t)
+	defer stop()
+
+	spec, err := jstransport.ParseProvisionSpec([]byte(provisionSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provisioner := jstransport.NewProvisioner(js)
+
+	for _, test := range []struct {
+		name   string
+		run    func(context.Context, jstransport.ProvisionSpec) (jstransport.ProvisionReport, error)
+		action jstransport.ProvisionAction
+	}{
+		{"dry run", provisioner.Plan, jstransport.ProvisionCreate},
+		{"apply", provisioner.Apply, jstransport.ProvisionCreate},
+		{"reapply", provisioner.Apply, jstransport.ProvisionUnchanged},
+	} {
+		report, err := test.run(ctx, spec)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if want, have := 3, len(report); want != have {
+			t.Fatalf("%s: want %d changes, have %d", test.name, want, have)
+		}
+
+		for _, change := range report {
+			if want, have := test.action, change.Action; want != have {
+				t.Errorf("%s: %s: want %s, have %s", test.name, change.Name, want, have)
+			}
+		}
+	}
+
+	spec.Streams[0].Consumers[0].AckWait = time.Minute
+	spec.KeyValues[0].History = 10
+
+	report, err := provisioner.Plan(ctx, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := jstransport.ProvisionReport{
+		{Resource: "stream", Name: "provision", Action: jstransport.ProvisionUnchanged},
+		{Resource: "consumer", Name: "provision/worker", Action: jstransport.ProvisionUpdate, Fields: []string{"ack_wait"}},
+		{Resource: "key_value", Name: "provision", Action: jstransport.ProvisionUpdate, Fields: []string{"max_msgs_per_subject"}},
+	}
+	if !reflect.DeepEqual(want, report) {
+		t.Errorf("want\n%s\nhave\n%s", want, report)
+	}
+}
+
+func TestProvisionerKeyValueStorage(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	js, _, stop := newJetstream(ctx, t)
+	defer stop()
+
+	if _, err := js.CreateKeyValue(ctx, jetstream.KeyValueConfig{Bucket: "cache", Storage: jetstream.MemoryStorage}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		spec   string
+		action jstransport.ProvisionAction
+	}{
+		{"key_values: [{bucket: cache}]", jstransport.ProvisionUnchanged},
+		{"key_values: [{bucket: cache, storage: memory}]", jstransport.ProvisionUnchanged},
+		{"key_values: [{bucket: cache, storage: file}]", jstransport.ProvisionUpdate},
+	} {
+		spec, err := jstransport.ParseProvisionSpec([]byte(test.spec))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		report, err := jstransport.NewProvisioner(js).Plan(ctx, spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, have := test.action, report[0].Action; want != have {
+			t.Errorf("%s: want %s, have %s (%v)", test.spec, want, have, report[0].Fields)
+		}
+	}
+}
+
+func TestParseProvisionSpecDurations(t *testing.T) {
+	spec, err := jstransport.ParseProvisionSpec([]byte(`
+streams:
+  - name: provision
+    max_age: 24h
+    duplicate_window: 120000000000
+    metadata:
+      ttl: 30s
+    consumers:
+      - durable_name: worker
+        ack_wait: 30s
+        backoff: [1s, 5s, 1m]
+key_values:
+  - bucket: provision
+    ttl: 1h30m
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream := spec.Streams[0]
+
+	if want, have := 24*time.Hour, stream.MaxAge; want != have {
+		t.Errorf("want max_age %v, have %v", want, have)
+	}
+
+	if want, have := 2*time.Minute, stream.Duplicates; want != have {
+		t.Errorf("want duplicate_window %v, have %v", want, have)
+	}
+
+	if want, have := "30s", stream.Metadata["ttl"]; want != have {
+		t.Errorf("want metadata ttl %q, have %q", want, have)
+	}
+
+	if want, have := 30*time.Second, stream.Consumers[0].AckWait; want != have {
+		t.Errorf("want ack_wait %v, have %v", want, have)
+	}
+
+	if want, have := []time.Duration{time.Second, 5 * time.Second, time.Minute}, stream.Consumers[0].BackOff; !reflect.DeepEqual(want, have) {
+		t.Errorf("want backoff %v, have %v", want, have)
+	}
+
+	if want, have := 90*time.Minute, spec.KeyValues[0].TTL; want != have {
+		t.Errorf("want ttl %v, have %v", want, have)
+	}
+
+	if _, err := jstransport.ParseProvisionSpec([]byte("streams:\n  - name: provision\n    max_age: forever\n")); err == nil {
+		t.Error("want error for malformed duration, have nil")
+	}
+}
+
+func TestSubscriberBind(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	js, stream, stop := newJetstream(ctx, t)
+	defer stop()
+
+	if err := stream.Purge(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := stream.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{Durable: "bound"}); err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan string, 1)
+
+	subscriber := jstransport.NewSubscriber(
+		func(_ context.Context, req string) (struct{}, error) {
+			received <- req
+			return struct{}{}, nil
+		},
+		func(_ context.Context, msg jetstream.Msg) (string, error) {
+			return string(msg.Data()), nil
+		},
+		gkit.NopResponseEncoder,
+	)
+
+	consumeCtx, err := subscriber.Bind(ctx, js, "test:stream", "bound")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumeCtx.Stop()
+
+	publish(t, js, "test data")
+
+	if want, have := "test data", <-received; want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
@@ -0,0 +1,148 @@
+package jetstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// PublishResult is the typed publish ack decoded by DecodePublishResult.
+type PublishResult struct {
+	// Stream is the name of the stream the message was stored in.
+	Stream string
+
+	// Sequence is the stream sequence of the message.
+	Sequence uint64
+
+	// Duplicate tells whether the server discarded the message as a duplicate
+	// of one already stored with the same Nats-Msg-Id.
+	Duplicate bool
+
+	// Domain is the JetStream domain of the stream, if any.
+	Domain string
+
+	// Outcome tells how the message was handled. Spooled messages have no
+	// stream or sequence yet.
+	Outcome PublishOutcome
+}
+
+// DecodePublishResult is a DecodeResponseFunc that decodes the publish ack
+// into a PublishResult.
+func DecodePublishResult(ctx context.Context, ack *jetstream.PubAck) (PublishResult, error) {
+	outcome, ok := PublishOutcomeFromContext(ctx)
+	if !ok {
+		outcome = publishOutcome(ack)
+	}
+
+	result := PublishResult{Outcome: outcome}
+
+	if ack != nil {
+		result.Stream = ack.Stream
+		result.Sequence = ack.Sequence
+		result.Duplicate = ack.Duplicate
+		result.Domain = ack.Domain
+	}
+
+	return result, nil
+}
+
+var (
+	// ErrNoStream is matched by errors.Is for the PublishErrors returned when
+	// no stream captured the subject, or its server didn't respond.
+	ErrNoStream = errors.New("jstransport: no stream response")
+
+	// ErrMessageTooLarge is matched by errors.Is for the PublishErrors returned
+	// when the message exceeds the maximum payload of the server or the maximum
+	// message size of the stream.
+	ErrMessageTooLarge = errors.New("jstransport: message too large")
+
+	// ErrStreamSealed is matched by errors.Is for the PublishErrors returned
+	// when the stream is sealed and doesn't accept messages anymore.
+	ErrStreamSealed = errors.New("jstransport: stream sealed")
+)
+
+// PublishError is returned by Publisher when a message can't be stored, for a
+// known reason matched by errors.Is against ErrNoStream, ErrMessageTooLarge or
+// ErrStreamSealed. Messages rejected because of unmet expectations are
+// returned as a ConflictError instead.
+type PublishError struct {
+	// Subject is the subject the message was published to.
+	Subject string
+
+	// Code is the JetStream error code of the rejection, or zero when the
+	// message was rejected before reaching the stream.
+	Code jetstream.ErrorCode
+
+	// Err is the error returned by the client.
+	Err error
+
+	reason error
+}
+
+// Error implements error.
+func (e *PublishError) Error() string {
+	return fmt.Sprintf("jstransport: publishing to %s: %v", e.Subject, e.Err)
+}
+
+// Unwrap returns the error returned by the client.
+func (e *PublishError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the reason of the error.
+func (e *PublishError) Is(target error) bool {
+	return target == e.reason //nolint:errorlint
+}
+
+const (
+	errCodeStreamMessageExceedsMaximum jetstream.ErrorCode = 10054
+	errCodeStreamSealed                jetstream.ErrorCode = 10109
+)
+
+// publishError maps the errors returned by the client when publishing msg to a
+// ConflictError or a PublishError, and returns any other error as is.
+func publishError(msg *nats.Msg, err error) error {
+	switch {
+	case errors.Is(err, jetstream.ErrNoStreamResponse):
+		return &PublishError{Subject: msg.Subject, Err: err, reason: ErrNoStream}
+	case errors.Is(err, nats.ErrMaxPayload):
+		return &PublishError{Subject: msg.Subject, Err: err, reason: ErrMessageTooLarge}
+	}
+
+	var apiErr *jetstream.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+
+	switch apiErr.ErrorCode {
+	case jetstream.JSErrCodeStreamWrongLastSequence, errCodeStreamWrongLastMsgID, errCodeStreamNotMatch:
+		return &ConflictError{Subject: msg.Subject, Code: apiErr.ErrorCode, Err: apiErr}
+	case errCodeStreamMessageExceedsMaximum:
+		return &PublishError{Subject: msg.Subject, Code: apiErr.ErrorCode, Err: apiErr, reason: ErrMessageTooLarge}
+	case errCodeStreamSealed:
+		return &PublishError{Subject: msg.Subject, Code: apiErr.ErrorCode, Err: apiErr, reason: ErrStreamSealed}
+	default:
+		return err
+	}
+}
+
+// IsRetryable reports whether publishing again may succeed after failing with
+// err, e.g. once the connection recovers or the stream is created. Messages
+// rejected by the server, conflicting with its state or too large won't be
+// stored however many times they are published.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, ErrNoStream) {
+		return true
+	}
+
+	var apiErr *jetstream.APIError
+
+	return !errors.As(err, &apiErr) && !errors.Is(err, ErrMessageTooLarge)
+}
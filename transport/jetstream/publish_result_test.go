@@ -0,0 +1,145 @@
+//go:build unit
+
+package jetstream_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	jstransport "github.com/kikihakiem/gkit/transport/jetstream"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func newResultPublisher(js jetstream.JetStream, subject string) *jstransport.Publisher[string, jstransport.PublishResult] {
+	return jstransport.NewPublisher(
+		js,
+		func(_ context.Context, req string) (*nats.Msg, error) {
+			msg := nats.NewMsg(subject)
+			msg.Data = []byte(req)
+
+			return msg, nil
+		},
+		jstransport.DecodePublishResult,
+		jstransport.PublisherMsgID[string, jstransport.PublishResult](func(_ context.Context, req string, _ *nats.Msg) (string, error) {
+			return req, nil
+		}),
+	)
+}
+
+func TestDecodePublishResult(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	js, _, stop := newJetstream(ctx, t)
+	defer stop()
+
+	nonce := strconv.FormatInt(time.Now().UnixNano(), 10)
+	publish := newResultPublisher(js, "jstransport.result."+nonce).Endpoint()
+
+	stored, err := publish(ctx, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := "test:stream", stored.Stream; want != have {
+		t.Errorf("want stream %q, have %q", want, have)
+	}
+
+	if stored.Sequence == 0 || stored.Duplicate || stored.Outcome != jstransport.PublishOutcomeStored {
+		t.Errorf("want a stored message, have %+v", stored)
+	}
+
+	duplicate, err := publish(ctx, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := stored.Sequence, duplicate.Sequence; want != have {
+		t.Errorf("want sequence %d, have %d", want, have)
+	}
+
+	if !duplicate.Duplicate || duplicate.Outcome != jstransport.PublishOutcomeDuplicate {
+		t.Errorf("want a duplicate, have %+v", duplicate)
+	}
+}
+
+func TestPublishErrors(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	js, _, stop := newJetstream(ctx, t)
+	defer stop()
+
+	nonce := strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	if _, err := js.CreateStream(ctx, jetstream.StreamConfig{
+		Name:       "small_" + nonce,
+		Subjects:   []string{"small." + nonce},
+		MaxMsgSize: 16,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := js.CreateStream(ctx, jetstream.StreamConfig{Name: "sealed_" + nonce, Subjects: []string{"sealed." + nonce}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := sealed.CachedInfo().Config
+	cfg.Sealed = true
+
+	if _, err := js.UpdateStream(ctx, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tc := range map[string]struct {
+		subject   string
+		reason    error
+		retryable bool
+	}{
+		"no stream": {subject: "nostream." + nonce, reason: jstransport.ErrNoStream, retryable: true},
+		"too large": {subject: "small." + nonce, reason: jstransport.ErrMessageTooLarge},
+		"sealed":    {subject: "sealed." + nonce, reason: jstransport.ErrStreamSealed},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := newResultPublisher(js, tc.subject).Endpoint()(ctx, strings.Repeat("a", 32))
+
+			var publishErr *jstransport.PublishError
+			if !errors.As(err, &publishErr) || !errors.Is(err, tc.reason) {
+				t.Fatalf("want %v, have %v", tc.reason, err)
+			}
+
+			if want, have := tc.subject, publishErr.Subject; want != have {
+				t.Errorf("want subject %q, have %q", want, have)
+			}
+
+			if want, have := tc.retryable, jstransport.IsRetryable(err); want != have {
+				t.Errorf("want retryable %t, have %t", want, have)
+			}
+		})
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	for name, tc := range map[string]struct {
+		err  error
+		want bool
+	}{
+		"nil":               {err: nil, want: false},
+		"connection closed": {err: nats.ErrConnectionClosed, want: true},
+		"timeout":           {err: context.DeadlineExceeded, want: true},
+		"conflict":          {err: &jstransport.ConflictError{Err: &jetstream.APIError{ErrorCode: jetstream.JSErrCodeStreamWrongLastSequence}}, want: false},
+		"other API error":   {err: &jetstream.APIError{ErrorCode: 10071}, want: false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if have := jstransport.IsRetryable(tc.err); tc.want != have {
+				t.Errorf("want %t, have %t", tc.want, have)
+			}
+		})
+	}
+}
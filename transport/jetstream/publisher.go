@@ -3,6 +3,8 @@ package jetstream
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"sync"
 	"time"
 
 	gkit "github.com/kikihakiem/gkit/core"
@@ -12,14 +14,24 @@ import (
 
 // Publisher wraps a URL and provides a method that implements endpoint.Endpoint.
 type Publisher[Req, Res any] struct {
-	publisher jetstream.JetStream
-	enc       gkit.EncodeDecodeFunc[Req, *nats.Msg]
-	dec       gkit.EncodeDecodeFunc[*jetstream.PubAck, Res]
-	before    []gkit.BeforeRequestFunc[*nats.Msg]
-	after     []gkit.AfterResponseFunc[*jetstream.PubAck]
-	timeout   time.Duration
+	publisher    jetstream.JetStream
+	enc          gkit.EncodeDecodeFunc[Req, *nats.Msg]
+	dec          gkit.EncodeDecodeFunc[*jetstream.PubAck, Res]
+	before       []gkit.BeforeRequestFunc[*nats.Msg]
+	after        []gkit.AfterResponseFunc[*jetstream.PubAck]
+	timeout      time.Duration
+	msgID        MsgIDFunc[Req]
+	prepare      []prepareFunc[Req]
+	errorHandler gkit.ErrorHandler
+
+	retryBudget time.Duration
+	windowCheck *sync.Once
+	spool       *Spool
 }
 
+// prepareFunc adjusts an outgoing message from the request, once encoded.
+type prepareFunc[Req any] func(ctx context.Context, request Req, msg *nats.Msg)
+
 // NewPublisher constructs a usable Publisher for a single remote method.
 func NewPublisher[Req, Res any](
 	publisher jetstream.JetStream,
@@ -28,10 +40,11 @@ func NewPublisher[Req, Res any](
 	options ...gkit.Option[*Publisher[Req, Res]],
 ) *Publisher[Req, Res] {
 	p := &Publisher[Req, Res]{
-		publisher: publisher,
-		enc:       enc,
-		dec:       dec,
-		timeout:   10 * time.Second,
+		publisher:    publisher,
+		enc:          enc,
+		dec:          dec,
+		timeout:      10 * time.Second,
+		errorHandler: gkit.LogErrorHandler(nil),
 	}
 
 	for _, option := range options {
@@ -59,6 +72,62 @@ func PublisherTimeout[Req, Res any](timeout time.Duration) gkit.Option[*Publishe
 	return func(p *Publisher[Req, Res]) { p.timeout = timeout }
 }
 
+// PublisherMsgID sets the function deriving the Nats-Msg-Id header from the
+// request, which lets JetStream discard retried publishes as duplicates.
+// Whether the message was stored or discarded is populated in the context
+// under ContextKeyPublishOutcome before the publish ack is decoded.
+func PublisherMsgID[Req, Res any](msgID MsgIDFunc[Req]) gkit.Option[*Publisher[Req, Res]] {
+	return func(p *Publisher[Req, Res]) { p.msgID = msgID }
+}
+
+// PublisherExpectLastSubjectSequence sets the function deriving the
+// Nats-Expected-Last-Subject-Sequence header from the request or the context.
+// The server rejects the message with a ConflictError if the last message on
+// its subject has a different sequence.
+func PublisherExpectLastSubjectSequence[Req, Res any](f ExpectFunc[Req, uint64]) gkit.Option[*Publisher[Req, Res]] {
+	return func(p *Publisher[Req, Res]) {
+		p.prepare = append(p.prepare, expectHeader(jetstream.ExpectedLastSubjSeqHeader, f, formatSequence))
+	}
+}
+
+// PublisherExpectLastMsgID sets the function deriving the
+// Nats-Expected-Last-Msg-Id header from the request or the context. The server
+// rejects the message with a ConflictError if the last message of the stream
+// has a different ID.
+func PublisherExpectLastMsgID[Req, Res any](f ExpectFunc[Req, string]) gkit.Option[*Publisher[Req, Res]] {
+	return func(p *Publisher[Req, Res]) {
+		p.prepare = append(p.prepare, expectHeader(jetstream.ExpectedLastMsgIDHeader, f, formatString))
+	}
+}
+
+// PublisherExpectStream sets the Nats-Expected-Stream header. The server
+// rejects the message with a ConflictError if its subject isn't bound to the
+// named stream.
+func PublisherExpectStream[Req, Res any](stream string) gkit.Option[*Publisher[Req, Res]] {
+	return func(p *Publisher[Req, Res]) {
+		p.prepare = append(p.prepare, expectHeader(jetstream.ExpectedStreamHeader, func(context.Context, Req) (string, bool) {
+			return stream, true
+		}, formatString))
+	}
+}
+
+// PublisherRetryBudget sets how long a message may keep being retried. On the
+// first successful publish, ErrDuplicateWindowTooShort is reported to the error
+// handler if the stream's duplicate window is shorter than the retry budget,
+// since retries beyond the window wouldn't be deduplicated.
+func PublisherRetryBudget[Req, Res any](retryBudget time.Duration) gkit.Option[*Publisher[Req, Res]] {
+	return func(p *Publisher[Req, Res]) {
+		p.retryBudget = retryBudget
+		p.windowCheck = &sync.Once{}
+	}
+}
+
+// PublisherErrorHandler is used to handle non-terminal errors, such as failing
+// to check the duplicate window of the stream. By default, they are logged.
+func PublisherErrorHandler[Req, Res any](errorHandler gkit.ErrorHandler) gkit.Option[*Publisher[Req, Res]] {
+	return func(p *Publisher[Req, Res]) { p.errorHandler = errorHandler }
+}
+
 // Endpoint returns a usable endpoint that invokes the remote endpoint.
 func (p Publisher[Req, Res]) Endpoint() gkit.Endpoint[Req, Res] {
 	return func(ctx context.Context, request Req) (Res, error) {
@@ -72,15 +141,38 @@ func (p Publisher[Req, Res]) Endpoint() gkit.Endpoint[Req, Res] {
 			return response, err
 		}
 
+		if p.msgID != nil {
+			id, err := p.msgID(ctx, request, msg)
+			if err != nil {
+				return response, err
+			}
+
+			if msg.Header == nil {
+				msg.Header = nats.Header{}
+			}
+
+			msg.Header.Set(jetstream.MsgIDHeader, id)
+		}
+
+		for _, f := range p.prepare {
+			f(ctx, request, msg)
+		}
+
 		for _, f := range p.before {
 			ctx = f(ctx, msg)
 		}
 
-		resp, err := p.publisher.PublishMsg(ctx, msg)
+		resp, outcome, err := p.publish(ctx, msg)
 		if err != nil {
 			return response, err
 		}
 
+		if p.windowCheck != nil && outcome != PublishOutcomeSpooled {
+			p.windowCheck.Do(func() { checkDuplicateWindow(ctx, p.publisher, resp.Stream, p.retryBudget, p.errorHandler) })
+		}
+
+		ctx = context.WithValue(ctx, ContextKeyPublishOutcome, outcome)
+
 		for _, f := range p.after {
 			ctx = f(ctx, resp, err)
 		}
@@ -94,6 +186,35 @@ func (p Publisher[Req, Res]) Endpoint() gkit.Endpoint[Req, Res] {
 	}
 }
 
+// publish publishes msg, or appends it to the spool if any and the server
+// can't be reached.
+func (p Publisher[Req, Res]) publish(ctx context.Context, msg *nats.Msg) (*jetstream.PubAck, PublishOutcome, error) {
+	// messages already waiting in the spool are published first, to keep order.
+	if p.spool != nil && p.spool.Pending() > 0 {
+		return p.spoolMsg(msg, nil)
+	}
+
+	resp, err := p.publisher.PublishMsg(ctx, msg)
+	if err == nil {
+		return resp, publishOutcome(resp), nil
+	}
+
+	err = publishError(msg, err)
+	if p.spool == nil || !IsRetryable(err) {
+		return nil, 0, err
+	}
+
+	return p.spoolMsg(msg, err)
+}
+
+func (p Publisher[Req, Res]) spoolMsg(msg *nats.Msg, publishErr error) (*jetstream.PubAck, PublishOutcome, error) {
+	if err := p.spool.Append(msg); err != nil {
+		return nil, 0, errors.Join(publishErr, err)
+	}
+
+	return &jetstream.PubAck{}, PublishOutcomeSpooled, nil
+}
+
 // EncodeJSONRequest is an EncodeRequestFunc that serializes the request as a
 // JSON object to the Data of the Msg. Many JSON-over-NATS services can use it as
 // a sensible default.
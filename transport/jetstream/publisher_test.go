@@ -4,6 +4,7 @@ package jetstream_test
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -166,3 +167,113 @@ func TestEncodeJSONRequest(t *testing.T) {
 		}
 	}
 }
+
+func TestPublisherMsgID(t *testing.T) {
+	type event struct {
+		ID string
+	}
+
+	js, _, stop := newJetstream(context.Background(), t)
+	defer stop()
+
+	publisher := jstransport.NewPublisher(
+		js,
+		jstransport.EncodeJSONRequest[event],
+		func(ctx context.Context, _ *jetstream.PubAck) (jstransport.PublishOutcome, error) {
+			outcome, _ := jstransport.PublishOutcomeFromContext(ctx)
+			return outcome, nil
+		},
+		jstransport.PublisherMsgID[event, jstransport.PublishOutcome](jstransport.MsgIDFromField[event]("ID")),
+	).Endpoint()
+
+	for _, test := range []struct {
+		id      string
+		outcome jstransport.PublishOutcome
+	}{
+		{"foo", jstransport.PublishOutcomeStored},
+		{"foo", jstransport.PublishOutcomeDuplicate},
+		{"bar", jstransport.PublishOutcomeStored},
+	} {
+		outcome, err := publisher(context.Background(), event{ID: test.id})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, have := test.outcome, outcome; want != have {
+			t.Errorf("%s: want %s, have %s", test.id, want, have)
+		}
+	}
+}
+
+func TestMsgIDFromContentHash(t *testing.T) {
+	msgID := jstransport.MsgIDFromContentHash[struct{}]()
+
+	msg := nats.NewMsg("foo")
+	msg.Data = []byte("bar")
+
+	first, _ := msgID(context.Background(), struct{}{}, msg)
+	second, _ := msgID(context.Background(), struct{}{}, msg)
+
+	msg.Data = []byte("baz")
+	third, _ := msgID(context.Background(), struct{}{}, msg)
+
+	if first != second {
+		t.Errorf("want equal IDs for equal content, have %q and %q", first, second)
+	}
+
+	if first == third {
+		t.Errorf("want different IDs for different content, have %q", first)
+	}
+}
+
+func TestMsgIDFromFieldMissing(t *testing.T) {
+	msgID := jstransport.MsgIDFromField[struct{ Foo string }]("Bar")
+
+	if _, err := msgID(context.Background(), struct{ Foo string }{}, nats.NewMsg("foo")); err == nil {
+		t.Error("want error, have nil")
+	}
+}
+
+func TestMsgIDFromFieldUnexported(t *testing.T) {
+	type request struct{ id string }
+
+	msgID := jstransport.MsgIDFromField[request]("id")
+
+	if _, err := msgID(context.Background(), request{id: "foo"}, nats.NewMsg("foo")); err == nil {
+		t.Error("want error, have nil")
+	}
+}
+
+func TestPublisherRetryBudget(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	js, _, stop := newJetstream(ctx, t)
+	defer stop()
+
+	var reported []error
+
+	publisher := jstransport.NewPublisher(
+		js,
+		jstransport.EncodeJSONRequest[string],
+		gkit.NopEncoderDecoder[*jetstream.PubAck, struct{}],
+		jstransport.PublisherRetryBudget[string, struct{}](time.Hour),
+		jstransport.PublisherErrorHandler[string, struct{}](gkit.ErrorHandlerFunc(func(_ context.Context, err error) {
+			reported = append(reported, err)
+		})),
+	)
+
+	for i := 0; i < 2; i++ {
+		if _, err := publisher.Endpoint()(ctx, "foo"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if want, have := 1, len(reported); want != have {
+		t.Fatalf("want %d reported error, have %d", want, have)
+	}
+
+	if !errors.Is(reported[0], jstransport.ErrDuplicateWindowTooShort) {
+		t.Errorf("want %v, have %v", jstransport.ErrDuplicateWindowTooShort, reported[0])
+	}
+}
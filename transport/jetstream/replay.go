@@ -0,0 +1,288 @@
+package jetstream
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	"github.com/nats-io/nats.go/jetstream"
+	"golang.org/x/time/rate"
+)
+
+// ReplayProgress reports how far a replay went.
+type ReplayProgress struct {
+	// Processed is the number of messages handled by the endpoint, or only
+	// decoded in dry-run mode.
+	Processed int
+
+	// Failed is the number of messages that failed to be decoded or handled.
+	Failed int
+
+	// LastSequence is the stream sequence of the last message replayed.
+	LastSequence uint64
+
+	// EndSequence is the stream sequence the replay stops at.
+	EndSequence uint64
+}
+
+// Replayer reprocesses the history of a stream through the decoder and the
+// endpoint of a subscriber, e.g. after fixing a bug in the endpoint. Messages
+// are read with an ephemeral ordered consumer, so the replay doesn't interfere
+// with the durable consumers of the stream, and nothing is acknowledged or
+// published to the subscriber reply.
+type Replayer[Req, Res any] struct {
+	s            Subscriber[Req, Res]
+	js           jetstream.JetStream
+	stream       string
+	subjects     []string
+	startSeq     uint64
+	startTime    time.Time
+	endSeq       uint64
+	endTime      time.Time
+	limiter      *rate.Limiter
+	progress     func(context.Context, ReplayProgress)
+	every        int
+	kv           jetstream.KeyValue
+	key          string
+	dryRun       bool
+	batchSize    int
+	maxWait      time.Duration
+	errorHandler gkit.ErrorHandler
+}
+
+// Replayer returns a runner replaying the named stream through the subscriber.
+// By default, the whole stream is replayed up to the last message stored when
+// the replay starts.
+func (s Subscriber[Req, Res]) Replayer(
+	js jetstream.JetStream,
+	stream string,
+	options ...gkit.Option[*Replayer[Req, Res]],
+) *Replayer[Req, Res] {
+	r := &Replayer[Req, Res]{
+		s:            s,
+		js:           js,
+		stream:       stream,
+		every:        100,
+		batchSize:    100,
+		maxWait:      time.Second,
+		errorHandler: s.errorHandler,
+	}
+
+	for _, option := range options {
+		option(r)
+	}
+
+	return r
+}
+
+// ReplayerFilterSubjects restricts the replay to messages matching the subjects.
+func ReplayerFilterSubjects[Req, Res any](subjects ...string) gkit.Option[*Replayer[Req, Res]] {
+	return func(r *Replayer[Req, Res]) { r.subjects = subjects }
+}
+
+// ReplayerFromSequence starts the replay at the given stream sequence.
+func ReplayerFromSequence[Req, Res any](seq uint64) gkit.Option[*Replayer[Req, Res]] {
+	return func(r *Replayer[Req, Res]) { r.startSeq = seq }
+}
+
+// ReplayerFromTime starts the replay at the first message stored at or after t.
+func ReplayerFromTime[Req, Res any](t time.Time) gkit.Option[*Replayer[Req, Res]] {
+	return func(r *Replayer[Req, Res]) { r.startTime = t }
+}
+
+// ReplayerUntilSequence stops the replay after the given stream sequence.
+func ReplayerUntilSequence[Req, Res any](seq uint64) gkit.Option[*Replayer[Req, Res]] {
+	return func(r *Replayer[Req, Res]) { r.endSeq = seq }
+}
+
+// ReplayerUntilTime stops the replay before the first message stored after t.
+func ReplayerUntilTime[Req, Res any](t time.Time) gkit.Option[*Replayer[Req, Res]] {
+	return func(r *Replayer[Req, Res]) { r.endTime = t }
+}
+
+// ReplayerRateLimit throttles the replay, so that it doesn't starve the live
+// traffic of the endpoint.
+func ReplayerRateLimit[Req, Res any](limiter *rate.Limiter) gkit.Option[*Replayer[Req, Res]] {
+	return func(r *Replayer[Req, Res]) { r.limiter = limiter }
+}
+
+// ReplayerProgress sets the function reporting the progress of the replay
+// every given number of messages, and once the replay is done.
+func ReplayerProgress[Req, Res any](every int, f func(context.Context, ReplayProgress)) gkit.Option[*Replayer[Req, Res]] {
+	return func(r *Replayer[Req, Res]) {
+		r.every = every
+		r.progress = f
+	}
+}
+
+// ReplayerCheckpoint stores the last replayed stream sequence under the given key
+// of the bucket whenever progress is reported. A replay finding a checkpoint
+// resumes right after it instead of from its start option.
+func ReplayerCheckpoint[Req, Res any](kv jetstream.KeyValue, key string) gkit.Option[*Replayer[Req, Res]] {
+	return func(r *Replayer[Req, Res]) {
+		r.kv = kv
+		r.key = key
+	}
+}
+
+// ReplayerDryRun only decodes the messages, without invoking the endpoint or
+// storing checkpoints. It is useful to check the range and the decoding of a
+// replay before running it.
+func ReplayerDryRun[Req, Res any]() gkit.Option[*Replayer[Req, Res]] {
+	return func(r *Replayer[Req, Res]) { r.dryRun = true }
+}
+
+// ReplayerErrorHandler is used to handle messages failing to be decoded or
+// handled. By default, the error handler of the subscriber is used.
+func ReplayerErrorHandler[Req, Res any](errorHandler gkit.ErrorHandler) gkit.Option[*Replayer[Req, Res]] {
+	return func(r *Replayer[Req, Res]) { r.errorHandler = errorHandler }
+}
+
+// Run replays the messages until the end of the range, or ctx is done.
+// Messages failing to be decoded or handled are passed to the error handler and
+// counted as failed, without stopping the replay.
+func (r *Replayer[Req, Res]) Run(ctx context.Context) (ReplayProgress, error) {
+	var progress ReplayProgress
+
+	stream, err := r.js.Stream(ctx, r.stream)
+	if err != nil {
+		return progress, err
+	}
+
+	progress.EndSequence = stream.CachedInfo().State.LastSeq
+	if r.endSeq > 0 && r.endSeq < progress.EndSequence {
+		progress.EndSequence = r.endSeq
+	}
+
+	config := jetstream.OrderedConsumerConfig{FilterSubjects: r.subjects}
+
+	startSeq, err := r.resumeSequence(ctx)
+	if err != nil {
+		return progress, err
+	}
+
+	switch {
+	case startSeq > 0:
+		config.DeliverPolicy = jetstream.DeliverByStartSequencePolicy
+		config.OptStartSeq = startSeq
+	case !r.startTime.IsZero():
+		config.DeliverPolicy = jetstream.DeliverByStartTimePolicy
+		config.OptStartTime = &r.startTime
+	}
+
+	if progress.EndSequence == 0 || startSeq > progress.EndSequence {
+		return progress, nil
+	}
+
+	consumer, err := r.js.OrderedConsumer(ctx, r.stream, config)
+	if err != nil {
+		return progress, err
+	}
+
+	defer r.report(ctx, &progress)
+
+	for {
+		batch, err := consumer.Fetch(r.batchSize, jetstream.FetchMaxWait(r.maxWait))
+		if err != nil {
+			return progress, err
+		}
+
+		received := 0
+
+		for msg := range batch.Messages() {
+			received++
+
+			meta, err := msg.Metadata()
+			if err != nil {
+				return progress, err
+			}
+
+			if meta.Sequence.Stream > progress.EndSequence || (!r.endTime.IsZero() && meta.Timestamp.After(r.endTime)) {
+				return progress, nil
+			}
+
+			if r.limiter != nil {
+				if err := r.limiter.Wait(ctx); err != nil {
+					return progress, err
+				}
+			}
+
+			if err := r.replay(ctx, msg); err != nil {
+				r.errorHandler.Handle(ctx, err)
+				progress.Failed++
+			} else {
+				progress.Processed++
+			}
+
+			progress.LastSequence = meta.Sequence.Stream
+
+			if r.every > 0 && (progress.Processed+progress.Failed)%r.every == 0 {
+				r.report(ctx, &progress)
+			}
+
+			if meta.Sequence.Stream == progress.EndSequence || meta.NumPending == 0 {
+				return progress, nil
+			}
+		}
+
+		if err := batch.Error(); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			return progress, err
+		}
+
+		if received == 0 {
+			return progress, ctx.Err()
+		}
+	}
+}
+
+// replay decodes the message and, unless in dry-run mode, invokes the endpoint.
+func (r *Replayer[Req, Res]) replay(ctx context.Context, msg jetstream.Msg) error {
+	ctx, request, err := r.s.decode(ctx, msg)
+	if err != nil || r.dryRun {
+		return err
+	}
+
+	_, err = r.s.e(ctx, request)
+
+	return err
+}
+
+// resumeSequence returns the sequence following the checkpoint, if any, or the
+// start sequence option.
+func (r *Replayer[Req, Res]) resumeSequence(ctx context.Context) (uint64, error) {
+	if r.kv == nil {
+		return r.startSeq, nil
+	}
+
+	entry, err := r.kv.Get(ctx, r.key)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return r.startSeq, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	seq, err := strconv.ParseUint(string(entry.Value()), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return seq + 1, nil
+}
+
+// report stores the checkpoint and reports the progress.
+func (r *Replayer[Req, Res]) report(ctx context.Context, progress *ReplayProgress) {
+	if r.kv != nil && !r.dryRun && progress.LastSequence > 0 {
+		_, err := r.kv.PutString(context.WithoutCancel(ctx), r.key, strconv.FormatUint(progress.LastSequence, 10))
+		if err != nil {
+			r.errorHandler.Handle(ctx, err)
+		}
+	}
+
+	if r.progress != nil {
+		r.progress(ctx, *progress)
+	}
+}
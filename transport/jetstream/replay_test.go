@@ -0,0 +1,132 @@
+//go:build unit
+
+package jetstream_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	jstransport "github.com/kikihakiem/gkit/transport/jetstream"
+	"github.com/nats-io/nats.go/jetstream"
+	"golang.org/x/time/rate"
+)
+
+func TestReplayer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	js, _, stop := newJetstream(ctx, t)
+	defer stop()
+
+	kv, err := js.CreateKeyValue(ctx, jetstream.KeyValueConfig{Bucket: "replay"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subject := "jstransport.replay.orders"
+
+	var first, last uint64
+
+	for i := 0; i < 5; i++ {
+		ack, err := js.Publish(ctx, subject, []byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if i == 0 {
+			first = ack.Sequence
+		}
+
+		last = ack.Sequence
+	}
+
+	// published after the replay range, on a subject filtered out.
+	if _, err := js.Publish(ctx, "jstransport.other.orders", []byte("other")); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		handled  []string
+		failures = map[string]bool{"3": true}
+		reports  []jstransport.ReplayProgress
+	)
+
+	subscriber := jstransport.NewSubscriber(
+		func(_ context.Context, req string) (struct{}, error) {
+			handled = append(handled, req)
+			if failures[req] {
+				return struct{}{}, errors.New("dang")
+			}
+
+			return struct{}{}, nil
+		},
+		func(_ context.Context, msg jetstream.Msg) (string, error) {
+			return string(msg.Data()), nil
+		},
+		gkit.NopResponseEncoder,
+		jstransport.SubscriberErrorHandler[string, struct{}](gkit.ErrorHandlerFunc(func(context.Context, error) {})),
+	)
+
+	replayer := func(options ...gkit.Option[*jstransport.Replayer[string, struct{}]]) *jstransport.Replayer[string, struct{}] {
+		return subscriber.Replayer(js, "test:stream", append([]gkit.Option[*jstransport.Replayer[string, struct{}]]{
+			jstransport.ReplayerFilterSubjects[string, struct{}](subject),
+			jstransport.ReplayerFromSequence[string, struct{}](first),
+			jstransport.ReplayerRateLimit[string, struct{}](rate.NewLimiter(rate.Inf, 1)),
+		}, options...)...)
+	}
+
+	progress, err := replayer(jstransport.ReplayerDryRun[string, struct{}]()).Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := 5, progress.Processed; want != have {
+		t.Errorf("dry run: want %d processed, have %d", want, have)
+	}
+
+	if len(handled) != 0 {
+		t.Errorf("dry run: want endpoint not invoked, have %v", handled)
+	}
+
+	progress, err = replayer(
+		jstransport.ReplayerUntilSequence[string, struct{}](last-1),
+		jstransport.ReplayerCheckpoint[string, struct{}](kv, "orders"),
+		jstransport.ReplayerProgress[string, struct{}](2, func(_ context.Context, p jstransport.ReplayProgress) {
+			reports = append(reports, p)
+		}),
+	).Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := (jstransport.ReplayProgress{Processed: 3, Failed: 1, LastSequence: last - 1, EndSequence: last - 1}), progress; want != have {
+		t.Errorf("want %+v, have %+v", want, have)
+	}
+
+	if want, have := []string{"0", "1", "2", "3"}, handled; !reflect.DeepEqual(want, have) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+
+	if want, have := 3, len(reports); want != have {
+		t.Errorf("want %d progress reports, have %d", want, have)
+	}
+
+	// resumes after the checkpoint.
+	progress, err = replayer(jstransport.ReplayerCheckpoint[string, struct{}](kv, "orders")).Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := 1, progress.Processed; want != have {
+		t.Errorf("want %d processed, have %d", want, have)
+	}
+
+	if want, have := []string{"0", "1", "2", "3", "4"}, handled; !reflect.DeepEqual(want, have) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
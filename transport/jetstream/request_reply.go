@@ -0,0 +1,209 @@
+package jetstream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nuid"
+)
+
+const (
+	// ReplyToHeader carries the core NATS subject the reply to a message
+	// published by a Requester is expected on.
+	ReplyToHeader = "Reply-To"
+
+	// CorrelationIDHeader carries the ID correlating a reply with the message
+	// published by a Requester.
+	CorrelationIDHeader = "Correlation-Id"
+)
+
+// Requester publishes requests durably to a stream, and waits for the reply
+// produced by a subscriber once it has processed the request. Replies are sent
+// over core NATS, to an inbox named in the ReplyToHeader.
+type Requester[Req, Res any] struct {
+	js      jetstream.JetStream
+	nc      *nats.Conn
+	enc     gkit.EncodeDecodeFunc[Req, *nats.Msg]
+	dec     gkit.EncodeDecodeFunc[*nats.Msg, Res]
+	before  []gkit.BeforeRequestFunc[*nats.Msg]
+	after   []gkit.AfterResponseFunc[*nats.Msg]
+	timeout time.Duration
+}
+
+// NewRequester constructs a usable Requester publishing with js and receiving
+// replies with nc, which should be the connection js was created from.
+func NewRequester[Req, Res any](
+	js jetstream.JetStream,
+	nc *nats.Conn,
+	enc gkit.EncodeDecodeFunc[Req, *nats.Msg],
+	dec gkit.EncodeDecodeFunc[*nats.Msg, Res],
+	options ...gkit.Option[*Requester[Req, Res]],
+) *Requester[Req, Res] {
+	r := &Requester[Req, Res]{
+		js:      js,
+		nc:      nc,
+		enc:     enc,
+		dec:     dec,
+		timeout: 30 * time.Second,
+	}
+
+	for _, option := range options {
+		option(r)
+	}
+
+	return r
+}
+
+// RequesterBefore sets the RequestFuncs that are applied to the outgoing
+// message before it's published.
+func RequesterBefore[Req, Res any](before ...gkit.BeforeRequestFunc[*nats.Msg]) gkit.Option[*Requester[Req, Res]] {
+	return func(r *Requester[Req, Res]) { r.before = append(r.before, before...) }
+}
+
+// RequesterAfter sets the ResponseFuncs applied to the reply prior to it being
+// decoded.
+func RequesterAfter[Req, Res any](after ...gkit.AfterResponseFunc[*nats.Msg]) gkit.Option[*Requester[Req, Res]] {
+	return func(r *Requester[Req, Res]) { r.after = append(r.after, after...) }
+}
+
+// RequesterTimeout sets how long to wait for the reply, including the time the
+// request spends in the stream before being processed. By default, it is 30
+// seconds.
+func RequesterTimeout[Req, Res any](timeout time.Duration) gkit.Option[*Requester[Req, Res]] {
+	return func(r *Requester[Req, Res]) { r.timeout = timeout }
+}
+
+// Endpoint returns a usable endpoint that publishes the request and waits for
+// its reply.
+func (r Requester[Req, Res]) Endpoint() gkit.Endpoint[Req, Res] {
+	return func(ctx context.Context, request Req) (Res, error) {
+		ctx, cancel := context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+
+		var response Res
+
+		msg, err := r.enc(ctx, request)
+		if err != nil {
+			return response, err
+		}
+
+		inbox := r.nc.NewInbox()
+
+		sub, err := r.nc.SubscribeSync(inbox)
+		if err != nil {
+			return response, err
+		}
+		defer sub.Unsubscribe() //nolint:errcheck
+
+		correlationID := nuid.Next()
+
+		if msg.Header == nil {
+			msg.Header = nats.Header{}
+		}
+
+		msg.Header.Set(ReplyToHeader, inbox)
+		msg.Header.Set(CorrelationIDHeader, correlationID)
+
+		for _, f := range r.before {
+			ctx = f(ctx, msg)
+		}
+
+		if _, err := r.js.PublishMsg(ctx, msg); err != nil {
+			return response, err
+		}
+
+		var reply *nats.Msg
+
+		for reply == nil {
+			m, err := sub.NextMsgWithContext(ctx)
+			if err != nil {
+				return response, err
+			}
+
+			if m.Header.Get(CorrelationIDHeader) == correlationID {
+				reply = m
+			}
+		}
+
+		for _, f := range r.after {
+			ctx = f(ctx, reply, nil)
+		}
+
+		return r.dec(ctx, reply)
+	}
+}
+
+// PopulateReplyContext is a BeforeRequestFunc that populates the reply subject
+// and the correlation ID of a message published by a Requester into the
+// context, where they're used by EncodeJSONReply and EncodeJSONReplyError.
+func PopulateReplyContext(ctx context.Context, msg jetstream.Msg) context.Context {
+	ctx = context.WithValue(ctx, ContextKeyReplyTo, msg.Headers().Get(ReplyToHeader))
+	ctx = context.WithValue(ctx, ContextKeyCorrelationID, msg.Headers().Get(CorrelationIDHeader))
+
+	return ctx
+}
+
+// EncodeJSONReply returns a ResponseEncoder that serializes the response as a
+// JSON object and sends it to the requester over nc. Subscribers using it must
+// also use PopulateReplyContext. Nothing is sent for messages that don't
+// expect a reply.
+func EncodeJSONReply[Res any](nc *nats.Conn) gkit.ResponseEncoder[jetstream.JetStream, Res] {
+	return func(ctx context.Context, _ jetstream.JetStream, response Res) error {
+		return sendReply(ctx, nc, response)
+	}
+}
+
+// EncodeJSONReplyError returns an ErrorEncoder that sends the error to the
+// requester over nc, as an ErrResponse.
+func EncodeJSONReplyError(nc *nats.Conn) gkit.ErrorEncoder[jetstream.JetStream] {
+	return func(ctx context.Context, _ jetstream.JetStream, err error) {
+		sendReply(ctx, nc, ErrResponse{Error: err.Error()}) //nolint:errcheck
+	}
+}
+
+// DecodeJSONReply is a DecodeResponseFunc that deserializes the JSON reply
+// sent by EncodeJSONReply. A reply sent by EncodeJSONReplyError is returned as
+// an error.
+func DecodeJSONReply[Res any](_ context.Context, msg *nats.Msg) (Res, error) {
+	var (
+		res    Res
+		errRes ErrResponse
+	)
+
+	if json.Unmarshal(msg.Data, &errRes) == nil && errRes.Error != "" {
+		return res, errors.New(errRes.Error)
+	}
+
+	err := json.Unmarshal(msg.Data, &res)
+	if err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
+
+func sendReply(ctx context.Context, nc *nats.Conn, v any) error {
+	replyTo, _ := ctx.Value(ContextKeyReplyTo).(string)
+	if replyTo == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	msg := nats.NewMsg(replyTo)
+	msg.Data = b
+
+	if correlationID, _ := ctx.Value(ContextKeyCorrelationID).(string); correlationID != "" {
+		msg.Header.Set(CorrelationIDHeader, correlationID)
+	}
+
+	return nc.PublishMsg(msg)
+}
@@ -0,0 +1,112 @@
+//go:build unit
+
+package jetstream_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	jstransport "github.com/kikihakiem/gkit/transport/jetstream"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestRequester(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	srv, nc := newNATSConn(t)
+	defer shutdownJSServer(t, srv)
+	defer nc.Close()
+
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:     "test:stream",
+		Subjects: []string{"jstransport.>"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := stream.Purge(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	consumer, err := stream.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subscriber := jstransport.NewSubscriber(
+		func(_ context.Context, name string) (string, error) {
+			if name == "" {
+				return "", errors.New("empty name")
+			}
+
+			return strings.ToUpper(name), nil
+		},
+		jstransport.DecodeJSONRequest[string],
+		jstransport.EncodeJSONReply[string](nc),
+		jstransport.SubscriberBefore[string, string](jstransport.PopulateReplyContext),
+		jstransport.SubscriberErrorEncoder[string, string](jstransport.EncodeJSONReplyError(nc)),
+	)
+
+	consumeCtx, err := consumer.Consume(subscriber.HandleMessage(js))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumeCtx.Stop()
+
+	requester := jstransport.NewRequester(
+		js,
+		nc,
+		jstransport.EncodeJSONRequest[string],
+		jstransport.DecodeJSONReply[string],
+		jstransport.RequesterTimeout[string, string](5*time.Second),
+		jstransport.RequesterBefore[string, string](func(ctx context.Context, msg *nats.Msg) context.Context {
+			msg.Subject = "jstransport.command"
+			return ctx
+		}),
+	).Endpoint()
+
+	reply, err := requester(ctx, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := "FOO", reply; want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+
+	if _, err := requester(ctx, ""); err == nil || err.Error() != "empty name" {
+		t.Errorf("want empty name error, have %v", err)
+	}
+}
+
+func TestRequesterTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	srv, nc := newNATSConn(t)
+	defer shutdownJSServer(t, srv)
+	defer nc.Close()
+
+	requester := jstransport.NewRequester(
+		&jetstreamMock{dataChan: make(chan string, 1)},
+		nc,
+		jstransport.EncodeJSONRequest[string],
+		jstransport.DecodeJSONReply[string],
+		jstransport.RequesterTimeout[string, string](50*time.Millisecond),
+	).Endpoint()
+
+	if _, err := requester(ctx, "foo"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want %v, have %v", context.DeadlineExceeded, err)
+	}
+}
@@ -0,0 +1,162 @@
+package jetstream
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// PopulateRequestContext is a BeforeRequestFunc that populates several values
+// into the context from the JetStream message. Those values may be extracted
+// using the corresponding ContextKey type in this package. Metadata values are
+// left out for messages that don't carry JetStream metadata.
+func PopulateRequestContext(ctx context.Context, msg jetstream.Msg) context.Context {
+	values := map[contextKey]any{
+		ContextKeyRequestSubject:     msg.Subject(),
+		ContextKeyRequestReply:       msg.Reply(),
+		ContextKeyRequestMsgID:       msg.Headers().Get(jetstream.MsgIDHeader),
+		ContextKeyRequestContentType: msg.Headers().Get("Content-Type"),
+		ContextKeyRequestXRequestID:  msg.Headers().Get("X-Request-Id"),
+	}
+
+	if meta, err := msg.Metadata(); err == nil {
+		values[ContextKeyRequestStream] = meta.Stream
+		values[ContextKeyRequestConsumer] = meta.Consumer
+		values[ContextKeyRequestDomain] = meta.Domain
+		values[ContextKeyRequestStreamSequence] = meta.Sequence.Stream
+		values[ContextKeyRequestConsumerSequence] = meta.Sequence.Consumer
+		values[ContextKeyRequestNumDelivered] = meta.NumDelivered
+		values[ContextKeyRequestNumPending] = meta.NumPending
+		values[ContextKeyRequestTimestamp] = meta.Timestamp
+	}
+
+	for k, v := range values {
+		ctx = context.WithValue(ctx, k, v)
+	}
+
+	return ctx
+}
+
+type contextKey int
+
+const (
+	// ContextKeyRequestSubject is populated in the context by
+	// PopulateRequestContext. Its value is msg.Subject().
+	ContextKeyRequestSubject contextKey = iota
+
+	// ContextKeyRequestReply is populated in the context by
+	// PopulateRequestContext. Its value is msg.Reply().
+	ContextKeyRequestReply
+
+	// ContextKeyRequestStream is populated in the context by
+	// PopulateRequestContext. Its value is the Stream of the message metadata.
+	ContextKeyRequestStream
+
+	// ContextKeyRequestConsumer is populated in the context by
+	// PopulateRequestContext. Its value is the Consumer of the message metadata.
+	ContextKeyRequestConsumer
+
+	// ContextKeyRequestDomain is populated in the context by
+	// PopulateRequestContext. Its value is the Domain of the message metadata.
+	ContextKeyRequestDomain
+
+	// ContextKeyRequestStreamSequence is populated in the context by
+	// PopulateRequestContext. Its value is the stream sequence of the message
+	// metadata, of type uint64.
+	ContextKeyRequestStreamSequence
+
+	// ContextKeyRequestConsumerSequence is populated in the context by
+	// PopulateRequestContext. Its value is the consumer sequence of the message
+	// metadata, of type uint64.
+	ContextKeyRequestConsumerSequence
+
+	// ContextKeyRequestNumDelivered is populated in the context by
+	// PopulateRequestContext. Its value is the NumDelivered of the message
+	// metadata, of type uint64.
+	ContextKeyRequestNumDelivered
+
+	// ContextKeyRequestNumPending is populated in the context by
+	// PopulateRequestContext. Its value is the NumPending of the message
+	// metadata, of type uint64.
+	ContextKeyRequestNumPending
+
+	// ContextKeyRequestTimestamp is populated in the context by
+	// PopulateRequestContext. Its value is the publish Timestamp of the message
+	// metadata, of type time.Time.
+	ContextKeyRequestTimestamp
+
+	// ContextKeyRequestMsgID is populated in the context by
+	// PopulateRequestContext. Its value is msg.Headers().Get("Nats-Msg-Id").
+	ContextKeyRequestMsgID
+
+	// ContextKeyRequestContentType is populated in the context by
+	// PopulateRequestContext. Its value is msg.Headers().Get("Content-Type").
+	ContextKeyRequestContentType
+
+	// ContextKeyRequestXRequestID is populated in the context by
+	// PopulateRequestContext. Its value is msg.Headers().Get("X-Request-Id").
+	ContextKeyRequestXRequestID
+
+	// ContextKeyAckDisposition is populated in the context whenever a
+	// subscriber finalizer is specified. Its value is of type AckDisposition,
+	// and is captured once the message has been acknowledged.
+	ContextKeyAckDisposition
+
+	// ContextKeyMessageSummary is populated in the context whenever a
+	// subscriber finalizer is specified. Its value is of type MessageSummary.
+	ContextKeyMessageSummary
+
+	// ContextKeyPublishOutcome is populated in the context by Publisher before
+	// the publish ack is decoded. Its value is of type PublishOutcome.
+	ContextKeyPublishOutcome
+
+	// ContextKeyExpectedLastSubjectSequence is populated in the context by
+	// WithExpectedLastSubjectSequence. Its value is of type uint64.
+	ContextKeyExpectedLastSubjectSequence
+
+	// ContextKeyExpectedLastMsgID is populated in the context by
+	// WithExpectedLastMsgID. Its value is of type string.
+	ContextKeyExpectedLastMsgID
+
+	// ContextKeyScheduleAt is populated in the context by WithScheduleAt. Its
+	// value is of type time.Time.
+	ContextKeyScheduleAt
+
+	// ContextKeyReplyTo is populated in the context by PopulateReplyContext.
+	// Its value is msg.Headers().Get("Reply-To").
+	ContextKeyReplyTo
+
+	// ContextKeyCorrelationID is populated in the context by
+	// PopulateReplyContext. Its value is msg.Headers().Get("Correlation-Id").
+	ContextKeyCorrelationID
+
+	// ContextKeySigner is populated in the context by SubscriberVerifier. Its
+	// value is the public nkey of the signer of the message.
+	ContextKeySigner
+
+	// ContextKeyEntryKey is populated in the context by PopulateEntryContext.
+	// Its value is entry.Key().
+	ContextKeyEntryKey
+
+	// ContextKeyEntryRevision is populated in the context by
+	// PopulateEntryContext. Its value is entry.Revision().
+	ContextKeyEntryRevision
+
+	// ContextKeyEntryOperation is populated in the context by
+	// PopulateEntryContext. Its value is entry.Operation().
+	ContextKeyEntryOperation
+
+	// ContextKeyEntryCreated is populated in the context by
+	// PopulateEntryContext. Its value is entry.Created().
+	ContextKeyEntryCreated
+
+	// contextKeyVerificationError is populated in the context by
+	// SubscriberVerifier for messages that can't be verified. Its value is the
+	// VerificationError, or the error publishing them to the dead letter queue.
+	contextKeyVerificationError
+
+	// contextKeyStart is populated in the context by Subscriber before the
+	// message is decoded. Its value is the time handling started, of type
+	// time.Time.
+	contextKeyStart
+)
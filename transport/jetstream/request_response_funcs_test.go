@@ -0,0 +1,163 @@
+//go:build unit
+
+package jetstream_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	jstransport "github.com/kikihakiem/gkit/transport/jetstream"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestPopulateRequestContext(t *testing.T) {
+	ctxChan := make(chan context.Context, 1)
+
+	handler := jstransport.NewSubscriber(
+		gkit.NopEndpoint[emptyStruct, emptyStruct],
+		gkit.NopEncoderDecoder,
+		gkit.NopResponseEncoder,
+		jstransport.SubscriberBefore[emptyStruct, emptyStruct](jstransport.PopulateRequestContext),
+		jstransport.SubscriberBefore[emptyStruct, emptyStruct](func(ctx context.Context, _ jetstream.Msg) context.Context {
+			ctxChan <- ctx
+			return ctx
+		}),
+	)
+
+	js, stop := newConsumer(t, handler)
+	defer stop()
+
+	msg := nats.NewMsg("jstransport.test.99")
+	msg.Data = []byte("test data")
+	msg.Header.Set("X-Request-Id", "request-id")
+	msg.Header.Set(jetstream.MsgIDHeader, time.Now().String())
+
+	if _, err := js.PublishMsg(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := <-ctxChan
+
+	for key, want := range map[any]any{
+		jstransport.ContextKeyRequestSubject:          "jstransport.test.99",
+		jstransport.ContextKeyRequestStream:           "test:stream",
+		jstransport.ContextKeyRequestConsumerSequence: uint64(1),
+		jstransport.ContextKeyRequestNumDelivered:     uint64(1),
+		jstransport.ContextKeyRequestNumPending:       uint64(0),
+		jstransport.ContextKeyRequestMsgID:            msg.Header.Get(jetstream.MsgIDHeader),
+		jstransport.ContextKeyRequestXRequestID:       "request-id",
+		jstransport.ContextKeyRequestContentType:      "",
+	} {
+		if have := ctx.Value(key); want != have {
+			t.Errorf("%v: want %v, have %v", key, want, have)
+		}
+	}
+
+	// purging the stream doesn't reset its sequence.
+	if seq, ok := ctx.Value(jstransport.ContextKeyRequestStreamSequence).(uint64); !ok || seq == 0 {
+		t.Errorf("want stream sequence, have %v", ctx.Value(jstransport.ContextKeyRequestStreamSequence))
+	}
+
+	if ts, ok := ctx.Value(jstransport.ContextKeyRequestTimestamp).(time.Time); !ok || ts.IsZero() {
+		t.Errorf("want publish timestamp, have %v", ctx.Value(jstransport.ContextKeyRequestTimestamp))
+	}
+}
+
+func TestSubscriberFinalizerAckDisposition(t *testing.T) {
+	dispositions := make(chan any, 1)
+
+	handler := jstransport.NewSubscriber(
+		gkit.NopEndpoint[emptyStruct, emptyStruct],
+		gkit.NopEncoderDecoder,
+		gkit.NopResponseEncoder,
+		jstransport.SubscriberFinalizer[emptyStruct, emptyStruct](func(ctx context.Context, _ jetstream.Msg, _ error) {
+			dispositions <- ctx.Value(jstransport.ContextKeyAckDisposition)
+		}),
+	)
+
+	js, stop := newConsumer(t, handler)
+	defer stop()
+
+	publish(t, js, "test data")
+
+	if want, have := jstransport.AckDispositionAck, <-dispositions; want != have {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
+
+func TestSubscriberFinalizerMessageSummary(t *testing.T) {
+	var (
+		summaries = make(chan jstransport.MessageSummary, 2)
+		attempts  int
+	)
+
+	handler := jstransport.NewSubscriber(
+		func(context.Context, emptyStruct) (emptyStruct, error) {
+			attempts++
+			if attempts == 1 {
+				return emptyStruct{}, errors.New("dang")
+			}
+
+			return emptyStruct{}, nil
+		},
+		gkit.NopEncoderDecoder,
+		gkit.NopResponseEncoder,
+		jstransport.SubscriberErrorEncoder[emptyStruct, emptyStruct](func(context.Context, jetstream.JetStream, error) {}),
+		jstransport.SubscriberFinalizer[emptyStruct, emptyStruct](func(ctx context.Context, _ jetstream.Msg, _ error) {
+			summary, _ := jstransport.MessageSummaryFromContext(ctx)
+			summaries <- summary
+		}),
+	)
+
+	js, stop := newConsumer(t, handler)
+	defer stop()
+
+	publish(t, js, "test data")
+
+	for _, want := range []jstransport.MessageSummary{
+		{Stage: jstransport.StageEndpoint, Disposition: jstransport.AckDispositionNak, NumDelivered: 1},
+		{Stage: jstransport.StageDone, Disposition: jstransport.AckDispositionAck, NumDelivered: 2},
+	} {
+		have := <-summaries
+		if have.Duration <= 0 {
+			t.Errorf("want positive duration, have %v", have.Duration)
+		}
+
+		have.Duration = 0
+		if want != have {
+			t.Errorf("want %+v, have %+v", want, have)
+		}
+	}
+}
+
+func TestSubscriberFinalizerDecodeStage(t *testing.T) {
+	summaries := make(chan jstransport.MessageSummary, 1)
+
+	handler := jstransport.NewSubscriber(
+		gkit.NopEndpoint[emptyStruct, emptyStruct],
+		func(context.Context, jetstream.Msg) (emptyStruct, error) { return emptyStruct{}, errors.New("dang") },
+		gkit.NopResponseEncoder,
+		jstransport.SubscriberErrorEncoder[emptyStruct, emptyStruct](func(context.Context, jetstream.JetStream, error) {}),
+		jstransport.SubscriberFinalizer[emptyStruct, emptyStruct](func(ctx context.Context, _ jetstream.Msg, _ error) {
+			summary, _ := jstransport.MessageSummaryFromContext(ctx)
+			summaries <- summary
+		}),
+	)
+
+	msg := &messageMock{subject: "jstransport.test.99"}
+	handler.HandleMessage(nil)(msg)
+
+	summary := <-summaries
+	if want, have := jstransport.StageDecode, summary.Stage; want != have {
+		t.Errorf("want %v, have %v", want, have)
+	}
+
+	if want, have := []string{"nak"}, msg.acks; !reflect.DeepEqual(want, have) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
@@ -0,0 +1,170 @@
+package jetstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// ErrNoRoute is passed to the router error handler for messages matching none
+// of the routes.
+var ErrNoRoute = errors.New("jstransport: no route for message")
+
+// DLQSubjectHeader carries the original subject of a message moved to a dead
+// letter queue, e.g. by RouterFallbackDLQ.
+const DLQSubjectHeader = "Dlq-Original-Subject"
+
+type route struct {
+	tokens  []string
+	msgType string
+	handler jetstream.MessageHandler
+}
+
+// Router dispatches the messages of a single consumer to different handlers,
+// e.g. differently typed subscribers, based on their subject or message type.
+type Router struct {
+	routes       []route
+	typeHeader   string
+	fallback     jetstream.MessageHandler
+	errorHandler gkit.ErrorHandler
+}
+
+// NewRouter constructs an empty router. By default, the message type is read
+// from the Message-Type header, and unmatched messages are terminated.
+func NewRouter(options ...gkit.Option[*Router]) *Router {
+	r := &Router{
+		typeHeader:   "Message-Type",
+		fallback:     func(msg jetstream.Msg) { msg.Term() }, //nolint:errcheck
+		errorHandler: gkit.LogErrorHandler(nil),
+	}
+
+	for _, option := range options {
+		option(r)
+	}
+
+	return r
+}
+
+// RouterTypeHeader sets the header carrying the message type matched by
+// HandleType.
+func RouterTypeHeader(name string) gkit.Option[*Router] {
+	return func(r *Router) { r.typeHeader = name }
+}
+
+// RouterFallback sets the handler of the messages matching none of the routes.
+func RouterFallback(handler jetstream.MessageHandler) gkit.Option[*Router] {
+	return func(r *Router) { r.fallback = handler }
+}
+
+// RouterFallbackNak negatively acknowledges unmatched messages, so that they're
+// redelivered, e.g. to a newer instance knowing how to route them.
+func RouterFallbackNak() gkit.Option[*Router] {
+	return RouterFallback(func(msg jetstream.Msg) { msg.Nak() }) //nolint:errcheck
+}
+
+// RouterFallbackTerm terminates unmatched messages, so that they're never
+// redelivered.
+func RouterFallbackTerm() gkit.Option[*Router] {
+	return RouterFallback(func(msg jetstream.Msg) { msg.Term() }) //nolint:errcheck
+}
+
+// RouterFallbackDLQ publishes unmatched messages to the dead letter queue
+// subject, with their original subject in the DLQSubjectHeader, and then
+// acknowledges them. Messages failing to be published are negatively
+// acknowledged.
+func RouterFallbackDLQ(js jetstream.JetStream, subject string) gkit.Option[*Router] {
+	return func(r *Router) {
+		r.fallback = func(msg jetstream.Msg) {
+			if err := publishDLQ(context.Background(), js, subject, msg); err != nil {
+				r.errorHandler.Handle(context.Background(), err)
+				msg.Nak() //nolint:errcheck
+
+				return
+			}
+
+			msg.Ack() //nolint:errcheck
+		}
+	}
+}
+
+// RouterErrorHandler is used to handle unmatched messages and dead letter
+// queue failures. By default, they are logged.
+func RouterErrorHandler(errorHandler gkit.ErrorHandler) gkit.Option[*Router] {
+	return func(r *Router) { r.errorHandler = errorHandler }
+}
+
+// Handle routes the messages whose subject matches the pattern to the handler.
+// The pattern may contain the * and > wildcards, as NATS subscriptions do.
+func (r *Router) Handle(pattern string, handler jetstream.MessageHandler) {
+	r.routes = append(r.routes, route{tokens: strings.Split(pattern, "."), handler: handler})
+}
+
+// HandleType routes the messages whose type header equals msgType to the
+// handler. Type routes take precedence over subject routes.
+func (r *Router) HandleType(msgType string, handler jetstream.MessageHandler) {
+	r.routes = append(r.routes, route{msgType: msgType, handler: handler})
+}
+
+// HandleMessage provides jetstream.MessageHandler. The message is dispatched to
+// the first matching type route, or else to the first matching subject route,
+// in the order they were registered.
+func (r *Router) HandleMessage(msg jetstream.Msg) {
+	if msgType := msg.Headers().Get(r.typeHeader); msgType != "" {
+		for _, route := range r.routes {
+			if route.msgType == msgType {
+				route.handler(msg)
+				return
+			}
+		}
+	}
+
+	subject := strings.Split(msg.Subject(), ".")
+
+	for _, route := range r.routes {
+		if route.tokens != nil && matchSubject(route.tokens, subject) {
+			route.handler(msg)
+			return
+		}
+	}
+
+	r.errorHandler.Handle(context.Background(), fmt.Errorf("%w: %s", ErrNoRoute, msg.Subject()))
+	r.fallback(msg)
+}
+
+// matchSubject reports whether the subject tokens match the pattern tokens.
+func matchSubject(pattern, subject []string) bool {
+	for i, token := range pattern {
+		if token == ">" {
+			return len(subject) > i
+		}
+
+		if i >= len(subject) || (token != "*" && token != subject[i]) {
+			return false
+		}
+	}
+
+	return len(pattern) == len(subject)
+}
+
+// publishDLQ publishes a copy of msg to the dead letter queue subject, with its
+// original subject in the DLQSubjectHeader.
+func publishDLQ(ctx context.Context, js jetstream.JetStream, subject string, msg jetstream.Msg) error {
+	dlq := nats.NewMsg(subject)
+	dlq.Data = msg.Data()
+
+	for k, v := range msg.Headers() {
+		dlq.Header[k] = v
+	}
+
+	dlq.Header.Set(DLQSubjectHeader, msg.Subject())
+	dlq.Header.Del(jetstream.MsgIDHeader)
+
+	_, err := js.PublishMsg(ctx, dlq)
+
+	return err
+}
@@ -0,0 +1,94 @@
+//go:build unit
+
+package jetstream_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	jstransport "github.com/kikihakiem/gkit/transport/jetstream"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestRouter(t *testing.T) {
+	var routed []string
+
+	handler := func(name string) jetstream.MessageHandler {
+		return func(jetstream.Msg) { routed = append(routed, name) }
+	}
+
+	router := jstransport.NewRouter(
+		jstransport.RouterFallback(handler("fallback")),
+		jstransport.RouterErrorHandler(gkit.ErrorHandlerFunc(func(context.Context, error) {})),
+	)
+	router.Handle("events.create", handler("create"))
+	router.Handle("events.*.audit", handler("audit"))
+	router.Handle("events.>", handler("events"))
+	router.HandleType("user.deleted", handler("deleted"))
+
+	for _, test := range []struct {
+		subject string
+		msgType string
+		want    string
+	}{
+		{"events.create", "", "create"},
+		{"events.update.audit", "", "audit"},
+		{"events.update", "", "events"},
+		{"events.update.audit.extra", "", "events"},
+		{"events", "", "fallback"},
+		{"other.create", "", "fallback"},
+		{"events.create", "user.deleted", "deleted"},
+		{"events.create", "user.unknown", "create"},
+	} {
+		routed = nil
+
+		router.HandleMessage(&messageMock{subject: test.subject, headers: nats.Header{"Message-Type": {test.msgType}}})
+
+		if want, have := []string{test.want}, routed; !reflect.DeepEqual(want, have) {
+			t.Errorf("%s (%s): want %v, have %v", test.subject, test.msgType, want, have)
+		}
+	}
+}
+
+func TestRouterFallbacks(t *testing.T) {
+	dlq := &jetstreamMock{dataChan: make(chan string, 1)}
+
+	for _, test := range []struct {
+		name     string
+		fallback gkit.Option[*jstransport.Router]
+		want     []string
+	}{
+		{"default", nil, []string{"term"}},
+		{"nak", jstransport.RouterFallbackNak(), []string{"nak"}},
+		{"term", jstransport.RouterFallbackTerm(), []string{"term"}},
+		{"dlq", jstransport.RouterFallbackDLQ(dlq, "events.dlq"), []string{"ack"}},
+	} {
+		var errs []error
+
+		options := []gkit.Option[*jstransport.Router]{
+			jstransport.RouterErrorHandler(gkit.ErrorHandlerFunc(func(_ context.Context, err error) { errs = append(errs, err) })),
+		}
+		if test.fallback != nil {
+			options = append(options, test.fallback)
+		}
+
+		msg := &messageMock{subject: "unknown", data: []byte("data")}
+		jstransport.NewRouter(options...).HandleMessage(msg)
+
+		if want, have := test.want, msg.acks; !reflect.DeepEqual(want, have) {
+			t.Errorf("%s: want %v, have %v", test.name, want, have)
+		}
+
+		if len(errs) != 1 || !errors.Is(errs[0], jstransport.ErrNoRoute) {
+			t.Errorf("%s: want ErrNoRoute, have %v", test.name, errs)
+		}
+	}
+
+	if want, have := "data", <-dlq.dataChan; want != have {
+		t.Errorf("want %s published to the dead letter queue, have %s", want, have)
+	}
+}
@@ -0,0 +1,198 @@
+package jetstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	// ScheduleAtHeader carries the time a scheduled message is due, formatted
+	// as RFC 3339.
+	ScheduleAtHeader = "Schedule-At"
+
+	// ScheduleTargetHeader carries the subject a scheduled message is to be
+	// published to once due.
+	ScheduleTargetHeader = "Schedule-Target"
+)
+
+// ScheduleFunc derives the time an outgoing message is due from the request or
+// the context. It reports false when the message is to be published right away.
+type ScheduleFunc[Req any] func(ctx context.Context, request Req) (time.Time, bool)
+
+// ScheduleAtFromContext returns a ScheduleFunc that takes the due time from the
+// context, where it was put by WithScheduleAt.
+func ScheduleAtFromContext[Req any]() ScheduleFunc[Req] {
+	return func(ctx context.Context, _ Req) (time.Time, bool) {
+		at, ok := ctx.Value(ContextKeyScheduleAt).(time.Time)
+		return at, ok
+	}
+}
+
+// WithScheduleAt returns a context carrying the time the message published
+// with it is due.
+func WithScheduleAt(ctx context.Context, at time.Time) context.Context {
+	return context.WithValue(ctx, ContextKeyScheduleAt, at)
+}
+
+// PublisherSchedule makes the publisher schedule messages for later delivery.
+// Messages with a due time are published to the subject prefix followed by
+// their target subject, e.g. "schedules.events.create", with the due time and
+// the target subject in the ScheduleAtHeader and ScheduleTargetHeader. The
+// prefix must be bound to a dedicated stream consumed by a Scheduler.
+func PublisherSchedule[Req, Res any](prefix string, at ScheduleFunc[Req]) gkit.Option[*Publisher[Req, Res]] {
+	return func(p *Publisher[Req, Res]) {
+		p.prepare = append(p.prepare, func(ctx context.Context, request Req, msg *nats.Msg) {
+			due, ok := at(ctx, request)
+			if !ok {
+				return
+			}
+
+			if msg.Header == nil {
+				msg.Header = nats.Header{}
+			}
+
+			msg.Header.Set(ScheduleAtHeader, due.Format(time.RFC3339Nano))
+			msg.Header.Set(ScheduleTargetHeader, msg.Subject)
+			msg.Subject = prefix + "." + msg.Subject
+		})
+	}
+}
+
+// Scheduler publishes scheduled messages to their target subject once they're
+// due. Scheduled messages are persisted in a dedicated stream, ideally with the
+// work queue retention policy, and consumed through a durable consumer, so that
+// they survive restarts and each message is handled by a single replica at a
+// time. Messages not due yet are negatively acknowledged with the remaining
+// delay, and are published with a Nats-Msg-Id, so that a message redelivered
+// after being published is discarded by the server as a duplicate.
+//
+// Messages held back stay pending on the consumer until they're due, so the
+// consumer's MaxAckPending bounds how many messages can be scheduled at once:
+// once it's reached, the server stops delivering, and messages that are due
+// wait behind the ones that aren't. See SchedulerMaxPending.
+type Scheduler struct {
+	js           jetstream.JetStream
+	maxPending   int
+	maxDelay     time.Duration
+	retryDelay   time.Duration
+	errorHandler gkit.ErrorHandler
+}
+
+// NewScheduler constructs a scheduler publishing due messages with js.
+func NewScheduler(js jetstream.JetStream, options ...gkit.Option[*Scheduler]) *Scheduler {
+	s := &Scheduler{
+		js:           js,
+		maxPending:   -1,
+		maxDelay:     time.Hour,
+		retryDelay:   5 * time.Second,
+		errorHandler: gkit.LogErrorHandler(nil),
+	}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	return s
+}
+
+// SchedulerMaxPending sets the MaxAckPending of the consumer created by Start,
+// i.e. how many messages not due yet can be held back at once. The server keeps
+// track of every pending message, and may impose its own limit on accounts or
+// streams. By default, it is unlimited (-1), since reaching the limit stalls
+// delivery of the messages that are due.
+func SchedulerMaxPending(maxPending int) gkit.Option[*Scheduler] {
+	return func(s *Scheduler) { s.maxPending = maxPending }
+}
+
+// SchedulerMaxDelay caps how long a message not due yet is held back before
+// being redelivered to the scheduler, so a message due in ten hours is
+// redelivered about ten times with the default. Raise it when scheduling far
+// into the future. By default, it is one hour.
+func SchedulerMaxDelay(maxDelay time.Duration) gkit.Option[*Scheduler] {
+	return func(s *Scheduler) { s.maxDelay = maxDelay }
+}
+
+// SchedulerRetryDelay sets the delay before retrying a due message that failed
+// to be published. By default, it is five seconds.
+func SchedulerRetryDelay(retryDelay time.Duration) gkit.Option[*Scheduler] {
+	return func(s *Scheduler) { s.retryDelay = retryDelay }
+}
+
+// SchedulerErrorHandler is used to handle malformed scheduled messages and
+// publish failures. By default, they are logged.
+func SchedulerErrorHandler(errorHandler gkit.ErrorHandler) gkit.Option[*Scheduler] {
+	return func(s *Scheduler) { s.errorHandler = errorHandler }
+}
+
+// Start creates or updates the durable consumer of the schedule stream, and
+// starts handling its messages. The consumer never gives up redelivering
+// messages, since every delay counts as a delivery, and allows as many pending
+// messages as SchedulerMaxPending.
+func (s *Scheduler) Start(ctx context.Context, stream, durable string) (jetstream.ConsumeContext, error) {
+	consumer, err := s.js.CreateOrUpdateConsumer(ctx, stream, jetstream.ConsumerConfig{
+		Durable:       durable,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		MaxDeliver:    -1,
+		MaxAckPending: s.maxPending,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return consumer.Consume(s.HandleMessage)
+}
+
+// HandleMessage provides jetstream.MessageHandler.
+func (s *Scheduler) HandleMessage(msg jetstream.Msg) {
+	ctx := context.Background()
+
+	target := msg.Headers().Get(ScheduleTargetHeader)
+
+	at, err := time.Parse(time.RFC3339Nano, msg.Headers().Get(ScheduleAtHeader))
+	if err == nil && target == "" {
+		err = errors.New("missing target subject")
+	}
+
+	if err != nil {
+		s.errorHandler.Handle(ctx, fmt.Errorf("jstransport: malformed scheduled message on %s: %w", msg.Subject(), err))
+		msg.Term() //nolint:errcheck
+
+		return
+	}
+
+	if wait := time.Until(at); wait > 0 {
+		msg.NakWithDelay(min(wait, s.maxDelay)) //nolint:errcheck
+		return
+	}
+
+	out := nats.NewMsg(target)
+	out.Data = msg.Data()
+
+	for k, v := range msg.Headers() {
+		out.Header[k] = v
+	}
+
+	out.Header.Del(ScheduleAtHeader)
+	out.Header.Del(ScheduleTargetHeader)
+
+	if out.Header.Get(jetstream.MsgIDHeader) == "" {
+		if meta, err := msg.Metadata(); err == nil {
+			out.Header.Set(jetstream.MsgIDHeader, fmt.Sprintf("%s:%d", meta.Stream, meta.Sequence.Stream))
+		}
+	}
+
+	if _, err := s.js.PublishMsg(ctx, out); err != nil {
+		s.errorHandler.Handle(ctx, err)
+		msg.NakWithDelay(s.retryDelay) //nolint:errcheck
+
+		return
+	}
+
+	msg.Ack() //nolint:errcheck
+}
@@ -0,0 +1,162 @@
+//go:build unit
+
+package jetstream_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	jstransport "github.com/kikihakiem/gkit/transport/jetstream"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestScheduler(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	js, stream, stop := newJetstream(ctx, t)
+	defer stop()
+
+	schedules, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      "test:schedules",
+		Subjects:  []string{"schedules.>"},
+		Retention: jetstream.WorkQueuePolicy,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := schedules.Purge(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	consumeCtx, err := jstransport.NewScheduler(js).Start(ctx, "test:schedules", "scheduler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumeCtx.Stop()
+
+	subject := "jstransport.scheduled.reminder"
+
+	publisher := jstransport.NewPublisher(
+		js,
+		func(_ context.Context, data string) (*nats.Msg, error) {
+			msg := nats.NewMsg(subject)
+			msg.Data = []byte(data)
+
+			return msg, nil
+		},
+		decodePubAck,
+		jstransport.PublisherSchedule[string, *jetstream.PubAck]("schedules", jstransport.ScheduleAtFromContext[string]()),
+	).Endpoint()
+
+	at := time.Now().Add(500 * time.Millisecond)
+
+	ack, err := publisher(jstransport.WithScheduleAt(ctx, at), "later")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := "test:schedules", ack.Stream; want != have {
+		t.Errorf("want scheduled message stored in %s, have %s", want, have)
+	}
+
+	if _, err := publisher(ctx, "now"); err != nil {
+		t.Fatal(err)
+	}
+
+	consumer, err := stream.OrderedConsumer(ctx, jetstream.OrderedConsumerConfig{FilterSubjects: []string{subject}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var received []string
+
+	for len(received) < 2 {
+		msg, err := consumer.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		received = append(received, string(msg.Data()))
+
+		if string(msg.Data()) != "later" {
+			continue
+		}
+
+		if meta, _ := msg.Metadata(); meta.Timestamp.Before(at) {
+			t.Errorf("want scheduled message published after %v, have %v", at, meta.Timestamp)
+		}
+
+		if msg.Headers().Get(jstransport.ScheduleAtHeader) != "" || msg.Headers().Get(jetstream.MsgIDHeader) == "" {
+			t.Errorf("want schedule headers replaced by a message ID, have %v", msg.Headers())
+		}
+	}
+
+	if want, have := []string{"now", "later"}, received; !reflect.DeepEqual(want, have) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
+
+func TestSchedulerMalformed(t *testing.T) {
+	errs := make(chan error, 1)
+
+	scheduler := jstransport.NewScheduler(nil, jstransport.SchedulerErrorHandler(gkit.ErrorHandlerFunc(func(_ context.Context, err error) {
+		errs <- err
+	})))
+
+	msg := &messageMock{subject: "schedules.foo", headers: nats.Header{jstransport.ScheduleAtHeader: {time.Now().Format(time.RFC3339)}}}
+	scheduler.HandleMessage(msg)
+
+	if want, have := []string{"term"}, msg.acks; !reflect.DeepEqual(want, have) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+
+	if err := <-errs; err == nil {
+		t.Error("want error, have nil")
+	}
+}
+
+func TestSchedulerMaxPending(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	js, _, stop := newJetstream(ctx, t)
+	defer stop()
+
+	if _, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:     "test:schedules",
+		Subjects: []string{"schedules.>"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		durable string
+		options []gkit.Option[*jstransport.Scheduler]
+		want    int
+	}{
+		{durable: "unlimited", want: -1},
+		{durable: "limited", options: []gkit.Option[*jstransport.Scheduler]{jstransport.SchedulerMaxPending(5000)}, want: 5000},
+	} {
+		consumeCtx, err := jstransport.NewScheduler(js, tc.options...).Start(ctx, "test:schedules", tc.durable)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		consumeCtx.Stop()
+
+		consumer, err := js.Consumer(ctx, "test:schedules", tc.durable)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, have := tc.want, consumer.CachedInfo().Config.MaxAckPending; want != have {
+			t.Errorf("%s: want MaxAckPending %d, have %d", tc.durable, want, have)
+		}
+	}
+}
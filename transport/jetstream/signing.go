@@ -0,0 +1,315 @@
+package jetstream
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nkeys"
+)
+
+const (
+	// SignatureHeader carries the signature of a message, encoded as base64.
+	SignatureHeader = "Signature"
+
+	// SignatureKeyHeader carries the public nkey of the signer of a message.
+	SignatureKeyHeader = "Signature-Key"
+
+	// SignatureHeadersHeader carries the comma separated names of the headers
+	// covered by the signature of a message, in addition to its data.
+	SignatureHeadersHeader = "Signature-Headers"
+)
+
+var (
+	// ErrMissingSignature is returned by Verifier for messages that aren't signed.
+	ErrMissingSignature = errors.New("jstransport: missing signature")
+
+	// ErrUntrustedSigner is returned by Verifier for messages signed with a key
+	// that isn't trusted.
+	ErrUntrustedSigner = errors.New("jstransport: untrusted signer")
+)
+
+// Signer signs outgoing messages with an nkeys key pair, so that subscribers
+// can verify they come from a trusted publisher. The signature covers the
+// message data and the selected headers, but not the subject, which may be
+// rewritten, e.g. by a Scheduler.
+type Signer struct {
+	kp      nkeys.KeyPair
+	headers []string
+}
+
+// NewSigner constructs a signer signing with kp, e.g. obtained from a seed with
+// nkeys.FromSeed.
+func NewSigner(kp nkeys.KeyPair, options ...gkit.Option[*Signer]) *Signer {
+	s := &Signer{kp: kp}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	return s
+}
+
+// NewEd25519Signer constructs a signer signing with an ed25519 private key.
+// Its public nkey, as trusted by verifiers, is given by Ed25519PublicKey.
+func NewEd25519Signer(key ed25519.PrivateKey, options ...gkit.Option[*Signer]) (*Signer, error) {
+	kp, err := nkeys.FromRawSeed(nkeys.PrefixByteUser, key.Seed())
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSigner(kp, options...), nil
+}
+
+// Ed25519PublicKey returns the public nkey of an ed25519 public key.
+func Ed25519PublicKey(key ed25519.PublicKey) (string, error) {
+	public, err := nkeys.Encode(nkeys.PrefixByteUser, key)
+	if err != nil {
+		return "", err
+	}
+
+	return string(public), nil
+}
+
+// SignerHeaders sets the headers covered by the signature, in addition to the
+// message data. Headers missing from a message are signed as empty.
+func SignerHeaders(headers ...string) gkit.Option[*Signer] {
+	return func(s *Signer) { s.headers = headers }
+}
+
+// SignEncoder wraps a publisher encoder. Encoded messages are signed, and fail
+// to be encoded when they can't be, so that no message is published unsigned.
+// It must wrap any CompressEncoder or EncryptEncoder, so that the signature
+// covers the data as published. The headers covered by the signature must be
+// set by the wrapped encoder, since a PublisherBefore changing them would break
+// the signature.
+func SignEncoder[Req any](s *Signer, enc gkit.EncodeDecodeFunc[Req, *nats.Msg]) gkit.EncodeDecodeFunc[Req, *nats.Msg] {
+	return func(ctx context.Context, request Req) (*nats.Msg, error) {
+		msg, err := enc(ctx, request)
+		if err != nil {
+			return msg, err
+		}
+
+		if msg.Header == nil {
+			msg.Header = nats.Header{}
+		}
+
+		if err := s.sign(msg); err != nil {
+			return nil, err
+		}
+
+		return msg, nil
+	}
+}
+
+func (s *Signer) sign(msg *nats.Msg) error {
+	public, err := s.kp.PublicKey()
+	if err != nil {
+		return err
+	}
+
+	msg.Header.Del(SignatureHeadersHeader)
+
+	if len(s.headers) > 0 {
+		msg.Header.Set(SignatureHeadersHeader, strings.Join(s.headers, ","))
+	}
+
+	sig, err := s.kp.Sign(signingInput(msg.Header, msg.Data))
+	if err != nil {
+		return err
+	}
+
+	msg.Header.Set(SignatureKeyHeader, public)
+	msg.Header.Set(SignatureHeader, base64.StdEncoding.EncodeToString(sig))
+
+	return nil
+}
+
+// VerificationError is returned by subscribers using SubscriberVerifier when a
+// message can't be verified. Redelivering such a message won't help, so
+// subscribers would rather terminate it with TermOnVerificationError.
+type VerificationError struct {
+	// Signer is the public nkey the message claims to be signed with, if any.
+	Signer string
+
+	Err error
+}
+
+// Error implements error.
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("jstransport: cannot verify message signed by %q: %v", e.Signer, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *VerificationError) Unwrap() error {
+	return e.Err
+}
+
+// Verifier verifies the signature of incoming messages against a set of
+// trusted keys.
+type Verifier struct {
+	trusted      map[string]nkeys.KeyPair
+	js           jetstream.JetStream
+	dlq          string
+	errorHandler gkit.ErrorHandler
+}
+
+// NewVerifier constructs a verifier trusting the signers of the given public
+// nkeys.
+func NewVerifier(trusted []string, options ...gkit.Option[*Verifier]) (*Verifier, error) {
+	v := &Verifier{
+		trusted:      make(map[string]nkeys.KeyPair, len(trusted)),
+		errorHandler: gkit.LogErrorHandler(nil),
+	}
+
+	for _, public := range trusted {
+		kp, err := nkeys.FromPublicKey(public)
+		if err != nil {
+			return nil, fmt.Errorf("jstransport: invalid trusted key %q: %w", public, err)
+		}
+
+		v.trusted[public] = kp
+	}
+
+	for _, option := range options {
+		option(v)
+	}
+
+	return v, nil
+}
+
+// VerifierDLQ publishes the messages that can't be verified to the dead letter
+// queue subject, with their original subject in the DLQSubjectHeader, before
+// they fail with a VerificationError wrapped with ErrDeadLettered, so that the
+// subscriber terminates them whatever its ack policy. Messages failing to be
+// published fail with the publish error instead, so that they are redelivered
+// by the default ack policy.
+func VerifierDLQ(js jetstream.JetStream, subject string) gkit.Option[*Verifier] {
+	return func(v *Verifier) {
+		v.js = js
+		v.dlq = subject
+	}
+}
+
+// VerifierErrorHandler is used to handle dead letter queue failures. By
+// default, they are logged.
+func VerifierErrorHandler(errorHandler gkit.ErrorHandler) gkit.Option[*Verifier] {
+	return func(v *Verifier) { v.errorHandler = errorHandler }
+}
+
+// Verify checks the signature of msg, and returns the public nkey of its signer.
+func (v *Verifier) Verify(msg jetstream.Msg) (string, error) {
+	public := msg.Headers().Get(SignatureKeyHeader)
+
+	encoded := msg.Headers().Get(SignatureHeader)
+	if encoded == "" || public == "" {
+		return public, &VerificationError{Signer: public, Err: ErrMissingSignature}
+	}
+
+	kp, ok := v.trusted[public]
+	if !ok {
+		return public, &VerificationError{Signer: public, Err: ErrUntrustedSigner}
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return public, &VerificationError{Signer: public, Err: err}
+	}
+
+	if err := kp.Verify(signingInput(msg.Headers(), msg.Data()), sig); err != nil {
+		return public, &VerificationError{Signer: public, Err: err}
+	}
+
+	return public, nil
+}
+
+// SubscriberVerifier makes the subscriber verify every message before it is
+// decoded. The public nkey of the signer of verified messages is populated in
+// the context under ContextKeySigner. Messages that can't be verified fail
+// with a VerificationError, without being decoded.
+func SubscriberVerifier[Req, Res any](v *Verifier) gkit.Option[*Subscriber[Req, Res]] {
+	return func(s *Subscriber[Req, Res]) {
+		s.before = append(s.before, func(ctx context.Context, msg jetstream.Msg) context.Context {
+			signer, err := v.Verify(msg)
+			if err == nil {
+				return context.WithValue(ctx, ContextKeySigner, signer)
+			}
+
+			if v.dlq != "" {
+				if dlqErr := publishDLQ(ctx, v.js, v.dlq, msg); dlqErr != nil {
+					v.errorHandler.Handle(ctx, dlqErr)
+					err = dlqErr
+				} else {
+					err = fmt.Errorf("%w: %w", ErrDeadLettered, err)
+				}
+			}
+
+			return context.WithValue(ctx, contextKeyVerificationError, err)
+		})
+
+		dec := s.dec
+		s.dec = func(ctx context.Context, msg jetstream.Msg) (Req, error) {
+			if err, ok := ctx.Value(contextKeyVerificationError).(error); ok {
+				var request Req
+				return request, err
+			}
+
+			return dec(ctx, msg)
+		}
+	}
+}
+
+// TermOnVerificationError is an AckPolicy terminating messages failing with a
+// VerificationError, and negatively acknowledging messages failing otherwise.
+func TermOnVerificationError(ctx context.Context, err error) AckDisposition {
+	var verificationErr *VerificationError
+	if errors.As(err, &verificationErr) {
+		return AckDispositionTerm
+	}
+
+	return DefaultAckPolicy(ctx, err)
+}
+
+// signingInput returns the bytes covered by the signature of a message: the
+// headers named in the SignatureHeadersHeader, followed by the data. Names and
+// values are length-prefixed, so that distinct headers can't sign alike, e.g.
+// a single "a,b" value and the two values "a" and "b".
+func signingInput(header nats.Header, data []byte) []byte {
+	var buf bytes.Buffer
+
+	writeField := func(field string) {
+		buf.WriteString(strconv.Itoa(len(field)))
+		buf.WriteByte(':')
+		buf.WriteString(field)
+	}
+
+	if names := header.Get(SignatureHeadersHeader); names != "" {
+		for _, name := range strings.Split(names, ",") {
+			values := header.Values(name)
+
+			writeField(strings.ToLower(name))
+			buf.WriteByte(' ')
+			buf.WriteString(strconv.Itoa(len(values)))
+
+			for _, value := range values {
+				buf.WriteByte(' ')
+				writeField(value)
+			}
+
+			buf.WriteByte('\n')
+		}
+	}
+
+	buf.WriteByte('\n')
+	buf.Write(data)
+
+	return buf.Bytes()
+}
@@ -0,0 +1,267 @@
+//go:build unit
+
+package jetstream_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	jstransport "github.com/kikihakiem/gkit/transport/jetstream"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nkeys"
+)
+
+func signedMessage(t *testing.T, signer *jstransport.Signer, data string) *messageMock {
+	t.Helper()
+
+	enc := jstransport.SignEncoder(signer, func(_ context.Context, data string) (*nats.Msg, error) {
+		msg := nats.NewMsg("jstransport.test.signed")
+		msg.Data = []byte(data)
+		msg.Header.Set("Message-Type", "audit")
+
+		return msg, nil
+	})
+
+	msg, err := enc(context.Background(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &messageMock{subject: msg.Subject, data: msg.Data, headers: msg.Header}
+}
+
+func newVerifyingSubscriber(v *jstransport.Verifier, signers chan<- string) *jstransport.Subscriber[string, struct{}] {
+	return jstransport.NewSubscriber(
+		func(ctx context.Context, _ string) (struct{}, error) {
+			signer, _ := ctx.Value(jstransport.ContextKeySigner).(string)
+			signers <- signer
+
+			return struct{}{}, nil
+		},
+		func(_ context.Context, msg jetstream.Msg) (string, error) { return string(msg.Data()), nil },
+		gkit.NopResponseEncoder,
+		jstransport.SubscriberVerifier[string, struct{}](v),
+		jstransport.SubscriberAckPolicy[string, struct{}](jstransport.TermOnVerificationError),
+		jstransport.SubscriberErrorEncoder[string, struct{}](func(context.Context, jetstream.JetStream, error) {}),
+		jstransport.SubscriberErrorHandler[string, struct{}](gkit.ErrorHandlerFunc(func(context.Context, error) {})),
+	)
+}
+
+func TestSigning(t *testing.T) {
+	kp, err := nkeys.CreateUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	public, _ := kp.PublicKey()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edSigner, err := jstransport.NewEd25519Signer(key, jstransport.SignerHeaders("Message-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edPublic, err := jstransport.Ed25519PublicKey(key.Public().(ed25519.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	untrusted, _ := nkeys.CreateUser()
+
+	verifier, err := jstransport.NewVerifier([]string{public, edPublic})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer := jstransport.NewSigner(kp, jstransport.SignerHeaders("Message-Type"))
+
+	for name, tc := range map[string]struct {
+		msg    func() *messageMock
+		signer string
+		ack    string
+	}{
+		"nkeys": {
+			msg:    func() *messageMock { return signedMessage(t, signer, "event") },
+			signer: public,
+			ack:    "ack",
+		},
+		"ed25519": {
+			msg:    func() *messageMock { return signedMessage(t, edSigner, "event") },
+			signer: edPublic,
+			ack:    "ack",
+		},
+		"unsigned": {
+			msg: func() *messageMock {
+				return &messageMock{subject: "jstransport.test.signed", data: []byte("event"), headers: nats.Header{}}
+			},
+			ack: "term",
+		},
+		"untrusted": {
+			msg: func() *messageMock { return signedMessage(t, jstransport.NewSigner(untrusted), "event") },
+			ack: "term",
+		},
+		"tampered data": {
+			msg: func() *messageMock {
+				msg := signedMessage(t, signer, "event")
+				msg.data = []byte("forged")
+
+				return msg
+			},
+			ack: "term",
+		},
+		"tampered header": {
+			msg: func() *messageMock {
+				msg := signedMessage(t, signer, "event")
+				msg.headers.Set("Message-Type", "forged")
+
+				return msg
+			},
+			ack: "term",
+		},
+		"tampered header values": {
+			msg: func() *messageMock {
+				msg := signedMessage(t, signer, "event")
+				msg.headers["Message-Type"] = []string{"audit", "forged"}
+
+				return msg
+			},
+			ack: "term",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			signers := make(chan string, 1)
+			msg := tc.msg()
+
+			newVerifyingSubscriber(verifier, signers).HandleMessage(nil)(msg)
+
+			if want, have := []string{tc.ack}, msg.acks; len(have) != 1 || want[0] != have[0] {
+				t.Fatalf("want %v, have %v", want, have)
+			}
+
+			if tc.ack != "ack" {
+				return
+			}
+
+			if want, have := tc.signer, <-signers; want != have {
+				t.Errorf("want signer %q, have %q", want, have)
+			}
+		})
+	}
+}
+
+func TestSigningMergedHeaderValues(t *testing.T) {
+	kp, _ := nkeys.CreateUser()
+	public, _ := kp.PublicKey()
+
+	verifier, err := jstransport.NewVerifier([]string{public})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc := jstransport.SignEncoder(jstransport.NewSigner(kp, jstransport.SignerHeaders("Message-Type")), func(_ context.Context, data string) (*nats.Msg, error) {
+		msg := nats.NewMsg("jstransport.test.signed")
+		msg.Data = []byte(data)
+		msg.Header.Add("Message-Type", "audit")
+		msg.Header.Add("Message-Type", "billing")
+
+		return msg, nil
+	})
+
+	msg, err := enc(context.Background(), "event")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg.Header["Message-Type"] = []string{"audit,billing"}
+
+	_, err = verifier.Verify(&messageMock{subject: msg.Subject, data: msg.Data, headers: msg.Header})
+
+	var verificationErr *jstransport.VerificationError
+	if !errors.As(err, &verificationErr) {
+		t.Errorf("want VerificationError, have %v", err)
+	}
+}
+
+func TestSignEncoderFailsClosed(t *testing.T) {
+	kp, _ := nkeys.CreateUser()
+	public, _ := kp.PublicKey()
+
+	// a key pair without its seed can't sign.
+	publicOnly, err := nkeys.FromPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc := jstransport.SignEncoder(jstransport.NewSigner(publicOnly), func(_ context.Context, data string) (*nats.Msg, error) {
+		return &nats.Msg{Subject: "jstransport.test.signed", Data: []byte(data)}, nil
+	})
+
+	msg, err := enc(context.Background(), "event")
+	if err == nil {
+		t.Fatalf("want error, have message %v", msg)
+	}
+}
+
+func TestVerifierDLQ(t *testing.T) {
+	kp, _ := nkeys.CreateUser()
+	public, _ := kp.PublicKey()
+
+	js := &jetstreamMock{dataChan: make(chan string, 1)}
+
+	verifier, err := jstransport.NewVerifier([]string{public}, jstransport.VerifierDLQ(js, "jstransport.dlq"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := &messageMock{subject: "jstransport.test.signed", data: []byte("unsigned"), headers: nats.Header{}}
+
+	// terminated even with the default ack policy, so that redeliveries don't
+	// fill the dead letter queue.
+	jstransport.NewSubscriber(
+		func(context.Context, string) (struct{}, error) { return struct{}{}, nil },
+		func(_ context.Context, msg jetstream.Msg) (string, error) { return string(msg.Data()), nil },
+		gkit.NopResponseEncoder,
+		jstransport.SubscriberVerifier[string, struct{}](verifier),
+		jstransport.SubscriberErrorEncoder[string, struct{}](func(context.Context, jetstream.JetStream, error) {}),
+		jstransport.SubscriberErrorHandler[string, struct{}](gkit.ErrorHandlerFunc(func(context.Context, error) {})),
+	).HandleMessage(nil)(msg)
+
+	if want, have := "unsigned", <-js.dataChan; want != have {
+		t.Errorf("want %q in the dead letter queue, have %q", want, have)
+	}
+
+	if want, have := []string{"term"}, msg.acks; len(have) != 1 || want[0] != have[0] {
+		t.Errorf("want %v, have %v", want, have)
+	}
+
+	_, err = verifier.Verify(msg)
+	if !errors.Is(err, jstransport.ErrMissingSignature) {
+		t.Errorf("want ErrMissingSignature, have %v", err)
+	}
+}
+
+func TestCombineAckPolicies(t *testing.T) {
+	policy := jstransport.CombineAckPolicies(jstransport.TermOnVerificationError, jstransport.TermOnDecryptError)
+
+	for name, tc := range map[string]struct {
+		err         error
+		disposition jstransport.AckDisposition
+	}{
+		"verification": {err: &jstransport.VerificationError{Err: jstransport.ErrMissingSignature}, disposition: jstransport.AckDispositionTerm},
+		"decryption":   {err: &jstransport.DecryptError{Err: jstransport.ErrNotEncrypted}, disposition: jstransport.AckDispositionTerm},
+		"other":        {err: errors.New("dang"), disposition: jstransport.AckDispositionNak},
+	} {
+		if want, have := tc.disposition, policy(context.Background(), tc.err); want != have {
+			t.Errorf("%s: want %s, have %s", name, want, have)
+		}
+	}
+}
@@ -0,0 +1,628 @@
+package jetstream
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// ErrSpoolFull is returned when appending a message would make the spool
+// exceed its maximum size.
+var ErrSpoolFull = errors.New("jstransport: spool full")
+
+// ErrSpoolForwarding is returned by Forward when the spool is already being
+// forwarded, since concurrent forwarders would publish messages twice.
+var ErrSpoolForwarding = errors.New("jstransport: spool already forwarding")
+
+const (
+	spoolSegmentExt  = ".seg"
+	spoolCursorFile  = "cursor"
+	spoolRecordHdrSz = 8 // length and CRC-32 of the record payload.
+)
+
+// SpoolStats are the metrics of a spool.
+type SpoolStats struct {
+	// Pending is the number of messages waiting to be forwarded.
+	Pending int64
+
+	// Bytes is the size of the messages waiting to be forwarded.
+	Bytes int64
+
+	// Segments is the number of segment files.
+	Segments int
+
+	// Spooled is the number of messages appended since the spool was opened.
+	Spooled uint64
+
+	// Forwarded is the number of messages forwarded since the spool was opened.
+	Forwarded uint64
+
+	// Dropped is the number of messages rejected by the server while being
+	// forwarded, or skipped because their record was corrupted, since the
+	// spool was opened.
+	Dropped uint64
+
+	// Rejected is the number of messages that didn't fit in the spool since it
+	// was opened.
+	Rejected uint64
+}
+
+// Spool is a disk-backed queue of messages that failed to be published, e.g.
+// because the connection to NATS was lost. Messages are appended to segment
+// files in a directory, and forwarded in order by Forward once the connection
+// recovers. The position of the next message to forward is kept in the
+// directory as well, so that the spool survives restarts. Messages are
+// forwarded at least once, so a message forwarded right before a crash may be
+// forwarded again; publishing with a Nats-Msg-Id lets the server discard it.
+type Spool struct {
+	dir          string
+	segmentSize  int64
+	maxSize      int64
+	retryDelay   time.Duration
+	errorHandler gkit.ErrorHandler
+
+	mu       sync.Mutex
+	segments []spoolSegment
+	w        *os.File
+	r        *os.File
+	cursor   spoolCursor
+	stats    SpoolStats
+	notify   chan struct{}
+
+	forwarding atomic.Bool
+}
+
+type spoolSegment struct {
+	id   uint64
+	size int64
+}
+
+type spoolCursor struct {
+	segment uint64
+	offset  int64
+}
+
+// spoolRecord is the payload of a record of a segment file.
+type spoolRecord struct {
+	Subject string              `json:"subject"`
+	Header  map[string][]string `json:"header,omitempty"`
+	Data    []byte              `json:"data,omitempty"`
+}
+
+// NewSpool opens the spool stored in dir, creating it if needed. By default,
+// segment files are rolled over at 16 MiB, and the spool holds up to 1 GiB.
+func NewSpool(dir string, options ...gkit.Option[*Spool]) (*Spool, error) {
+	s := &Spool{
+		dir:          dir,
+		segmentSize:  16 << 20,
+		maxSize:      1 << 30,
+		retryDelay:   time.Second,
+		errorHandler: gkit.LogErrorHandler(nil),
+		notify:       make(chan struct{}, 1),
+	}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	if err := s.recover(); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// SpoolSegmentSize sets the size in bytes above which a new segment file is
+// started. Segment files are deleted once all their messages are forwarded.
+func SpoolSegmentSize(size int64) gkit.Option[*Spool] {
+	return func(s *Spool) { s.segmentSize = size }
+}
+
+// SpoolMaxSize sets the maximum size in bytes of the messages waiting to be
+// forwarded. Appending more fails with ErrSpoolFull. Zero means no limit.
+func SpoolMaxSize(size int64) gkit.Option[*Spool] {
+	return func(s *Spool) { s.maxSize = size }
+}
+
+// SpoolRetryDelay sets the delay before forwarding a message again after
+// failing to reach the server. By default, it is one second.
+func SpoolRetryDelay(retryDelay time.Duration) gkit.Option[*Spool] {
+	return func(s *Spool) { s.retryDelay = retryDelay }
+}
+
+// SpoolErrorHandler is used to handle the errors forwarding messages. By
+// default, they are logged.
+func SpoolErrorHandler(errorHandler gkit.ErrorHandler) gkit.Option[*Spool] {
+	return func(s *Spool) { s.errorHandler = errorHandler }
+}
+
+// PublisherSpool makes the publisher append the messages failing to reach the
+// server to spool, rather than returning an error. Spooled messages are
+// acknowledged with an empty PubAck and PublishOutcomeSpooled, and must be
+// forwarded by running spool.Forward. Only the messages failing with a
+// retryable error, see IsRetryable, are spooled.
+func PublisherSpool[Req, Res any](spool *Spool) gkit.Option[*Publisher[Req, Res]] {
+	return func(p *Publisher[Req, Res]) { p.spool = spool }
+}
+
+// Append adds msg at the end of the spool. The segment file is synced before
+// returning, so that a message acknowledged as spooled survives a crash of the
+// host.
+func (s *Spool) Append(msg *nats.Msg) error {
+	payload, err := json.Marshal(spoolRecord{Subject: msg.Subject, Header: msg.Header, Data: msg.Data})
+	if err != nil {
+		return err
+	}
+
+	record := make([]byte, spoolRecordHdrSz+len(payload))
+	binary.BigEndian.PutUint32(record, uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:], crc32.ChecksumIEEE(payload))
+	copy(record[spoolRecordHdrSz:], payload)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxSize > 0 && s.stats.Bytes+int64(len(record)) > s.maxSize {
+		s.stats.Rejected++
+		return ErrSpoolFull
+	}
+
+	active := &s.segments[len(s.segments)-1]
+	if active.size > 0 && active.size+int64(len(record)) > s.segmentSize {
+		if err := s.roll(); err != nil {
+			return err
+		}
+
+		active = &s.segments[len(s.segments)-1]
+	}
+
+	n, err := s.w.Write(record)
+	if err == nil {
+		err = s.w.Sync()
+	}
+
+	if err != nil {
+		// drop the partial record, so that the next one is appended right.
+		s.w.Truncate(active.size)           //nolint:errcheck
+		s.w.Seek(active.size, io.SeekStart) //nolint:errcheck
+
+		return err
+	}
+
+	active.size += int64(n)
+	s.stats.Bytes += int64(n)
+	s.stats.Pending++
+	s.stats.Spooled++
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Pending returns the number of messages waiting to be forwarded.
+func (s *Spool) Pending() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.stats.Pending
+}
+
+// Stats returns the metrics of the spool.
+func (s *Spool) Stats() SpoolStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.stats
+	stats.Segments = len(s.segments)
+
+	return stats
+}
+
+// Forward publishes the spooled messages in order with js, until ctx is done.
+// A message failing to reach the server is retried after the retry delay,
+// holding back the following ones. A message that can't be stored however
+// many times it is published, see IsRetryable, is dropped, and so is a message
+// whose record is corrupted; both are reported to the error handler. Only one
+// Forward may run at a time; others fail with ErrSpoolForwarding.
+func (s *Spool) Forward(ctx context.Context, js jetstream.JetStream) error {
+	if !s.forwarding.CompareAndSwap(false, true) {
+		return ErrSpoolForwarding
+	}
+	defer s.forwarding.Store(false)
+
+	for {
+		msg, next, err := s.peek()
+		if errors.Is(err, errCorruptedSpoolRecord) {
+			s.errorHandler.Handle(ctx, err)
+
+			if err := s.advance(next, true); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if msg == nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-s.notify:
+			}
+
+			continue
+		}
+
+		_, err = js.PublishMsg(ctx, msg)
+		if err != nil {
+			err = publishError(msg, err)
+		}
+
+		switch {
+		case err == nil:
+		case !IsRetryable(err):
+			s.errorHandler.Handle(ctx, fmt.Errorf("jstransport: dropping spooled message to %s: %w", msg.Subject, err))
+		default:
+			s.errorHandler.Handle(ctx, err)
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(s.retryDelay):
+			}
+
+			continue
+		}
+
+		if err := s.advance(next, err != nil); err != nil {
+			return err
+		}
+	}
+}
+
+// Close closes the segment files. The spool must not be used afterwards.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+
+	for _, f := range []*os.File{s.w, s.r} {
+		if f != nil {
+			errs = append(errs, f.Close())
+		}
+	}
+
+	s.w, s.r = nil, nil
+
+	return errors.Join(errs...)
+}
+
+// peek reads the next message to forward, and returns it with the position
+// following it. It returns a nil message when the spool is empty. A corrupted
+// record fails with errCorruptedSpoolRecord, along with the position following
+// it, so that it can be skipped.
+func (s *Spool) peek() (*nats.Msg, spoolCursor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for s.stats.Pending > 0 {
+		segment := s.segments[0]
+
+		if s.cursor.offset >= segment.size {
+			if len(s.segments) == 1 {
+				break
+			}
+
+			if err := s.dropSegment(); err != nil {
+				return nil, s.cursor, err
+			}
+
+			continue
+		}
+
+		if s.r == nil {
+			r, err := os.Open(s.segmentPath(segment.id))
+			if err != nil {
+				return nil, s.cursor, err
+			}
+
+			s.r = r
+		}
+
+		record, size, err := readSpoolRecord(s.r, s.cursor.offset, segment.size)
+		if size == 0 {
+			return nil, s.cursor, err
+		}
+
+		next := spoolCursor{segment: segment.id, offset: s.cursor.offset + size}
+
+		if err != nil {
+			return nil, next, fmt.Errorf("%w at offset %d of %s", err, s.cursor.offset, s.segmentPath(segment.id))
+		}
+
+		msg := nats.NewMsg(record.Subject)
+		msg.Data = record.Data
+
+		for k, v := range record.Header {
+			msg.Header[k] = v
+		}
+
+		return msg, next, nil
+	}
+
+	return nil, s.cursor, nil
+}
+
+// advance moves past the message forwarded or dropped up to next.
+func (s *Spool) advance(next spoolCursor, dropped bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.Bytes -= next.offset - s.cursor.offset
+	s.stats.Pending--
+
+	if dropped {
+		s.stats.Dropped++
+	} else {
+		s.stats.Forwarded++
+	}
+
+	s.cursor = next
+
+	if s.cursor.offset >= s.segments[0].size && len(s.segments) > 1 {
+		if err := s.dropSegment(); err != nil {
+			return err
+		}
+	}
+
+	return s.saveCursor()
+}
+
+// dropSegment deletes the first segment, once all its messages are forwarded.
+// The active segment is never deleted.
+func (s *Spool) dropSegment() error {
+	if len(s.segments) == 1 {
+		return nil
+	}
+
+	if s.r != nil {
+		s.r.Close()
+		s.r = nil
+	}
+
+	if err := os.Remove(s.segmentPath(s.segments[0].id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	s.segments = s.segments[1:]
+	s.cursor = spoolCursor{segment: s.segments[0].id}
+
+	return s.saveCursor()
+}
+
+// roll starts a new active segment.
+func (s *Spool) roll() error {
+	id := s.segments[len(s.segments)-1].id + 1
+
+	w, err := os.OpenFile(s.segmentPath(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	s.w.Close()
+	s.w = w
+	s.segments = append(s.segments, spoolSegment{id: id})
+
+	return nil
+}
+
+// recover loads the segments and the cursor from the directory, and counts the
+// messages waiting to be forwarded. The active segment ending with a partially
+// written record, e.g. after a crash, is truncated to its last complete record.
+func (s *Spool) recover() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), spoolSegmentExt)
+		if !ok {
+			continue
+		}
+
+		id, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		s.segments = append(s.segments, spoolSegment{id: id})
+	}
+
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].id < s.segments[j].id })
+
+	if len(s.segments) == 0 {
+		s.segments = append(s.segments, spoolSegment{id: 1})
+	}
+
+	s.cursor = spoolCursor{segment: s.segments[0].id}
+
+	if b, err := os.ReadFile(filepath.Join(s.dir, spoolCursorFile)); err == nil {
+		var cursor spoolCursor
+		if _, err := fmt.Sscan(string(b), &cursor.segment, &cursor.offset); err == nil && cursor.segment >= s.segments[0].id {
+			s.cursor = cursor
+		}
+	}
+
+	// drop the segments forwarded entirely.
+	for len(s.segments) > 1 && s.segments[0].id < s.cursor.segment {
+		if err := os.Remove(s.segmentPath(s.segments[0].id)); err != nil {
+			return err
+		}
+
+		s.segments = s.segments[1:]
+	}
+
+	if s.cursor.segment != s.segments[0].id {
+		s.cursor = spoolCursor{segment: s.segments[0].id}
+	}
+
+	for i := range s.segments {
+		if err := s.scan(&s.segments[i], i == len(s.segments)-1); err != nil {
+			return err
+		}
+	}
+
+	active := s.segments[len(s.segments)-1]
+
+	w, err := os.OpenFile(s.segmentPath(active.id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	s.w = w
+
+	return nil
+}
+
+// scan counts the records of a segment waiting to be forwarded, including the
+// corrupted ones, which are skipped by Forward. The records following one that
+// can't be delimited are lost: the active segment is truncated there, so that
+// messages are appended right after its last complete record, while the other
+// segments are only read up to there. Either way, the loss is reported.
+func (s *Spool) scan(segment *spoolSegment, active bool) error {
+	f, err := os.OpenFile(s.segmentPath(segment.id), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var offset int64
+
+	for offset < info.Size() {
+		var size int64
+
+		_, size, err = readSpoolRecord(f, offset, info.Size())
+		if size == 0 {
+			break
+		}
+
+		if segment.id > s.cursor.segment || offset >= s.cursor.offset {
+			s.stats.Pending++
+			s.stats.Bytes += size
+		}
+
+		offset += size
+	}
+
+	segment.size = offset
+
+	if offset == info.Size() {
+		return nil
+	}
+
+	ctx := context.Background()
+
+	if !active {
+		s.errorHandler.Handle(ctx, fmt.Errorf("jstransport: ignoring the last %d bytes of spool segment %s: %w", info.Size()-offset, f.Name(), err))
+		return nil
+	}
+
+	s.errorHandler.Handle(ctx, fmt.Errorf("jstransport: truncating spool segment %s from %d to %d bytes: %w", f.Name(), info.Size(), offset, err))
+
+	return f.Truncate(offset)
+}
+
+func (s *Spool) saveCursor() error {
+	path := filepath.Join(s.dir, spoolCursorFile)
+
+	err := os.WriteFile(path+".tmp", []byte(fmt.Sprintf("%d %d", s.cursor.segment, s.cursor.offset)), 0o644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+func (s *Spool) segmentPath(id uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", id, spoolSegmentExt))
+}
+
+var (
+	errIncompleteSpoolRecord = errors.New("jstransport: incomplete spool record")
+	errCorruptedSpoolRecord  = errors.New("jstransport: corrupted spool record")
+)
+
+// readSpoolRecord reads the record at offset of a segment of the given size,
+// and returns it with its size on disk. It fails if the record is incomplete
+// or corrupted. The size is returned along with errCorruptedSpoolRecord when
+// the record can be delimited, i.e. only its payload is corrupted.
+func readSpoolRecord(r io.ReaderAt, offset, segmentSize int64) (*spoolRecord, int64, error) {
+	var hdr [spoolRecordHdrSz]byte
+	if segmentSize-offset < spoolRecordHdrSz {
+		return nil, 0, errIncompleteSpoolRecord
+	}
+
+	if _, err := r.ReadAt(hdr[:], offset); err != nil {
+		return nil, 0, err
+	}
+
+	// the length is checked before allocating, since a torn header may hold
+	// any value. Records are never empty, while zeroed headers are a common
+	// leftover of a torn write.
+	length := int64(binary.BigEndian.Uint32(hdr[:]))
+	if length == 0 || length > segmentSize-offset-spoolRecordHdrSz {
+		return nil, 0, errIncompleteSpoolRecord
+	}
+
+	payload := make([]byte, length)
+	if _, err := r.ReadAt(payload, offset+spoolRecordHdrSz); err != nil {
+		return nil, 0, err
+	}
+
+	size := spoolRecordHdrSz + length
+
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(hdr[4:]) {
+		return nil, size, fmt.Errorf("%w: checksum mismatch", errCorruptedSpoolRecord)
+	}
+
+	var record spoolRecord
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return nil, size, fmt.Errorf("%w: %w", errCorruptedSpoolRecord, err)
+	}
+
+	return &record, size, nil
+}
@@ -0,0 +1,375 @@
+//go:build unit
+
+package jetstream_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	jstransport "github.com/kikihakiem/gkit/transport/jetstream"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// unreachableJetstream fails every publish as if the connection was lost.
+type unreachableJetstream struct {
+	jetstream.JetStream
+}
+
+func (unreachableJetstream) PublishMsg(context.Context, *nats.Msg, ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	return nil, nats.ErrConnectionClosed
+}
+
+// recordingJetstream publishes every message to msgs.
+type recordingJetstream struct {
+	jetstream.JetStream
+	msgs chan *nats.Msg
+}
+
+func (js recordingJetstream) PublishMsg(_ context.Context, msg *nats.Msg, _ ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	js.msgs <- msg
+	return &jetstream.PubAck{}, nil
+}
+
+func spoolMsg(subject, data string) *nats.Msg {
+	msg := nats.NewMsg(subject)
+	msg.Header.Set("X-Test", data)
+	msg.Data = []byte(data)
+
+	return msg
+}
+
+func TestSpoolRecovery(t *testing.T) {
+	dir := t.TempDir()
+
+	spool, err := jstransport.NewSpool(dir, jstransport.SpoolSegmentSize(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, data := range []string{"one", "two", "three"} {
+		if err := spool.Append(spoolMsg("jstransport.spool", data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := spool.Stats()
+	if want, have := int64(3), stats.Pending; want != have {
+		t.Errorf("want %d pending, have %d", want, have)
+	}
+
+	if stats.Segments < 2 {
+		t.Errorf("want segments rolled over, have %d", stats.Segments)
+	}
+
+	if err := spool.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	spool, err = jstransport.NewSpool(dir, jstransport.SpoolSegmentSize(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	if want, have := stats.Bytes, spool.Stats().Bytes; want != have {
+		t.Errorf("want %d bytes pending once reopened, have %d", want, have)
+	}
+}
+
+func TestSpoolForward(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	js, _, stop := newJetstream(ctx, t)
+	defer stop()
+
+	subject := "jstransport.spool." + strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	spool, err := jstransport.NewSpool(t.TempDir(), jstransport.SpoolSegmentSize(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	publisher := jstransport.NewPublisher(
+		unreachableJetstream{},
+		func(_ context.Context, req string) (*nats.Msg, error) { return spoolMsg(subject, req), nil },
+		func(ctx context.Context, _ *jetstream.PubAck) (jstransport.PublishOutcome, error) {
+			outcome, _ := jstransport.PublishOutcomeFromContext(ctx)
+			return outcome, nil
+		},
+		jstransport.PublisherSpool[string, jstransport.PublishOutcome](spool),
+	)
+
+	for _, data := range []string{"one", "two", "three"} {
+		outcome, err := publisher.Endpoint()(ctx, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, have := jstransport.PublishOutcomeSpooled, outcome; want != have {
+			t.Errorf("want %s, have %s", want, have)
+		}
+	}
+
+	forwardCtx, stopForwarding := context.WithCancel(ctx)
+	done := make(chan error, 1)
+
+	go func() { done <- spool.Forward(forwardCtx, js) }()
+
+	consumer, err := js.OrderedConsumer(ctx, "test:stream", jetstream.OrderedConsumerConfig{FilterSubjects: []string{subject}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"one", "two", "three"} {
+		msg, err := consumer.Next(jetstream.FetchMaxWait(5 * time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if have := string(msg.Data()); want != have {
+			t.Errorf("want %q, have %q", want, have)
+		}
+
+		if have := msg.Headers().Get("X-Test"); want != have {
+			t.Errorf("want header %q, have %q", want, have)
+		}
+	}
+
+	stopForwarding()
+
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("want %v, have %v", context.Canceled, err)
+	}
+
+	stats := spool.Stats()
+	if want, have := uint64(3), stats.Forwarded; want != have {
+		t.Errorf("want %d forwarded, have %d", want, have)
+	}
+
+	if want, have := int64(0), stats.Pending; want != have {
+		t.Errorf("want %d pending, have %d", want, have)
+	}
+
+	if want, have := 1, stats.Segments; want != have {
+		t.Errorf("want forwarded segments deleted, have %d segments", have)
+	}
+}
+
+func TestSpoolFull(t *testing.T) {
+	spool, err := jstransport.NewSpool(t.TempDir(), jstransport.SpoolMaxSize(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	publisher := jstransport.NewPublisher(
+		unreachableJetstream{},
+		func(_ context.Context, req string) (*nats.Msg, error) { return spoolMsg("jstransport.spool", req), nil },
+		gkit.NopEncoderDecoder[*jetstream.PubAck, struct{}],
+		jstransport.PublisherSpool[string, struct{}](spool),
+	)
+
+	if _, err := publisher.Endpoint()(context.Background(), "one"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = publisher.Endpoint()(context.Background(), "two")
+	if !errors.Is(err, jstransport.ErrSpoolFull) {
+		t.Errorf("want %v, have %v", jstransport.ErrSpoolFull, err)
+	}
+
+	if want, have := uint64(1), spool.Stats().Rejected; want != have {
+		t.Errorf("want %d rejected, have %d", want, have)
+	}
+}
+
+func TestSpoolRecoveryTornHeader(t *testing.T) {
+	dir := t.TempDir()
+
+	spool, err := jstransport.NewSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := spool.Append(spoolMsg("jstransport.spool", "one")); err != nil {
+		t.Fatal(err)
+	}
+
+	bytes := spool.Stats().Bytes
+	spool.Close()
+
+	// a record header claiming a 4 GiB payload, as left by a torn write.
+	f, err := os.OpenFile(filepath.Join(dir, fmt.Sprintf("%020d.seg", 1)), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Write([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	f.Close()
+
+	spool, err = jstransport.NewSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	stats := spool.Stats()
+	if want, have := int64(1), stats.Pending; want != have {
+		t.Errorf("want %d pending, have %d", want, have)
+	}
+
+	if want, have := bytes, stats.Bytes; want != have {
+		t.Errorf("want torn record truncated to %d bytes, have %d", want, have)
+	}
+}
+
+func TestSpoolCorrupted(t *testing.T) {
+	dir := t.TempDir()
+
+	spool, err := jstransport.NewSpool(dir, jstransport.SpoolSegmentSize(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, data := range []string{"one", "two", "three"} {
+		if err := spool.Append(spoolMsg("jstransport.spool", data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if want, have := 3, spool.Stats().Segments; want != have {
+		t.Fatalf("want a segment per message, have %d segments", have)
+	}
+
+	spool.Close()
+
+	segment := func(id int) string { return filepath.Join(dir, fmt.Sprintf("%020d.seg", id)) }
+
+	// a flipped bit in the payload of the first message.
+	f, err := os.OpenFile(segment(1), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.WriteAt([]byte{'X'}, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	f.Close()
+
+	// a torn record at the end of the second segment, which isn't the active one.
+	f, err = os.OpenFile(segment(2), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Write([]byte{0xff, 0xff}); err != nil {
+		t.Fatal(err)
+	}
+
+	f.Close()
+
+	info, err := os.Stat(segment(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 2)
+
+	spool, err = jstransport.NewSpool(dir, jstransport.SpoolSegmentSize(100), jstransport.SpoolErrorHandler(gkit.ErrorHandlerFunc(func(_ context.Context, err error) {
+		errs <- err
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	if err := <-errs; err == nil {
+		t.Error("want the torn record reported, have nil")
+	}
+
+	if after, err := os.Stat(segment(2)); err != nil || after.Size() != info.Size() {
+		t.Errorf("want segment 2 left untouched, have %v", err)
+	}
+
+	if want, have := int64(3), spool.Pending(); want != have {
+		t.Errorf("want %d pending, have %d", want, have)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	js := recordingJetstream{msgs: make(chan *nats.Msg, 3)}
+
+	go func() { done <- spool.Forward(ctx, js) }()
+
+	for _, want := range []string{"two", "three"} {
+		select {
+		case msg := <-js.msgs:
+			if have := string(msg.Data); want != have {
+				t.Errorf("want %q, have %q", want, have)
+			}
+		case err := <-done:
+			t.Fatalf("want forwarding to go on past the corrupted message, have %v", err)
+		}
+	}
+
+	cancel()
+	<-done
+
+	if err := <-errs; err == nil {
+		t.Error("want the corrupted message reported, have nil")
+	}
+
+	stats := spool.Stats()
+	if want, have := uint64(1), stats.Dropped; want != have {
+		t.Errorf("want %d dropped, have %d", want, have)
+	}
+
+	if want, have := int64(0), stats.Pending; want != have {
+		t.Errorf("want %d pending, have %d", want, have)
+	}
+}
+
+func TestSpoolSingleForwarder(t *testing.T) {
+	spool, err := jstransport.NewSpool(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() { done <- spool.Forward(ctx, unreachableJetstream{}) }()
+
+	stopped, cancelStopped := context.WithCancel(context.Background())
+	cancelStopped()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !errors.Is(spool.Forward(stopped, unreachableJetstream{}), jstransport.ErrSpoolForwarding) {
+		if time.Now().After(deadline) {
+			t.Fatal("want a concurrent Forward rejected")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("want %v, have %v", context.Canceled, err)
+	}
+}
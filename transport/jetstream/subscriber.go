@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"sync"
+	"time"
 
 	gkit "github.com/kikihakiem/gkit/core"
 	"github.com/nats-io/nats.go"
@@ -20,6 +23,13 @@ type Subscriber[Req, Res any] struct {
 	errorEncoder gkit.ErrorEncoder[jetstream.JetStream]
 	finalizer    []gkit.FinalizerFunc[jetstream.Msg]
 	errorHandler gkit.ErrorHandler
+	ackPolicy    AckPolicy
+	dedup        *Deduplicator
+
+	baseContext    func(jetstream.Msg) context.Context
+	timeout        time.Duration
+	deadlineHeader string
+	inflight       *inflight
 }
 
 // NewSubscriber constructs a new subscriber, which provides nats.MsgHandler and wraps
@@ -36,6 +46,8 @@ func NewSubscriber[Req, Res any](
 		enc:          enc,
 		errorEncoder: EncodeJSONError,
 		errorHandler: gkit.LogErrorHandler(nil),
+		ackPolicy:    DefaultAckPolicy,
+		inflight:     &inflight{},
 	}
 
 	for _, option := range options {
@@ -93,68 +105,375 @@ func SubscriberErrorHandler[Req, Res any](errorHandler gkit.ErrorHandler) gkit.O
 	return func(s *Subscriber[Req, Res]) { s.errorHandler = errorHandler }
 }
 
-// SubscriberFinalizer is executed at the end of every request from a publisher through NATS.
-// By default, no finalizer is registered.
+// SubscriberFinalizer is executed at the end of every message, once it has been
+// acknowledged. The outcome of the message is provided in the context under
+// ContextKeyMessageSummary. By default, no finalizer is registered.
 func SubscriberFinalizer[Req, Res any](finalizerFunc ...gkit.FinalizerFunc[jetstream.Msg]) gkit.Option[*Subscriber[Req, Res]] {
 	return func(s *Subscriber[Req, Res]) { s.finalizer = finalizerFunc }
 }
 
+// SubscriberAckPolicy sets how messages failing to be handled are
+// acknowledged. By default, they are negatively acknowledged to be redelivered.
+func SubscriberAckPolicy[Req, Res any](policy AckPolicy) gkit.Option[*Subscriber[Req, Res]] {
+	return func(s *Subscriber[Req, Res]) { s.ackPolicy = policy }
+}
+
+// SubscriberBaseContext sets the context every request context is derived
+// from, so that cancelling it, e.g. on shutdown, cancels the running endpoints.
+// By default, request contexts are derived from context.Background().
+func SubscriberBaseContext[Req, Res any](ctx context.Context) gkit.Option[*Subscriber[Req, Res]] {
+	return func(s *Subscriber[Req, Res]) {
+		s.baseContext = func(jetstream.Msg) context.Context { return ctx }
+	}
+}
+
+// SubscriberContextFunc sets the function creating the context a request
+// context is derived from, for every message.
+func SubscriberContextFunc[Req, Res any](f func(jetstream.Msg) context.Context) gkit.Option[*Subscriber[Req, Res]] {
+	return func(s *Subscriber[Req, Res]) { s.baseContext = f }
+}
+
+// SubscriberTimeout sets how long a message may be handled before its request
+// context is cancelled. Bind defaults it to the AckWait of the consumer, past
+// which the message would be redelivered anyway.
+func SubscriberTimeout[Req, Res any](timeout time.Duration) gkit.Option[*Subscriber[Req, Res]] {
+	return func(s *Subscriber[Req, Res]) { s.timeout = timeout }
+}
+
+// SubscriberDeadlineHeader sets the header carrying the deadline of a message,
+// formatted as RFC 3339. When present, the deadline takes precedence over the
+// subscriber timeout.
+func SubscriberDeadlineHeader[Req, Res any](name string) gkit.Option[*Subscriber[Req, Res]] {
+	return func(s *Subscriber[Req, Res]) { s.deadlineHeader = name }
+}
+
 // ServeMsg provides nats.MsgHandler.
 func (s Subscriber[Req, Res]) HandleMessage(js jetstream.JetStream) func(jetstream.Msg) {
 	return func(msg jetstream.Msg) {
-		ctx, cancel := context.WithCancel(context.Background())
+		s.inflight.add()
+		defer s.inflight.done()
+
+		ctx, cancel := s.newContext(msg)
 		defer cancel()
 
-		var (
-			response Res
-			err      error
-		)
+		ctx, request, err := s.decode(ctx, msg)
+		s.serve(ctx, js, msg, request, err)
+	}
+}
+
+// Drain blocks until the messages being handled are done, or ctx is done. It
+// is meant to be called on shutdown, after the consume context feeding the
+// subscriber is stopped.
+func (s Subscriber[Req, Res]) Drain(ctx context.Context) error {
+	return s.inflight.wait(ctx)
+}
+
+// newContext creates the request context of a message from the base context,
+// bounded by the message deadline.
+func (s Subscriber[Req, Res]) newContext(msg jetstream.Msg) (context.Context, context.CancelFunc) {
+	ctx := context.Background()
+	if s.baseContext != nil {
+		ctx = s.baseContext(msg)
+	}
+
+	if s.deadlineHeader != "" {
+		if deadline, err := time.Parse(time.RFC3339Nano, msg.Headers().Get(s.deadlineHeader)); err == nil {
+			return context.WithDeadline(ctx, deadline)
+		}
+	}
+
+	if s.timeout > 0 {
+		return context.WithTimeout(ctx, s.timeout)
+	}
+
+	return context.WithCancel(ctx)
+}
+
+// decode runs the before functions and decodes the request from the message.
+func (s Subscriber[Req, Res]) decode(ctx context.Context, msg jetstream.Msg) (context.Context, Req, error) {
+	for _, f := range s.before {
+		ctx = f(ctx, msg)
+	}
+
+	ctx = context.WithValue(ctx, contextKeyStart, time.Now())
+
+	request, err := s.dec(ctx, msg)
 
-		defer func() {
-			if msg.Reply() != "" {
-				for _, f := range s.finalizer {
-					f(ctx, msg, err)
-				}
+	return ctx, request, err
+}
+
+// serve invokes the endpoint with the decoded request, encodes the response and
+// acknowledges the message. A non-nil err is the decoding error, in which case
+// the endpoint is not invoked.
+func (s Subscriber[Req, Res]) serve(ctx context.Context, js jetstream.JetStream, msg jetstream.Msg, request Req, err error) {
+	var (
+		response Res
+		claim    *dedupClaim
+		delay    time.Duration
+	)
+
+	stage := StageDecode
+
+	defer func() {
+		disposition := AckDispositionAck
+
+		switch {
+		case errors.Is(err, ErrDeadLettered):
+			disposition = AckDispositionTerm
+		case err != nil:
+			disposition = s.ackPolicy(ctx, err)
+		}
+
+		switch {
+		case claim != nil && claim.skip != "":
+			disposition, delay = claim.skip, claim.delay
+		case claim != nil:
+			if dedupErr := s.dedup.complete(ctx, claim, disposition); dedupErr != nil {
+				s.errorHandler.Handle(ctx, dedupErr)
 			}
+		}
 
-			if err != nil {
-				msg.Nak() //nolint:errcheck
-			} else {
-				msg.Ack() //nolint:errcheck
+		disposition.apply(msg, delay) //nolint:errcheck
+
+		if len(s.finalizer) > 0 {
+			summary := MessageSummary{Stage: stage, Disposition: disposition}
+			if start, ok := ctx.Value(contextKeyStart).(time.Time); ok {
+				summary.Duration = time.Since(start)
 			}
-		}()
 
-		for _, f := range s.before {
-			ctx = f(ctx, msg)
-		}
+			if meta, err := msg.Metadata(); err == nil {
+				summary.NumDelivered = meta.NumDelivered
+			}
 
-		request, err := s.dec(ctx, msg)
-		if err != nil {
-			s.errorHandler.Handle(ctx, err)
-			s.errorEncoder(ctx, js, err)
+			ctx = context.WithValue(ctx, ContextKeyAckDisposition, disposition)
+			ctx = context.WithValue(ctx, ContextKeyMessageSummary, summary)
 
-			return
+			for _, f := range s.finalizer {
+				f(ctx, msg, err)
+			}
 		}
+	}()
+
+	if err != nil {
+		s.errorHandler.Handle(ctx, err)
+		s.errorEncoder(ctx, js, err)
+
+		return
+	}
+
+	if s.dedup != nil {
+		claim, err = s.dedup.claim(ctx, msg)
+		if err != nil || (claim != nil && claim.skip != "") {
+			stage = StageDedup
 
-		response, err = s.e(ctx, request)
-		if err != nil {
-			s.errorHandler.Handle(ctx, err)
-			s.errorEncoder(ctx, js, err)
+			if err != nil {
+				s.errorHandler.Handle(ctx, err)
+				s.errorEncoder(ctx, js, err)
+			}
 
 			return
 		}
+	}
+
+	stage = StageEndpoint
+
+	response, err = s.e(ctx, request)
+	if err != nil {
+		s.errorHandler.Handle(ctx, err)
+		s.errorEncoder(ctx, js, err)
+
+		return
+	}
+
+	for _, f := range s.after {
+		ctx = f(ctx, response, err)
+	}
+
+	stage = StageEncode
+
+	err = s.enc(ctx, js, response)
+	if err != nil {
+		s.errorHandler.Handle(ctx, err)
+		s.errorEncoder(ctx, js, err)
+
+		return
+	}
+
+	stage = StageDone
+}
+
+// Bind starts consuming messages from an existing consumer, e.g. one declared
+// in a ProvisionSpec, and handles them with the subscriber.
+func (s Subscriber[Req, Res]) Bind(
+	ctx context.Context,
+	js jetstream.JetStream,
+	stream, consumer string,
+	opts ...jetstream.PullConsumeOpt,
+) (jetstream.ConsumeContext, error) {
+	c, err := js.Consumer(ctx, stream, consumer)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.timeout == 0 {
+		s.timeout = c.CachedInfo().Config.AckWait
+	}
+
+	return c.Consume(s.HandleMessage(js), opts...)
+}
+
+// AckDisposition tells how a subscriber acknowledged a message.
+type AckDisposition string
 
-		for _, f := range s.after {
-			ctx = f(ctx, response, err)
+const (
+	// AckDispositionAck means the message was processed and acknowledged.
+	AckDispositionAck AckDisposition = "ack"
+
+	// AckDispositionNak means the message was negatively acknowledged and will
+	// be redelivered.
+	AckDispositionNak AckDisposition = "nak"
+
+	// AckDispositionTerm means the message was terminated and won't be
+	// redelivered.
+	AckDispositionTerm AckDisposition = "term"
+)
+
+// ErrDeadLettered is wrapped by the errors of messages moved to a dead letter
+// queue while being handled, e.g. by VerifierDLQ. Subscribers terminate such
+// messages whatever their ack policy, so that they aren't redelivered, and
+// moved again.
+var ErrDeadLettered = errors.New("jstransport: moved to the dead letter queue")
+
+// AckPolicy decides how a message failing with err is acknowledged.
+type AckPolicy func(ctx context.Context, err error) AckDisposition
+
+// DefaultAckPolicy negatively acknowledges every failing message, so that it
+// is redelivered.
+func DefaultAckPolicy(context.Context, error) AckDisposition {
+	return AckDispositionNak
+}
+
+// CombineAckPolicies returns an AckPolicy consulting policies in order, e.g.
+// TermOnVerificationError and TermOnDecryptError. The first disposition other
+// than a negative acknowledgement wins, and failing messages are negatively
+// acknowledged when every policy does so.
+func CombineAckPolicies(policies ...AckPolicy) AckPolicy {
+	return func(ctx context.Context, err error) AckDisposition {
+		for _, policy := range policies {
+			if disposition := policy(ctx, err); disposition != AckDispositionNak {
+				return disposition
+			}
 		}
 
-		err = s.enc(ctx, js, response)
-		if err != nil {
-			s.errorHandler.Handle(ctx, err)
-			s.errorEncoder(ctx, js, err)
+		return AckDispositionNak
+	}
+}
 
-			return
+func (d AckDisposition) apply(msg jetstream.Msg, delay time.Duration) error {
+	switch d {
+	case AckDispositionNak:
+		if delay > 0 {
+			return msg.NakWithDelay(delay)
 		}
+
+		return msg.Nak()
+	case AckDispositionTerm:
+		return msg.Term()
+	default:
+		return msg.Ack()
+	}
+}
+
+// Stage is the processing stage a message reached in a subscriber.
+type Stage string
+
+const (
+	// StageDecode means the message failed to be decoded.
+	StageDecode Stage = "decode"
+
+	// StageDedup means the message was skipped by the deduplicator, having
+	// been or being processed already, or failed to be checked for duplicates.
+	StageDedup Stage = "dedup"
+
+	// StageEndpoint means the endpoint was invoked and returned an error.
+	StageEndpoint Stage = "endpoint"
+
+	// StageEncode means the response failed to be encoded.
+	StageEncode Stage = "encode"
+
+	// StageDone means the message went through every stage successfully.
+	StageDone Stage = "done"
+)
+
+// MessageSummary is the outcome of handling a message, populated in the context
+// of subscriber finalizers under ContextKeyMessageSummary.
+type MessageSummary struct {
+	// Duration is the time spent handling the message, from decoding it up to
+	// acknowledging it.
+	Duration time.Duration
+
+	// Stage is the processing stage the message reached.
+	Stage Stage
+
+	// Disposition is how the message was acknowledged.
+	Disposition AckDisposition
+
+	// NumDelivered is the delivery count of the message, or zero if the message
+	// carries no JetStream metadata.
+	NumDelivered uint64
+}
+
+// MessageSummaryFromContext returns the message summary populated in the
+// context of subscriber finalizers.
+func MessageSummaryFromContext(ctx context.Context) (MessageSummary, bool) {
+	summary, ok := ctx.Value(ContextKeyMessageSummary).(MessageSummary)
+
+	return summary, ok
+}
+
+// inflight tracks the messages being handled.
+type inflight struct {
+	mu   sync.Mutex
+	n    int
+	idle chan struct{}
+}
+
+func (f *inflight) add() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.n == 0 {
+		f.idle = make(chan struct{})
+	}
+
+	f.n++
+}
+
+func (f *inflight) done() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.n--
+	if f.n == 0 {
+		close(f.idle)
+	}
+}
+
+func (f *inflight) wait(ctx context.Context) error {
+	f.mu.Lock()
+	if f.n == 0 {
+		f.mu.Unlock()
+		return nil
+	}
+
+	idle := f.idle
+	f.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
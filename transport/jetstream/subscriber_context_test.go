@@ -0,0 +1,95 @@
+//go:build unit
+
+package jetstream_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	jstransport "github.com/kikihakiem/gkit/transport/jetstream"
+	"github.com/nats-io/nats.go"
+)
+
+func TestSubscriberBaseContextDrain(t *testing.T) {
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+
+	var (
+		started = make(chan struct{})
+		result  = make(chan error, 1)
+	)
+
+	handler := jstransport.NewSubscriber(
+		func(ctx context.Context, _ emptyStruct) (emptyStruct, error) {
+			close(started)
+			<-ctx.Done()
+			time.Sleep(50 * time.Millisecond)
+			result <- ctx.Err()
+
+			return emptyStruct{}, nil
+		},
+		gkit.NopEncoderDecoder,
+		gkit.NopResponseEncoder,
+		jstransport.SubscriberBaseContext[emptyStruct, emptyStruct](baseCtx),
+	)
+
+	msg := &messageMock{subject: "jstransport.test.99", headers: nats.Header{}}
+
+	go handler.HandleMessage(nil)(msg)
+
+	<-started
+	cancelBase()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := handler.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("want %v, have %v", context.Canceled, err)
+		}
+	default:
+		t.Error("want drain to wait for the running handler")
+	}
+}
+
+func TestSubscriberDeadline(t *testing.T) {
+	deadline := time.Now().Add(time.Minute).Truncate(time.Second)
+
+	for _, test := range []struct {
+		name    string
+		headers nats.Header
+		want    time.Duration
+	}{
+		{"timeout", nats.Header{}, time.Hour},
+		{"header", nats.Header{"Deadline": {deadline.Format(time.RFC3339)}}, time.Minute},
+		{"malformed header", nats.Header{"Deadline": {"soon"}}, time.Hour},
+	} {
+		deadlines := make(chan time.Time, 1)
+
+		handler := jstransport.NewSubscriber(
+			func(ctx context.Context, _ emptyStruct) (emptyStruct, error) {
+				d, _ := ctx.Deadline()
+				deadlines <- d
+
+				return emptyStruct{}, nil
+			},
+			gkit.NopEncoderDecoder,
+			gkit.NopResponseEncoder,
+			jstransport.SubscriberTimeout[emptyStruct, emptyStruct](time.Hour),
+			jstransport.SubscriberDeadlineHeader[emptyStruct, emptyStruct]("Deadline"),
+		)
+
+		handler.HandleMessage(nil)(&messageMock{subject: "jstransport.test.99", headers: test.headers})
+
+		if have := time.Until(<-deadlines); have > test.want || have < test.want-5*time.Second {
+			t.Errorf("%s: want deadline in %v, have %v", test.name, test.want, have)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package jetstream
+
+import (
+	"context"
+	"encoding/json"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Watcher wraps an endpoint invoked with the changes of the keys of a
+// JetStream Key-Value bucket, e.g. holding configuration or feature toggles.
+type Watcher[Req, Res any] struct {
+	e            gkit.Endpoint[Req, Res]
+	dec          gkit.EncodeDecodeFunc[jetstream.KeyValueEntry, Req]
+	before       []gkit.BeforeRequestFunc[jetstream.KeyValueEntry]
+	after        []gkit.AfterResponseFunc[Res]
+	finalizer    []gkit.FinalizerFunc[jetstream.KeyValueEntry]
+	errorHandler gkit.ErrorHandler
+
+	keys          []string
+	watchOpts     []jetstream.WatchOpt
+	updatesOnly   bool
+	onInitialized func()
+}
+
+// NewWatcher constructs a new watcher invoking the endpoint with the requests
+// decoded from the entries of a bucket. By default, every key is watched,
+// starting with their current values, and deleted or purged keys are passed to
+// the decoder as entries with the corresponding operation.
+func NewWatcher[Req, Res any](
+	e gkit.Endpoint[Req, Res],
+	dec gkit.EncodeDecodeFunc[jetstream.KeyValueEntry, Req],
+	options ...gkit.Option[*Watcher[Req, Res]],
+) *Watcher[Req, Res] {
+	w := &Watcher[Req, Res]{
+		e:            e,
+		dec:          dec,
+		errorHandler: gkit.LogErrorHandler(nil),
+		keys:         []string{jetstream.AllKeys},
+	}
+
+	for _, option := range options {
+		option(w)
+	}
+
+	return w
+}
+
+// WatcherBefore functions are executed on the entry before the request is
+// decoded.
+func WatcherBefore[Req, Res any](before ...gkit.BeforeRequestFunc[jetstream.KeyValueEntry]) gkit.Option[*Watcher[Req, Res]] {
+	return func(w *Watcher[Req, Res]) { w.before = append(w.before, before...) }
+}
+
+// WatcherAfter functions are executed on the endpoint response.
+func WatcherAfter[Req, Res any](after ...gkit.AfterResponseFunc[Res]) gkit.Option[*Watcher[Req, Res]] {
+	return func(w *Watcher[Req, Res]) { w.after = append(w.after, after...) }
+}
+
+// WatcherFinalizer is executed at the end of every entry, with the error
+// handling it failed with, if any. By default, no finalizer is registered.
+func WatcherFinalizer[Req, Res any](finalizer ...gkit.FinalizerFunc[jetstream.KeyValueEntry]) gkit.Option[*Watcher[Req, Res]] {
+	return func(w *Watcher[Req, Res]) { w.finalizer = append(w.finalizer, finalizer...) }
+}
+
+// WatcherErrorHandler is used to handle the errors decoding entries or
+// returned by the endpoint. By default, they are logged.
+func WatcherErrorHandler[Req, Res any](errorHandler gkit.ErrorHandler) gkit.Option[*Watcher[Req, Res]] {
+	return func(w *Watcher[Req, Res]) { w.errorHandler = errorHandler }
+}
+
+// WatcherKeys sets the keys to watch. Keys may contain the * and > wildcards,
+// e.g. "toggles.>".
+func WatcherKeys[Req, Res any](keys ...string) gkit.Option[*Watcher[Req, Res]] {
+	return func(w *Watcher[Req, Res]) { w.keys = keys }
+}
+
+// WatcherIgnoreDeletes skips the keys that are deleted or purged.
+func WatcherIgnoreDeletes[Req, Res any]() gkit.Option[*Watcher[Req, Res]] {
+	return func(w *Watcher[Req, Res]) { w.watchOpts = append(w.watchOpts, jetstream.IgnoreDeletes()) }
+}
+
+// WatcherUpdatesOnly skips the current values of the keys, only passing the
+// changes made once watching.
+func WatcherUpdatesOnly[Req, Res any]() gkit.Option[*Watcher[Req, Res]] {
+	return func(w *Watcher[Req, Res]) {
+		w.watchOpts = append(w.watchOpts, jetstream.UpdatesOnly())
+		w.updatesOnly = true
+	}
+}
+
+// WatcherIncludeHistory replays every revision of the keys kept by the bucket
+// before passing the changes, rather than only their current values.
+func WatcherIncludeHistory[Req, Res any]() gkit.Option[*Watcher[Req, Res]] {
+	return func(w *Watcher[Req, Res]) { w.watchOpts = append(w.watchOpts, jetstream.IncludeHistory()) }
+}
+
+// WatcherResumeFromRevision replays every revision of the keys from the given
+// revision onwards, e.g. the revision following the last one handled before a
+// restart, as populated by PopulateEntryContext.
+func WatcherResumeFromRevision[Req, Res any](revision uint64) gkit.Option[*Watcher[Req, Res]] {
+	return func(w *Watcher[Req, Res]) { w.watchOpts = append(w.watchOpts, jetstream.ResumeFromRevision(revision)) }
+}
+
+// WatcherOnInitialized sets a function called once the initial values of the
+// keys have been handled, e.g. to report readiness. It is called right away
+// with WatcherUpdatesOnly.
+func WatcherOnInitialized[Req, Res any](f func()) gkit.Option[*Watcher[Req, Res]] {
+	return func(w *Watcher[Req, Res]) { w.onInitialized = f }
+}
+
+// Watch watches the keys of the bucket and handles their entries one at a
+// time, until ctx is done.
+func (w Watcher[Req, Res]) Watch(ctx context.Context, kv jetstream.KeyValue) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	entries := make(chan jetstream.KeyValueEntry)
+
+	for _, key := range w.keys {
+		watcher, err := kv.Watch(ctx, key, w.watchOpts...)
+		if err != nil {
+			return err
+		}
+		defer watcher.Stop() //nolint:errcheck
+
+		go func() {
+			for entry := range watcher.Updates() {
+				select {
+				case entries <- entry:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	// a nil entry marks the end of the initial values of a key pattern, which
+	// isn't sent when only watching updates.
+	pending := len(w.keys)
+	if w.updatesOnly && w.onInitialized != nil {
+		w.onInitialized()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case entry := <-entries:
+			if entry != nil {
+				w.HandleEntry(ctx, entry)
+				continue
+			}
+
+			if pending--; pending == 0 && w.onInitialized != nil {
+				w.onInitialized()
+			}
+		}
+	}
+}
+
+// HandleEntry invokes the endpoint with the request decoded from entry.
+func (w Watcher[Req, Res]) HandleEntry(ctx context.Context, entry jetstream.KeyValueEntry) {
+	var err error
+
+	if len(w.finalizer) > 0 {
+		defer func() {
+			for _, f := range w.finalizer {
+				f(ctx, entry, err)
+			}
+		}()
+	}
+
+	for _, f := range w.before {
+		ctx = f(ctx, entry)
+	}
+
+	request, err := w.dec(ctx, entry)
+	if err != nil {
+		w.errorHandler.Handle(ctx, err)
+		return
+	}
+
+	response, err := w.e(ctx, request)
+	if err != nil {
+		w.errorHandler.Handle(ctx, err)
+		return
+	}
+
+	for _, f := range w.after {
+		ctx = f(ctx, response, nil)
+	}
+}
+
+// PopulateEntryContext is a BeforeRequestFunc that populates the key, revision,
+// operation and creation time of the entry into the context.
+func PopulateEntryContext(ctx context.Context, entry jetstream.KeyValueEntry) context.Context {
+	for k, v := range map[contextKey]any{
+		ContextKeyEntryKey:       entry.Key(),
+		ContextKeyEntryRevision:  entry.Revision(),
+		ContextKeyEntryOperation: entry.Operation(),
+		ContextKeyEntryCreated:   entry.Created(),
+	} {
+		ctx = context.WithValue(ctx, k, v)
+	}
+
+	return ctx
+}
+
+// DecodeJSONEntry is a DecodeRequestFunc that deserializes the JSON value of
+// the entry. Entries of deleted or purged keys are decoded as the zero value.
+func DecodeJSONEntry[Req any](_ context.Context, entry jetstream.KeyValueEntry) (Req, error) {
+	var req Req
+
+	if entry.Operation() != jetstream.KeyValuePut {
+		return req, nil
+	}
+
+	err := json.Unmarshal(entry.Value(), &req)
+	if err != nil {
+		return req, err
+	}
+
+	return req, nil
+}
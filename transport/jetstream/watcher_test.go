@@ -0,0 +1,181 @@
+//go:build unit
+
+package jetstream_test
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	jstransport "github.com/kikihakiem/gkit/transport/jetstream"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type toggle struct {
+	Enabled bool `json:"enabled"`
+}
+
+func newWatchKV(ctx context.Context, t *testing.T) (jetstream.KeyValue, func()) {
+	t.Helper()
+
+	js, _, stop := newJetstream(ctx, t)
+
+	bucket := "watch_" + strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	kv, err := js.CreateKeyValue(ctx, jetstream.KeyValueConfig{Bucket: bucket, History: 5})
+	if err != nil {
+		stop()
+		t.Fatal(err)
+	}
+
+	return kv, stop
+}
+
+func recordingWatcher(changes chan<- string, options ...gkit.Option[*jstransport.Watcher[toggle, struct{}]]) *jstransport.Watcher[toggle, struct{}] {
+	return jstransport.NewWatcher(
+		func(ctx context.Context, req toggle) (struct{}, error) {
+			key, _ := ctx.Value(jstransport.ContextKeyEntryKey).(string)
+			op, _ := ctx.Value(jstransport.ContextKeyEntryOperation).(jetstream.KeyValueOp)
+			changes <- fmt.Sprintf("%s %s %t", op, key, req.Enabled)
+
+			return struct{}{}, nil
+		},
+		jstransport.DecodeJSONEntry[toggle],
+		append(options, jstransport.WatcherBefore[toggle, struct{}](jstransport.PopulateEntryContext))...,
+	)
+}
+
+func nextChange(t *testing.T, changes <-chan string) string {
+	t.Helper()
+
+	select {
+	case change := <-changes:
+		return change
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a change")
+		return ""
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	kv, stop := newWatchKV(ctx, t)
+	defer stop()
+
+	for key, value := range map[string]string{"toggles.a": `{"enabled":true}`, "other.b": `{"enabled":true}`} {
+		if _, err := kv.PutString(ctx, key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var (
+		changes     = make(chan string, 10)
+		initialized = make(chan struct{})
+		failures    = make(chan error, 1)
+	)
+
+	watcher := recordingWatcher(changes,
+		jstransport.WatcherKeys[toggle, struct{}]("toggles.>"),
+		jstransport.WatcherOnInitialized[toggle, struct{}](func() { close(initialized) }),
+		jstransport.WatcherErrorHandler[toggle, struct{}](gkit.ErrorHandlerFunc(func(context.Context, error) {})),
+		jstransport.WatcherFinalizer[toggle, struct{}](func(_ context.Context, _ jetstream.KeyValueEntry, err error) {
+			if err != nil {
+				failures <- err
+			}
+		}),
+	)
+
+	watchCtx, stopWatching := context.WithCancel(ctx)
+	done := make(chan error, 1)
+
+	go func() { done <- watcher.Watch(watchCtx, kv) }()
+
+	if want, have := "KeyValuePutOp toggles.a true", nextChange(t, changes); want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+
+	select {
+	case <-initialized:
+	case <-time.After(5 * time.Second):
+		t.Fatal("want initialized")
+	}
+
+	if _, err := kv.PutString(ctx, "toggles.a", `{"enabled":false}`); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := kv.PutString(ctx, "toggles.c", `not json`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := kv.Delete(ctx, "toggles.a"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"KeyValuePutOp toggles.a false", "KeyValueDeleteOp toggles.a false"} {
+		if have := nextChange(t, changes); want != have {
+			t.Errorf("want %q, have %q", want, have)
+		}
+	}
+
+	select {
+	case <-failures:
+	case <-time.After(5 * time.Second):
+		t.Error("want the invalid entry to be finalized with an error")
+	}
+
+	stopWatching()
+
+	if err := <-done; err != context.Canceled {
+		t.Errorf("want %v, have %v", context.Canceled, err)
+	}
+}
+
+func TestWatcherResumeFromRevision(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	kv, stop := newWatchKV(ctx, t)
+	defer stop()
+
+	var revisions []uint64
+
+	for _, value := range []string{`{"enabled":true}`, `{"enabled":false}`, `{"enabled":true}`} {
+		revision, err := kv.PutString(ctx, "toggles.a", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		revisions = append(revisions, revision)
+	}
+
+	changes := make(chan string, 10)
+	initialized := make(chan struct{})
+
+	watcher := recordingWatcher(changes,
+		jstransport.WatcherResumeFromRevision[toggle, struct{}](revisions[1]),
+		jstransport.WatcherOnInitialized[toggle, struct{}](func() { close(initialized) }),
+	)
+
+	watchCtx, stopWatching := context.WithCancel(ctx)
+	defer stopWatching()
+
+	go watcher.Watch(watchCtx, kv) //nolint:errcheck
+
+	for _, want := range []string{"KeyValuePutOp toggles.a false", "KeyValuePutOp toggles.a true"} {
+		if have := nextChange(t, changes); want != have {
+			t.Errorf("want %q, have %q", want, have)
+		}
+	}
+
+	select {
+	case <-initialized:
+	case <-time.After(5 * time.Second):
+		t.Fatal("want initialized")
+	}
+}
@@ -0,0 +1,159 @@
+package jetstream
+
+import (
+	"context"
+	"hash/fnv"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// KeyFunc extracts the ordering key of a message. Messages sharing a key are
+// processed one at a time, in the order they were delivered.
+type KeyFunc[Req any] func(ctx context.Context, msg jetstream.Msg, request Req) string
+
+// KeyFromSubjectToken returns a KeyFunc that uses the subject token at the given
+// position as the key. A negative index counts from the last token, e.g. -1 is
+// the last token of the subject.
+func KeyFromSubjectToken[Req any](index int) KeyFunc[Req] {
+	return func(_ context.Context, msg jetstream.Msg, _ Req) string {
+		tokens := strings.Split(msg.Subject(), ".")
+
+		i := index
+		if i < 0 {
+			i += len(tokens)
+		}
+
+		if i < 0 || i >= len(tokens) {
+			return ""
+		}
+
+		return tokens[i]
+	}
+}
+
+// KeyFromHeader returns a KeyFunc that uses the value of the named header as the key.
+func KeyFromHeader[Req any](name string) KeyFunc[Req] {
+	return func(_ context.Context, msg jetstream.Msg, _ Req) string {
+		return msg.Headers().Get(name)
+	}
+}
+
+// KeyFromRequest returns a KeyFunc that takes the key from the decoded request.
+func KeyFromRequest[Req any](key func(Req) string) KeyFunc[Req] {
+	return func(_ context.Context, _ jetstream.Msg, request Req) string {
+		return key(request)
+	}
+}
+
+type job[Req any] struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	msg     jetstream.Msg
+	request Req
+}
+
+// WorkerPool handles messages with a fixed number of workers. Messages are
+// sharded by key, so messages sharing a key are processed in order by the same
+// worker while messages with different keys are processed in parallel. Every
+// message is acknowledged individually once processed.
+type WorkerPool[Req, Res any] struct {
+	s      Subscriber[Req, Res]
+	js     jetstream.JetStream
+	key    KeyFunc[Req]
+	queues []chan job[Req]
+	wg     sync.WaitGroup
+
+	mu      sync.RWMutex
+	stopped bool
+}
+
+// WorkerPool starts a pool of workers handling messages with the subscriber.
+// The consumer's MaxAckPending should be at least the number of workers for all
+// of them to be kept busy. Stop must be called to release the workers.
+func (s Subscriber[Req, Res]) WorkerPool(js jetstream.JetStream, workers int, key KeyFunc[Req]) *WorkerPool[Req, Res] {
+	if workers < 1 {
+		workers = 1
+	}
+
+	p := &WorkerPool[Req, Res]{
+		s:      s,
+		js:     js,
+		key:    key,
+		queues: make([]chan job[Req], workers),
+	}
+
+	for i := range p.queues {
+		p.queues[i] = make(chan job[Req], 1)
+
+		p.wg.Add(1)
+
+		go p.work(p.queues[i])
+	}
+
+	return p
+}
+
+// HandleMessage provides jetstream.MessageHandler. It decodes the message and
+// queues it to the worker owning its key, blocking while that worker is busy.
+// Messages received after Stop are negatively acknowledged.
+func (p *WorkerPool[Req, Res]) HandleMessage(msg jetstream.Msg) {
+	p.s.inflight.add()
+
+	ctx, cancel := p.s.newContext(msg)
+
+	ctx, request, err := p.s.decode(ctx, msg)
+	if err != nil {
+		p.s.serve(ctx, p.js, msg, request, err)
+		cancel()
+		p.s.inflight.done()
+
+		return
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.stopped {
+		msg.Nak() //nolint:errcheck
+		cancel()
+		p.s.inflight.done()
+
+		return
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(p.key(ctx, msg, request)))
+
+	p.queues[h.Sum32()%uint32(len(p.queues))] <- job[Req]{ctx: ctx, cancel: cancel, msg: msg, request: request}
+}
+
+func (p *WorkerPool[Req, Res]) work(queue <-chan job[Req]) {
+	defer p.wg.Done()
+
+	for j := range queue {
+		p.s.serve(j.ctx, p.js, j.msg, j.request, nil)
+		j.cancel()
+		p.s.inflight.done()
+	}
+}
+
+// Stop waits for the queued messages to be processed and stops the workers.
+// The consume context feeding the pool should be stopped first.
+func (p *WorkerPool[Req, Res]) Stop() {
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		return
+	}
+
+	p.stopped = true
+
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
@@ -0,0 +1,145 @@
+//go:build unit
+
+package jetstream_test
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	jstransport "github.com/kikihakiem/gkit/transport/jetstream"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestWorkerPool(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	js, stream, stop := newJetstream(ctx, t)
+	defer stop()
+
+	if err := stream.Purge(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	consumer, err := stream.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu          sync.Mutex
+		processed   = map[string][]string{}
+		running     atomic.Int32
+		maxRunning  atomic.Int32
+		wg          sync.WaitGroup
+		keys        = []string{"a", "b", "c", "d"}
+		perKey      = 5
+		subjectOf   = func(key string) string { return "jstransport.entity." + key }
+		payloadsFor = func(key string) []string {
+			payloads := make([]string, 0, perKey)
+			for i := 0; i < perKey; i++ {
+				payloads = append(payloads, key+string(rune('0'+i)))
+			}
+
+			return payloads
+		}
+	)
+
+	wg.Add(len(keys) * perKey)
+
+	subscriber := jstransport.NewSubscriber(
+		func(_ context.Context, req string) (struct{}, error) {
+			defer wg.Done()
+
+			if n := running.Add(1); n > maxRunning.Load() {
+				maxRunning.Store(n)
+			}
+			defer running.Add(-1)
+
+			time.Sleep(10 * time.Millisecond)
+
+			mu.Lock()
+			processed[req[:1]] = append(processed[req[:1]], req)
+			mu.Unlock()
+
+			return struct{}{}, nil
+		},
+		func(_ context.Context, msg jetstream.Msg) (string, error) {
+			return string(msg.Data()), nil
+		},
+		gkit.NopResponseEncoder,
+	)
+
+	pool := subscriber.WorkerPool(js, len(keys), jstransport.KeyFromSubjectToken[string](-1))
+
+	consumeCtx, err := consumer.Consume(pool.HandleMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < perKey; i++ {
+		for _, key := range keys {
+			if _, err := js.Publish(ctx, subjectOf(key), []byte(payloadsFor(key)[i])); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	wg.Wait()
+	consumeCtx.Stop()
+	pool.Stop()
+
+	for _, key := range keys {
+		if want, have := payloadsFor(key), processed[key]; !reflect.DeepEqual(want, have) {
+			t.Errorf("key %s: want %v, have %v", key, want, have)
+		}
+	}
+
+	if maxRunning.Load() < 2 {
+		t.Errorf("want messages with different keys processed in parallel, have at most %d at once", maxRunning.Load())
+	}
+}
+
+func TestKeyFuncs(t *testing.T) {
+	msg := &messageMock{subject: "events.user.42", headers: map[string][]string{"Entity-Id": {"7"}}}
+
+	for _, test := range []struct {
+		name string
+		key  jstransport.KeyFunc[string]
+		want string
+	}{
+		{"first token", jstransport.KeyFromSubjectToken[string](0), "events"},
+		{"last token", jstransport.KeyFromSubjectToken[string](-1), "42"},
+		{"out of range", jstransport.KeyFromSubjectToken[string](5), ""},
+		{"header", jstransport.KeyFromHeader[string]("Entity-Id"), "7"},
+		{"request", jstransport.KeyFromRequest(strings.ToUpper), "FOO"},
+	} {
+		if have := test.key(context.Background(), msg, "foo"); test.want != have {
+			t.Errorf("%s: want %q, have %q", test.name, test.want, have)
+		}
+	}
+}
+
+func TestKeyFromSubjectTokenVaryingLength(t *testing.T) {
+	key := jstransport.KeyFromSubjectToken[string](-1)
+
+	for _, test := range []struct {
+		subject string
+		want    string
+	}{
+		{"events.user.42", "42"},
+		{"events.7", "7"},
+		{"events.user.profile.9", "9"},
+		{"events.user.42", "42"},
+	} {
+		if have := key(context.Background(), &messageMock{subject: test.subject}, ""); test.want != have {
+			t.Errorf("%s: want %q, have %q", test.subject, test.want, have)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	"github.com/nats-io/nats.go"
+)
+
+// Client wraps a subject and provides a method that implements endpoint.Endpoint.
+type Client[Req, Res any] struct {
+	nc        *nats.Conn
+	subject   string
+	enc       gkit.EncodeDecodeFunc[Req, *nats.Msg]
+	dec       gkit.EncodeDecodeFunc[*nats.Msg, Res]
+	before    []gkit.BeforeRequestFunc[*nats.Msg]
+	after     []gkit.AfterResponseFunc[*nats.Msg]
+	finalizer []gkit.FinalizerFunc[Req]
+	timeout   time.Duration
+}
+
+// NewClient constructs a usable Client for a single remote method.
+func NewClient[Req, Res any](
+	nc *nats.Conn,
+	subject string,
+	enc gkit.EncodeDecodeFunc[Req, *nats.Msg],
+	dec gkit.EncodeDecodeFunc[*nats.Msg, Res],
+	options ...ClientOption[Req, Res],
+) *Client[Req, Res] {
+	c := &Client[Req, Res]{
+		nc:      nc,
+		subject: subject,
+		enc:     enc,
+		dec:     dec,
+		timeout: 10 * time.Second,
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	return c
+}
+
+// ClientOption sets an optional parameter for clients.
+type ClientOption[Req, Res any] gkit.Option[*Client[Req, Res]]
+
+// ClientBefore sets the functions that are applied to the outgoing NATS
+// request before it's invoked.
+func ClientBefore[Req, Res any](before ...gkit.BeforeRequestFunc[*nats.Msg]) ClientOption[Req, Res] {
+	return func(c *Client[Req, Res]) { c.before = append(c.before, before...) }
+}
+
+// ClientAfter sets the functions applied to the incoming NATS reply prior to
+// it being decoded. This is useful for obtaining anything off of the reply and
+// adding it onto the context prior to decoding.
+func ClientAfter[Req, Res any](after ...gkit.AfterResponseFunc[*nats.Msg]) ClientOption[Req, Res] {
+	return func(c *Client[Req, Res]) { c.after = append(c.after, after...) }
+}
+
+// ClientFinalizer is executed at the end of every request.
+// By default, no finalizer is registered.
+func ClientFinalizer[Req, Res any](finalizerFunc ...gkit.FinalizerFunc[Req]) ClientOption[Req, Res] {
+	return func(c *Client[Req, Res]) { c.finalizer = append(c.finalizer, finalizerFunc...) }
+}
+
+// ClientTimeout sets the available timeout for NATS request.
+func ClientTimeout[Req, Res any](timeout time.Duration) ClientOption[Req, Res] {
+	return func(c *Client[Req, Res]) { c.timeout = timeout }
+}
+
+// Endpoint returns a usable endpoint that invokes the remote endpoint. When
+// nobody listens on the subject, the returned error wraps nats.ErrNoResponders.
+func (c Client[Req, Res]) Endpoint() gkit.Endpoint[Req, Res] {
+	return func(ctx context.Context, request Req) (Res, error) {
+		ctx, cancel := context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+
+		var (
+			response Res
+			err      error
+		)
+
+		if len(c.finalizer) > 0 {
+			defer func() {
+				for _, f := range c.finalizer {
+					f(ctx, request, err)
+				}
+			}()
+		}
+
+		msg, err := c.enc(ctx, request)
+		if err != nil {
+			return response, err
+		}
+
+		msg.Subject = c.subject
+
+		for _, f := range c.before {
+			ctx = f(ctx, msg)
+		}
+
+		reply, err := c.nc.RequestMsgWithContext(ctx, msg)
+		if errors.Is(err, nats.ErrNoResponders) {
+			err = fmt.Errorf("%w on subject %s", err, c.subject)
+		}
+
+		if err != nil {
+			return response, err
+		}
+
+		for _, f := range c.after {
+			ctx = f(ctx, reply, err)
+		}
+
+		response, err = c.dec(ctx, reply)
+		if err != nil {
+			return response, err
+		}
+
+		return response, nil
+	}
+}
+
+// EncodeJSONRequest is an EncodeRequestFunc that serializes the request as a
+// JSON object to the Data of the Msg. Many JSON-over-NATS services can use it as
+// a sensible default.
+func EncodeJSONRequest[Req any](_ context.Context, request Req) (*nats.Msg, error) {
+	b, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := nats.NewMsg("")
+	msg.Data = b
+
+	return msg, nil
+}
+
+// DecodeJSONResponse is a DecodeResponseFunc that deserialize JSON reply to
+// domain object. A reply published by EncodeJSONError is returned as an error.
+func DecodeJSONResponse[Res any](_ context.Context, msg *nats.Msg) (Res, error) {
+	var (
+		res    Res
+		errRes ErrResponse
+	)
+
+	if json.Unmarshal(msg.Data, &errRes) == nil && errRes.Error != "" {
+		return res, errors.New(errRes.Error)
+	}
+
+	err := json.Unmarshal(msg.Data, &res)
+	if err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
@@ -0,0 +1,131 @@
+//go:build unit
+
+package nats_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	natstransport "github.com/kikihakiem/gkit/transport/nats"
+	"github.com/nats-io/nats.go"
+)
+
+func TestClient(t *testing.T) {
+	nc, stop := newNATSConn(t)
+	defer stop()
+
+	server := natstransport.NewServer(
+		func(_ context.Context, req string) (string, error) { return req + " bar", nil },
+		natstransport.DecodeJSONRequest[string],
+		natstransport.EncodeJSONResponse[string],
+	)
+
+	if _, err := server.Subscribe(nc, "natstransport.test"); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		beforeSubject string
+		finalizerErr  = make(chan error, 1)
+	)
+
+	client := natstransport.NewClient(
+		nc,
+		"natstransport.test",
+		natstransport.EncodeJSONRequest[string],
+		natstransport.DecodeJSONResponse[string],
+		natstransport.ClientBefore[string, string](func(ctx context.Context, msg *nats.Msg) context.Context {
+			beforeSubject = msg.Subject
+			return ctx
+		}),
+		natstransport.ClientFinalizer[string, string](func(_ context.Context, _ string, err error) {
+			finalizerErr <- err
+		}),
+	)
+
+	res, err := client.Endpoint()(context.Background(), "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := "foo bar", res; want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+
+	if want, have := "natstransport.test", beforeSubject; want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+
+	if err := <-finalizerErr; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestClientRemoteError(t *testing.T) {
+	nc, stop := newNATSConn(t)
+	defer stop()
+
+	server := natstransport.NewServer(
+		func(context.Context, string) (string, error) { return "", errors.New("dang") },
+		natstransport.DecodeJSONRequest[string],
+		natstransport.EncodeJSONResponse[string],
+	)
+
+	if _, err := server.Subscribe(nc, "natstransport.test"); err != nil {
+		t.Fatal(err)
+	}
+
+	client := natstransport.NewClient(
+		nc,
+		"natstransport.test",
+		natstransport.EncodeJSONRequest[string],
+		natstransport.DecodeJSONResponse[string],
+	)
+
+	_, err := client.Endpoint()(context.Background(), "foo")
+	if want, have := "dang", err; have == nil || want != have.Error() {
+		t.Errorf("want %s, have %v", want, have)
+	}
+}
+
+func TestClientNoResponders(t *testing.T) {
+	nc, stop := newNATSConn(t)
+	defer stop()
+
+	client := natstransport.NewClient(
+		nc,
+		"natstransport.nobody",
+		natstransport.EncodeJSONRequest[emptyStruct],
+		gkit.NopEncoderDecoder[*nats.Msg, emptyStruct],
+	)
+
+	_, err := client.Endpoint()(context.Background(), emptyStruct{})
+	if !errors.Is(err, nats.ErrNoResponders) {
+		t.Errorf("want %s, have %v", nats.ErrNoResponders, err)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	nc, stop := newNATSConn(t)
+	defer stop()
+
+	if _, err := nc.Subscribe("natstransport.slow", func(*nats.Msg) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	client := natstransport.NewClient(
+		nc,
+		"natstransport.slow",
+		natstransport.EncodeJSONRequest[emptyStruct],
+		gkit.NopEncoderDecoder[*nats.Msg, emptyStruct],
+		natstransport.ClientTimeout[emptyStruct, emptyStruct](10*time.Millisecond),
+	)
+
+	_, err := client.Endpoint()(context.Background(), emptyStruct{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want %s, have %v", context.DeadlineExceeded, err)
+	}
+}
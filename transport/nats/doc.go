@@ -0,0 +1,3 @@
+// Package nats provides a NATS request-reply transport over core NATS, without
+// JetStream persistence.
+package nats
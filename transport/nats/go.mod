@@ -0,0 +1,20 @@
+module github.com/kikihakiem/gkit/transport/nats
+
+go 1.21.6
+
+require (
+	github.com/kikihakiem/gkit/core v0.4.0
+	github.com/nats-io/nats-server/v2 v2.10.10
+	github.com/nats-io/nats.go v1.32.0
+)
+
+require (
+	github.com/klauspost/compress v1.17.5 // indirect
+	github.com/minio/highwayhash v1.0.2 // indirect
+	github.com/nats-io/jwt/v2 v2.5.3 // indirect
+	github.com/nats-io/nkeys v0.4.7 // indirect
+	github.com/nats-io/nuid v1.0.1 // indirect
+	golang.org/x/crypto v0.18.0 // indirect
+	golang.org/x/sys v0.16.0 // indirect
+	golang.org/x/time v0.5.0 // indirect
+)
@@ -0,0 +1,296 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// MicroHandler wraps an endpoint and implements micro.Handler, so that it can
+// be registered into a micro.Service or micro.Group with AddEndpoint.
+type MicroHandler[Req, Res any] struct {
+	e            gkit.Endpoint[Req, Res]
+	dec          gkit.EncodeDecodeFunc[micro.Request, Req]
+	enc          gkit.ResponseEncoder[micro.Request, Res]
+	before       []gkit.BeforeRequestFunc[micro.Request]
+	after        []gkit.AfterResponseFunc[Res]
+	errorEncoder gkit.ErrorEncoder[micro.Request]
+	finalizer    []gkit.FinalizerFunc[micro.Request]
+	errorHandler gkit.ErrorHandler
+	stats        *MicroStats
+	statsName    string
+}
+
+// NewMicroHandler constructs a new micro handler wrapping the provided endpoint.
+func NewMicroHandler[Req, Res any](
+	e gkit.Endpoint[Req, Res],
+	dec gkit.EncodeDecodeFunc[micro.Request, Req],
+	enc gkit.ResponseEncoder[micro.Request, Res],
+	options ...MicroHandlerOption[Req, Res],
+) *MicroHandler[Req, Res] {
+	h := &MicroHandler[Req, Res]{
+		e:            e,
+		dec:          dec,
+		enc:          enc,
+		errorEncoder: EncodeMicroError,
+		errorHandler: gkit.LogErrorHandler(nil),
+	}
+
+	for _, option := range options {
+		option(h)
+	}
+
+	return h
+}
+
+// MicroHandlerOption sets an optional parameter for micro handlers.
+type MicroHandlerOption[Req, Res any] gkit.Option[*MicroHandler[Req, Res]]
+
+// MicroHandlerBefore functions are executed on the micro request before the
+// request is decoded.
+func MicroHandlerBefore[Req, Res any](before ...gkit.BeforeRequestFunc[micro.Request]) MicroHandlerOption[Req, Res] {
+	return func(h *MicroHandler[Req, Res]) { h.before = append(h.before, before...) }
+}
+
+// MicroHandlerAfter functions are executed on the response after the endpoint
+// is invoked, but before it is encoded.
+func MicroHandlerAfter[Req, Res any](after ...gkit.AfterResponseFunc[Res]) MicroHandlerOption[Req, Res] {
+	return func(h *MicroHandler[Req, Res]) { h.after = append(h.after, after...) }
+}
+
+// MicroHandlerErrorEncoder is used to encode errors to the micro request. By
+// default, errors are encoded with EncodeMicroError.
+func MicroHandlerErrorEncoder[Req, Res any](encoder gkit.ErrorEncoder[micro.Request]) MicroHandlerOption[Req, Res] {
+	return func(h *MicroHandler[Req, Res]) { h.errorEncoder = encoder }
+}
+
+// MicroHandlerErrorHandler is used to handle non-terminal errors. By default,
+// non-terminal errors are logged.
+func MicroHandlerErrorHandler[Req, Res any](errorHandler gkit.ErrorHandler) MicroHandlerOption[Req, Res] {
+	return func(h *MicroHandler[Req, Res]) { h.errorHandler = errorHandler }
+}
+
+// MicroHandlerFinalizer is executed at the end of every request.
+// By default, no finalizer is registered.
+func MicroHandlerFinalizer[Req, Res any](finalizerFunc ...gkit.FinalizerFunc[micro.Request]) MicroHandlerOption[Req, Res] {
+	return func(h *MicroHandler[Req, Res]) { h.finalizer = append(h.finalizer, finalizerFunc...) }
+}
+
+// MicroHandlerStats records the outcome of every request of the handler into
+// stats under the given endpoint name, which should be the name the handler is
+// registered with. The outcome is recorded before the response is sent, so
+// that it is reported to whoever got the response. To do so, the response and
+// error encoders are passed a micro.Request wrapping the original one, while
+// the before functions, the decoder and the finalizers get the original.
+func MicroHandlerStats[Req, Res any](stats *MicroStats, name string) MicroHandlerOption[Req, Res] {
+	return func(h *MicroHandler[Req, Res]) {
+		h.stats = stats
+		h.statsName = name
+	}
+}
+
+// Handle implements micro.Handler.
+func (h MicroHandler[Req, Res]) Handle(req micro.Request) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var err error
+
+	// resp is the request the response is sent through.
+	resp := req
+
+	if h.stats != nil {
+		call := &microCall{stats: h.stats, name: h.statsName, start: time.Now()}
+		resp = microStatsRequest{Request: req, call: call}
+
+		// requests that are never responded to are recorded once handled.
+		defer func() { call.record(err) }()
+	}
+
+	if len(h.finalizer) > 0 {
+		defer func() {
+			for _, f := range h.finalizer {
+				f(ctx, req, err)
+			}
+		}()
+	}
+
+	for _, f := range h.before {
+		ctx = f(ctx, req)
+	}
+
+	request, err := h.dec(ctx, req)
+	if err != nil {
+		h.errorHandler.Handle(ctx, err)
+		h.errorEncoder(ctx, resp, err)
+
+		return
+	}
+
+	response, err := h.e(ctx, request)
+	if err != nil {
+		h.errorHandler.Handle(ctx, err)
+		h.errorEncoder(ctx, resp, err)
+
+		return
+	}
+
+	for _, f := range h.after {
+		ctx = f(ctx, response, err)
+	}
+
+	err = h.enc(ctx, resp, response)
+	if err != nil {
+		h.errorHandler.Handle(ctx, err)
+		h.errorEncoder(ctx, resp, err)
+
+		return
+	}
+}
+
+// ErrorCoder is checked by EncodeMicroError. If an error value implements
+// ErrorCoder, the ErrorCode will be used as the Nats-Service-Error-Code header.
+// By default, "500" is used.
+type ErrorCoder interface {
+	ErrorCode() int
+}
+
+// DecodeMicroJSONRequest is a DecodeRequestFunc that deserialize the JSON data
+// of a micro request to domain object.
+func DecodeMicroJSONRequest[Req any](_ context.Context, req micro.Request) (Req, error) {
+	var request Req
+
+	err := json.Unmarshal(req.Data(), &request)
+	if err != nil {
+		return request, err
+	}
+
+	return request, nil
+}
+
+// EncodeMicroJSONResponse is a ResponseEncoder that responds to the micro
+// request with the JSON representation of the response.
+func EncodeMicroJSONResponse[Res any](_ context.Context, req micro.Request, response Res) error {
+	return req.RespondJSON(response)
+}
+
+// EncodeMicroError responds to the micro request with the Nats-Service-Error
+// and Nats-Service-Error-Code headers, which the micro framework counts as an
+// endpoint error in its stats.
+func EncodeMicroError(_ context.Context, req micro.Request, err error) {
+	code := 500
+	if coder, ok := err.(ErrorCoder); ok {
+		code = coder.ErrorCode()
+	}
+
+	req.Error(strconv.Itoa(code), err.Error(), nil) //nolint:errcheck
+}
+
+// MicroEndpointStats is the per-endpoint data reported by MicroStats in the
+// data field of the micro STATS response.
+type MicroEndpointStats struct {
+	NumRequests    int           `json:"num_requests"`
+	NumErrors      int           `json:"num_errors"`
+	LastError      string        `json:"last_error,omitempty"`
+	ProcessingTime time.Duration `json:"processing_time"`
+	MaxLatency     time.Duration `json:"max_latency"`
+}
+
+// MicroStats collects per-endpoint statistics from micro handlers configured
+// with MicroHandlerStats. It is exposed through the micro STATS endpoint by
+// setting StatsHandler as the service config's StatsHandler.
+type MicroStats struct {
+	mu        sync.Mutex
+	endpoints map[string]*MicroEndpointStats
+}
+
+// NewMicroStats constructs an empty MicroStats.
+func NewMicroStats() *MicroStats {
+	return &MicroStats{endpoints: map[string]*MicroEndpointStats{}}
+}
+
+func (s *MicroStats) record(name string, latency time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats, ok := s.endpoints[name]
+	if !ok {
+		stats = &MicroEndpointStats{}
+		s.endpoints[name] = stats
+	}
+
+	stats.NumRequests++
+	stats.ProcessingTime += latency
+
+	if latency > stats.MaxLatency {
+		stats.MaxLatency = latency
+	}
+
+	if err != nil {
+		stats.NumErrors++
+		stats.LastError = err.Error()
+	}
+}
+
+// Endpoint returns a snapshot of the statistics of the named endpoint.
+func (s *MicroStats) Endpoint(name string) MicroEndpointStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stats, ok := s.endpoints[name]; ok {
+		return *stats
+	}
+
+	return MicroEndpointStats{}
+}
+
+// StatsHandler returns a micro.StatsHandler reporting the collected statistics.
+func (s *MicroStats) StatsHandler() micro.StatsHandler {
+	return func(endpoint *micro.Endpoint) any {
+		return s.Endpoint(endpoint.Name)
+	}
+}
+
+// microCall records the outcome of a request into stats, once.
+type microCall struct {
+	stats *MicroStats
+	name  string
+	start time.Time
+	done  bool
+}
+
+func (c *microCall) record(err error) {
+	if c.done {
+		return
+	}
+
+	c.done = true
+	c.stats.record(c.name, time.Since(c.start), err)
+}
+
+// microStatsRequest records the outcome of the request before responding to it.
+type microStatsRequest struct {
+	micro.Request
+	call *microCall
+}
+
+func (r microStatsRequest) Respond(data []byte, opts ...micro.RespondOpt) error {
+	r.call.record(nil)
+	return r.Request.Respond(data, opts...)
+}
+
+func (r microStatsRequest) RespondJSON(data any, opts ...micro.RespondOpt) error {
+	r.call.record(nil)
+	return r.Request.RespondJSON(data, opts...)
+}
+
+func (r microStatsRequest) Error(code, description string, data []byte, opts ...micro.RespondOpt) error {
+	r.call.record(errors.New(description))
+	return r.Request.Error(code, description, data, opts...)
+}
@@ -0,0 +1,180 @@
+//go:build unit
+
+package nats_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	natstransport "github.com/kikihakiem/gkit/transport/nats"
+	"github.com/nats-io/nats.go/micro"
+)
+
+type codedError struct{}
+
+func (codedError) Error() string  { return "not found" }
+func (codedError) ErrorCode() int { return 404 }
+
+func TestMicroHandler(t *testing.T) {
+	nc, stop := newNATSConn(t)
+	defer stop()
+
+	stats := natstransport.NewMicroStats()
+
+	svc, err := micro.AddService(nc, micro.Config{
+		Name:         "greeter",
+		Version:      "1.0.0",
+		StatsHandler: stats.StatsHandler(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svc.Stop() //nolint:errcheck
+
+	handler := natstransport.NewMicroHandler(
+		func(_ context.Context, name string) (string, error) {
+			if name == "" {
+				return "", codedError{}
+			}
+
+			return "hello " + name, nil
+		},
+		natstransport.DecodeMicroJSONRequest[string],
+		natstransport.EncodeMicroJSONResponse[string],
+		natstransport.MicroHandlerStats[string, string](stats, "greet"),
+	)
+
+	err = svc.AddGroup("greeter", micro.WithGroupQueueGroup("greeters")).AddEndpoint("greet", handler,
+		micro.WithEndpointMetadata(map[string]string{"format": "json"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := nc.Request("greeter.greet", []byte(`"world"`), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := `"hello world"`, string(reply.Data); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+
+	reply, err = nc.Request("greeter.greet", []byte(`""`), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := "404", reply.Header.Get(micro.ErrorCodeHeader); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+
+	if want, have := "not found", reply.Header.Get(micro.ErrorHeader); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+
+	info := svc.Info()
+	if want, have := "json", info.Endpoints[0].Metadata["format"]; want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+
+	if want, have := "greeters", info.Endpoints[0].QueueGroup; want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+
+	var data natstransport.MicroEndpointStats
+	if err := json.Unmarshal(svc.Stats().Endpoints[0].Data, &data); err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := 2, data.NumRequests; want != have {
+		t.Errorf("want %d requests, have %d", want, have)
+	}
+
+	if want, have := 1, data.NumErrors; want != have {
+		t.Errorf("want %d errors, have %d", want, have)
+	}
+}
+
+// microRequestMock records the responses sent to it.
+type microRequestMock struct {
+	micro.Request
+	data string
+}
+
+func (r *microRequestMock) Data() []byte { return []byte(r.data) }
+
+func (r *microRequestMock) RespondJSON(any, ...micro.RespondOpt) error { return nil }
+
+func TestMicroHandlerStatsOriginalRequest(t *testing.T) {
+	var (
+		req   = &microRequestMock{data: `"world"`}
+		stats = natstransport.NewMicroStats()
+		seen  []micro.Request
+	)
+
+	natstransport.NewMicroHandler(
+		func(_ context.Context, name string) (string, error) { return "hello " + name, nil },
+		func(ctx context.Context, r micro.Request) (string, error) {
+			seen = append(seen, r)
+			return natstransport.DecodeMicroJSONRequest[string](ctx, r)
+		},
+		natstransport.EncodeMicroJSONResponse[string],
+		natstransport.MicroHandlerStats[string, string](stats, "greet"),
+		natstransport.MicroHandlerBefore[string, string](func(ctx context.Context, r micro.Request) context.Context {
+			seen = append(seen, r)
+			return ctx
+		}),
+		natstransport.MicroHandlerFinalizer[string, string](func(_ context.Context, r micro.Request, _ error) {
+			seen = append(seen, r)
+		}),
+	).Handle(req)
+
+	if want, have := 3, len(seen); want != have {
+		t.Fatalf("want %d calls, have %d", want, have)
+	}
+
+	for _, r := range seen {
+		if r != micro.Request(req) {
+			t.Errorf("want the original request, have %T", r)
+		}
+	}
+
+	if want, have := 1, stats.Endpoint("greet").NumRequests; want != have {
+		t.Errorf("want %d requests, have %d", want, have)
+	}
+}
+
+func TestEncodeMicroErrorDefaultCode(t *testing.T) {
+	nc, stop := newNATSConn(t)
+	defer stop()
+
+	svc, err := micro.AddService(nc, micro.Config{
+		Name:    "failing",
+		Version: "1.0.0",
+		Endpoint: &micro.EndpointConfig{
+			Subject: "natstransport.micro",
+			Handler: natstransport.NewMicroHandler(
+				func(context.Context, string) (string, error) { return "", errors.New("dang") },
+				natstransport.DecodeMicroJSONRequest[string],
+				natstransport.EncodeMicroJSONResponse[string],
+			),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svc.Stop() //nolint:errcheck
+
+	reply, err := nc.Request("natstransport.micro", []byte(`"x"`), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := "500", reply.Header.Get(micro.ErrorCodeHeader); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
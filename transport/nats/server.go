@@ -0,0 +1,189 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	"github.com/nats-io/nats.go"
+)
+
+// Server wraps an endpoint and provides nats.MsgHandler.
+type Server[Req, Res any] struct {
+	e            gkit.Endpoint[Req, Res]
+	dec          gkit.EncodeDecodeFunc[*nats.Msg, Req]
+	enc          gkit.ResponseEncoder[*nats.Msg, Res]
+	before       []gkit.BeforeRequestFunc[*nats.Msg]
+	after        []gkit.AfterResponseFunc[Res]
+	errorEncoder gkit.ErrorEncoder[*nats.Msg]
+	finalizer    []gkit.FinalizerFunc[*nats.Msg]
+	errorHandler gkit.ErrorHandler
+}
+
+// NewServer constructs a new server, which provides nats.MsgHandler and wraps
+// the provided endpoint.
+func NewServer[Req, Res any](
+	e gkit.Endpoint[Req, Res],
+	dec gkit.EncodeDecodeFunc[*nats.Msg, Req],
+	enc gkit.ResponseEncoder[*nats.Msg, Res],
+	options ...ServerOption[Req, Res],
+) *Server[Req, Res] {
+	s := &Server[Req, Res]{
+		e:            e,
+		dec:          dec,
+		enc:          enc,
+		errorEncoder: EncodeJSONError,
+		errorHandler: gkit.LogErrorHandler(nil),
+	}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	return s
+}
+
+// NewMsgHandler constructs a new nats.MsgHandler and wraps the provided endpoint.
+func NewMsgHandler[Req, Res any](
+	e gkit.Endpoint[Req, Res],
+	dec gkit.EncodeDecodeFunc[*nats.Msg, Req],
+	enc gkit.ResponseEncoder[*nats.Msg, Res],
+	options ...ServerOption[Req, Res],
+) nats.MsgHandler {
+	return NewServer(e, dec, enc, options...).ServeMsg
+}
+
+// ServerOption sets an optional parameter for servers.
+type ServerOption[Req, Res any] gkit.Option[*Server[Req, Res]]
+
+// ServerBefore functions are executed on the NATS request message before the
+// request is decoded.
+func ServerBefore[Req, Res any](before ...gkit.BeforeRequestFunc[*nats.Msg]) ServerOption[Req, Res] {
+	return func(s *Server[Req, Res]) { s.before = append(s.before, before...) }
+}
+
+// ServerAfter functions are executed on the response after the endpoint is
+// invoked, but before anything is published to the reply subject.
+func ServerAfter[Req, Res any](after ...gkit.AfterResponseFunc[Res]) ServerOption[Req, Res] {
+	return func(s *Server[Req, Res]) { s.after = append(s.after, after...) }
+}
+
+// ServerErrorEncoder is used to encode errors to the reply subject whenever
+// they're encountered in the processing of a request. Clients can use this to
+// provide custom error formatting. By default, errors will be published with
+// EncodeJSONError.
+func ServerErrorEncoder[Req, Res any](encoder gkit.ErrorEncoder[*nats.Msg]) ServerOption[Req, Res] {
+	return func(s *Server[Req, Res]) { s.errorEncoder = encoder }
+}
+
+// ServerErrorHandler is used to handle non-terminal errors. By default, non-terminal errors
+// are logged. This is intended as a diagnostic measure. Finer-grained control
+// of error handling, including logging in more detail, should be performed in a
+// custom ServerErrorEncoder which has access to the context.
+func ServerErrorHandler[Req, Res any](errorHandler gkit.ErrorHandler) ServerOption[Req, Res] {
+	return func(s *Server[Req, Res]) { s.errorHandler = errorHandler }
+}
+
+// ServerFinalizer is executed at the end of every request.
+// By default, no finalizer is registered.
+func ServerFinalizer[Req, Res any](finalizerFunc ...gkit.FinalizerFunc[*nats.Msg]) ServerOption[Req, Res] {
+	return func(s *Server[Req, Res]) { s.finalizer = append(s.finalizer, finalizerFunc...) }
+}
+
+// Subscribe registers the server as the handler of the subject.
+func (s Server[Req, Res]) Subscribe(nc *nats.Conn, subject string) (*nats.Subscription, error) {
+	return nc.Subscribe(subject, s.ServeMsg)
+}
+
+// QueueSubscribe registers the server as the handler of the subject within a
+// queue group, so that every request is handled by a single member of the group.
+func (s Server[Req, Res]) QueueSubscribe(nc *nats.Conn, subject, queue string) (*nats.Subscription, error) {
+	return nc.QueueSubscribe(subject, queue, s.ServeMsg)
+}
+
+// ServeMsg provides nats.MsgHandler.
+func (s Server[Req, Res]) ServeMsg(msg *nats.Msg) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var err error
+
+	if len(s.finalizer) > 0 {
+		defer func() {
+			for _, f := range s.finalizer {
+				f(ctx, msg, err)
+			}
+		}()
+	}
+
+	for _, f := range s.before {
+		ctx = f(ctx, msg)
+	}
+
+	request, err := s.dec(ctx, msg)
+	if err != nil {
+		s.errorHandler.Handle(ctx, err)
+		s.errorEncoder(ctx, msg, err)
+
+		return
+	}
+
+	response, err := s.e(ctx, request)
+	if err != nil {
+		s.errorHandler.Handle(ctx, err)
+		s.errorEncoder(ctx, msg, err)
+
+		return
+	}
+
+	for _, f := range s.after {
+		ctx = f(ctx, response, err)
+	}
+
+	err = s.enc(ctx, msg, response)
+	if err != nil {
+		s.errorHandler.Handle(ctx, err)
+		s.errorEncoder(ctx, msg, err)
+
+		return
+	}
+}
+
+// DecodeJSONRequest is a DecodeRequestFunc that deserialize JSON to domain object.
+func DecodeJSONRequest[Req any](_ context.Context, msg *nats.Msg) (Req, error) {
+	var req Req
+
+	err := json.Unmarshal(msg.Data, &req)
+	if err != nil {
+		return req, err
+	}
+
+	return req, nil
+}
+
+// EncodeJSONResponse is a ResponseEncoder that serializes the response as a
+// JSON object and publishes it to the reply subject of the request. Many
+// JSON-over-NATS services can use it as a sensible default.
+func EncodeJSONResponse[Res any](_ context.Context, msg *nats.Msg, response Res) error {
+	b, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+
+	return msg.Respond(b)
+}
+
+// ErrResponse is the JSON body published by EncodeJSONError.
+type ErrResponse struct {
+	Error string `json:"err"`
+}
+
+// EncodeJSONError publishes the error to the reply subject of the request.
+func EncodeJSONError(_ context.Context, msg *nats.Msg, err error) {
+	b, err := json.Marshal(ErrResponse{Error: err.Error()})
+	if err != nil {
+		return
+	}
+
+	msg.Respond(b) //nolint:errcheck
+}
@@ -0,0 +1,180 @@
+//go:build unit
+
+package nats_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	gkit "github.com/kikihakiem/gkit/core"
+	natstransport "github.com/kikihakiem/gkit/transport/nats"
+	"github.com/nats-io/nats.go"
+)
+
+type emptyStruct struct{}
+
+func TestServerBadDecode(t *testing.T) {
+	nc, stop := newNATSConn(t)
+	defer stop()
+
+	server := natstransport.NewServer(
+		gkit.NopEndpoint[emptyStruct, emptyStruct],
+		func(context.Context, *nats.Msg) (emptyStruct, error) { return emptyStruct{}, errors.New("dang") },
+		natstransport.EncodeJSONResponse[emptyStruct],
+	)
+
+	if _, err := server.Subscribe(nc, "natstransport.test"); err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := nc.Request("natstransport.test", []byte("test data"), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := `{"err":"dang"}`, string(reply.Data); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
+
+func TestServerBadEndpoint(t *testing.T) {
+	nc, stop := newNATSConn(t)
+	defer stop()
+
+	errChan := make(chan error, 1)
+
+	server := natstransport.NewServer(
+		func(context.Context, emptyStruct) (emptyStruct, error) { return emptyStruct{}, errors.New("dang") },
+		gkit.NopEncoderDecoder,
+		natstransport.EncodeJSONResponse[emptyStruct],
+		natstransport.ServerErrorHandler[emptyStruct, emptyStruct](gkit.ErrorHandlerFunc(func(_ context.Context, err error) {
+			errChan <- err
+		})),
+	)
+
+	if _, err := server.Subscribe(nc, "natstransport.test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := nc.Request("natstransport.test", []byte("test data"), time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := "dang", (<-errChan).Error(); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
+
+func TestServerErrorEncoder(t *testing.T) {
+	nc, stop := newNATSConn(t)
+	defer stop()
+
+	server := natstransport.NewServer(
+		func(context.Context, emptyStruct) (emptyStruct, error) { return emptyStruct{}, errors.New("dang") },
+		gkit.NopEncoderDecoder,
+		natstransport.EncodeJSONResponse[emptyStruct],
+		natstransport.ServerErrorEncoder[emptyStruct, emptyStruct](func(_ context.Context, msg *nats.Msg, err error) {
+			msg.Respond([]byte("custom " + err.Error())) //nolint:errcheck
+		}),
+	)
+
+	if _, err := server.Subscribe(nc, "natstransport.test"); err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := nc.Request("natstransport.test", []byte("test data"), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := "custom dang", string(reply.Data); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
+
+func TestServerHappyPath(t *testing.T) {
+	nc, stop := newNATSConn(t)
+	defer stop()
+
+	var (
+		beforeCalled    bool
+		afterCalled     bool
+		finalizerCalled = make(chan error, 1)
+	)
+
+	server := natstransport.NewServer(
+		func(_ context.Context, req string) (string, error) { return req + " bar", nil },
+		natstransport.DecodeJSONRequest[string],
+		natstransport.EncodeJSONResponse[string],
+		natstransport.ServerBefore[string, string](func(ctx context.Context, _ *nats.Msg) context.Context {
+			beforeCalled = true
+			return ctx
+		}),
+		natstransport.ServerAfter[string](func(ctx context.Context, _ string, _ error) context.Context {
+			afterCalled = true
+			return ctx
+		}),
+		natstransport.ServerFinalizer[string, string](func(_ context.Context, _ *nats.Msg, err error) {
+			finalizerCalled <- err
+		}),
+	)
+
+	if _, err := server.Subscribe(nc, "natstransport.test"); err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := nc.Request("natstransport.test", []byte(`"foo"`), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, have := `"foo bar"`, string(reply.Data); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+
+	if err := <-finalizerCalled; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if !beforeCalled || !afterCalled {
+		t.Errorf("want before and after called, have %v and %v", beforeCalled, afterCalled)
+	}
+}
+
+func TestServerQueueSubscribe(t *testing.T) {
+	nc, stop := newNATSConn(t)
+	defer stop()
+
+	handled := make(chan string, 2)
+
+	for _, name := range []string{"first", "second"} {
+		name := name
+
+		server := natstransport.NewServer(
+			func(context.Context, emptyStruct) (emptyStruct, error) {
+				handled <- name
+				return emptyStruct{}, nil
+			},
+			gkit.NopEncoderDecoder,
+			natstransport.EncodeJSONResponse[emptyStruct],
+		)
+
+		if _, err := server.QueueSubscribe(nc, "natstransport.test", "workers"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := nc.Request("natstransport.test", nil, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	<-handled
+
+	select {
+	case name := <-handled:
+		t.Errorf("want request handled once, also handled by %s", name)
+	case <-time.After(100 * time.Millisecond):
+	}
+}